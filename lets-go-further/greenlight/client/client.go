@@ -0,0 +1,199 @@
+// Package client is a Go client for the greenlight API.
+//
+// A Client sends requests to the API on behalf of a single user, identified by the
+// authentication token in its Token field:
+//
+//	c := client.New("https://greenlight.example.com", "")
+//
+//	token, err := c.CreateAuthenticationToken(ctx, "alice@example.com", "pa55word")
+//	if err != nil {
+//		// ...
+//	}
+//	c.Token = token.Plaintext
+//
+//	movie, err := c.GetMovie(ctx, 1)
+//
+// Movies are sent and received in their /v2 representation, where the runtime is a
+// number of minutes. Errors from the API are returned as an *Error, which can be
+// compared with the sentinel errors in this package using errors.Is(), such as
+// errors.Is(err, client.ErrEditConflict). Requests which are rate limited are retried
+// automatically after the delay that the API asks for. Sign ins which are refused after
+// too many failed attempts are not retried, as retrying would only extend the delay.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Define a Client struct to hold the settings for talking to the API. The fields can be
+// changed after New() returns, but not while requests are being made.
+type Client struct {
+	// BaseURL is the scheme and host of the API, such as "https://greenlight.example.com".
+	BaseURL string
+
+	// Token is the authentication token sent with each request as a bearer token, or ""
+	// to make requests as an anonymous user.
+	Token string
+
+	// HTTPClient is used to send the requests.
+	HTTPClient *http.Client
+
+	// MaxRetries is how many times a rate limited request is retried before its *Error is
+	// returned. Set it to 0 to turn off retries.
+	MaxRetries int
+
+	// MinRetryWait is how long the client waits before the first retry when the API
+	// doesn't send a Retry-After header. The wait doubles with each retry after that.
+	MinRetryWait time.Duration
+
+	// MaxRetryWait is the longest the client will wait before retrying a rate limited
+	// request. If the API asks for a longer wait, the error is returned straight away.
+	MaxRetryWait time.Duration
+}
+
+// New returns a Client for the API at baseURL, which authenticates with the given token
+// (or "" to make requests as an anonymous user).
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimRight(baseURL, "/"),
+		Token:        token,
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:   3,
+		MinRetryWait: time.Second,
+		MaxRetryWait: time.Minute,
+	}
+}
+
+// envelope is the JSON object which the API wraps its responses in, such as
+// {"movie": {...}}.
+type envelope map[string]json.RawMessage
+
+// do sends a request to the API, encoding input (if it isn't nil) as the JSON request
+// body. A successful response's envelope is returned, and an error response is returned
+// as an *Error. A rate limited response is retried after its Retry-After delay (or an
+// increasing backoff if it doesn't have one), up to MaxRetries times.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, input interface{}) (envelope, int, error) {
+	var body []byte
+
+	if input != nil {
+		var err error
+
+		body, err = json.Marshal(input)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		env, status, err := c.send(ctx, method, path, query, body)
+		if err != nil {
+			// Only the rate limiter's responses are retried. A 429 with another code, such
+			// as too_many_login_attempts, won't go away by sending the request again.
+			apiErr, ok := err.(*Error)
+			if !ok || apiErr.Code != ErrRateLimited.Code || attempt >= c.MaxRetries {
+				return nil, status, err
+			}
+
+			wait := apiErr.RetryAfter
+			if wait == 0 {
+				wait = c.MinRetryWait << attempt
+			}
+
+			if wait > c.MaxRetryWait {
+				return nil, status, err
+			}
+
+			// The request wasn't carried out, so it is safe to send it again once the
+			// rate limit allows, even if it isn't idempotent.
+			timer := time.NewTimer(wait)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, status, ctx.Err()
+			case <-timer.C:
+			}
+
+			continue
+		}
+
+		return env, status, nil
+	}
+}
+
+// send makes a single attempt at a request.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body []byte) (envelope, int, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	// Responses are small, so limit how much is read in case the URL points somewhere
+	// other than the API.
+	data, err := io.ReadAll(io.LimitReader(res.Body, 10<<20))
+	if err != nil {
+		return nil, res.StatusCode, err
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return nil, res.StatusCode, newError(res, data)
+	}
+
+	var env envelope
+
+	err = json.Unmarshal(data, &env)
+	if err != nil {
+		return nil, res.StatusCode, fmt.Errorf("client: decoding %s %s response: %w", method, path, err)
+	}
+
+	return env, res.StatusCode, nil
+}
+
+// decode decodes the value with the given key in a response envelope into dst.
+func (env envelope) decode(key string, dst interface{}) error {
+	raw, ok := env[key]
+	if !ok {
+		return fmt.Errorf("client: response has no %q field", key)
+	}
+
+	err := json.Unmarshal(raw, dst)
+	if err != nil {
+		return fmt.Errorf("client: decoding %q: %w", key, err)
+	}
+
+	return nil
+}
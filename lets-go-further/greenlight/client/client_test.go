@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         string
+		retryAfter   string
+		failures     int
+		wantRequests int
+		wantErr      error
+	}{
+		{"Rate limited", "rate_limited", "1", 1, 2, nil},
+		{"Rate limited without Retry-After", "rate_limited", "", 2, 3, nil},
+		{"Too many retries", "rate_limited", "", 5, 4, ErrRateLimited},
+		{"Retry-After too long", "rate_limited", "120", 1, 1, ErrRateLimited},
+		{"Too many login attempts", "too_many_login_attempts", "1", 1, 1, ErrTooManyLoginAttempts},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+
+				if requests <= tt.failures {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.Header().Set("Content-Type", "application/problem+json")
+					w.WriteHeader(http.StatusTooManyRequests)
+					fmt.Fprintf(w, `{"status": 429, "code": %q, "detail": "slow down"}`, tt.code)
+					return
+				}
+
+				fmt.Fprint(w, `{"authentication_token": {"token": "TOKEN", "expiry": "2024-01-01T12:00:00Z"}}`)
+			}))
+			defer ts.Close()
+
+			c := New(ts.URL, "")
+			c.MinRetryWait = 10 * time.Millisecond
+
+			_, err := c.CreateAuthenticationToken(context.Background(), "alice@example.com", "pa55word")
+
+			assert.Equal(t, requests, tt.wantRequests)
+			if tt.wantErr == nil {
+				assert.NilError(t, err)
+			} else {
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Define the sentinel errors which an *Error can be compared with using errors.Is().
+// Each one matches the error code that the API sends in its responses.
+var (
+	ErrNotFound                   = &Error{Code: "not_found"}
+	ErrBadRequest                 = &Error{Code: "bad_request"}
+	ErrValidationFailed           = &Error{Code: "validation_failed"}
+	ErrEditConflict               = &Error{Code: "edit_conflict"}
+	ErrRateLimited                = &Error{Code: "rate_limited"}
+	ErrInvalidCredentials         = &Error{Code: "invalid_credentials"}
+	ErrTooManyLoginAttempts       = &Error{Code: "too_many_login_attempts"}
+	ErrAccountLocked              = &Error{Code: "account_locked"}
+	ErrInvalidAuthenticationToken = &Error{Code: "invalid_authentication_token"}
+	ErrAuthenticationRequired     = &Error{Code: "authentication_required"}
+	ErrInactiveAccount            = &Error{Code: "inactive_account"}
+	ErrNotPermitted               = &Error{Code: "not_permitted"}
+	ErrIdempotencyKeyReused       = &Error{Code: "idempotency_key_reused"}
+	ErrIdempotencyKeyInProgress   = &Error{Code: "idempotency_key_in_progress"}
+)
+
+// An Error is an error response from the API.
+type Error struct {
+	// Status is the HTTP status code of the response.
+	Status int
+
+	// Code is the machine-readable error code, such as "edit_conflict". Switch on this
+	// (or compare the error with the sentinel errors) rather than the Message.
+	Code string
+
+	// Message is the human-readable description of the error.
+	Message string
+
+	// Fields holds the error message for each invalid field, keyed by its name (such as
+	// "title" or "genres[1]"), when the request failed validation.
+	Fields map[string]string
+
+	// RetryAfter is how long the API asked the client to wait before trying again, for
+	// rate limited and locked out requests.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if len(e.Fields) == 0 {
+		return fmt.Sprintf("greenlight: %s (%d %s)", e.Message, e.Status, e.Code)
+	}
+
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]string, len(names))
+	for i, name := range names {
+		fields[i] = name + ": " + e.Fields[name]
+	}
+
+	return fmt.Sprintf("greenlight: %s: %s (%d %s)", e.Message, strings.Join(fields, "; "), e.Status, e.Code)
+}
+
+// Is reports whether an error has the same code as target, so that errors.Is(err,
+// client.ErrEditConflict) works.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// legacyCodes are the error codes for the responses of an API running with legacy
+// errors, which only have a message, worked out from their status codes.
+var legacyCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusForbidden:           "not_permitted",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "edit_conflict",
+	http.StatusUnprocessableEntity: "validation_failed",
+	http.StatusLocked:              "account_locked",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "server_error",
+}
+
+// newError decodes an error response. The API sends RFC 7807 problem details, unless it
+// is running with legacy errors, when the body is {"error": message}.
+func newError(res *http.Response, body []byte) *Error {
+	e := &Error{Status: res.StatusCode}
+
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	var problem struct {
+		Code   string `json:"code"`
+		Detail string `json:"detail"`
+		Errors []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Error json.RawMessage `json:"error"`
+	}
+
+	err := json.Unmarshal(body, &problem)
+	if err != nil {
+		e.Code = legacyCodes[res.StatusCode]
+		e.Message = http.StatusText(res.StatusCode)
+		return e
+	}
+
+	e.Code = problem.Code
+	e.Message = problem.Detail
+
+	if len(problem.Errors) > 0 {
+		e.Fields = make(map[string]string, len(problem.Errors))
+		for _, fe := range problem.Errors {
+			e.Fields[fe.Field] = fe.Message
+		}
+	}
+
+	// A legacy error's message is either a string or a map of field errors.
+	if e.Code == "" {
+		e.Code = legacyCodes[res.StatusCode]
+
+		if json.Unmarshal(problem.Error, &e.Message) != nil && json.Unmarshal(problem.Error, &e.Fields) == nil {
+			e.Message = "one or more fields failed validation"
+		}
+	}
+
+	if e.Message == "" {
+		e.Message = http.StatusText(res.StatusCode)
+	}
+
+	return e
+}
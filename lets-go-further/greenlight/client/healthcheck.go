@@ -0,0 +1,41 @@
+package client
+
+import (
+	"context"
+	"net/http"
+)
+
+// Health is the status of the API.
+type Health struct {
+	Status      string // "available" when the API is working
+	Environment string
+	Version     string
+}
+
+// Healthcheck returns the status of the API. It doesn't need authentication.
+func (c *Client) Healthcheck(ctx context.Context) (*Health, error) {
+	env, _, err := c.do(ctx, http.MethodGet, "/v1/healthcheck", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var health Health
+	var info struct {
+		Environment string `json:"environment"`
+		Version     string `json:"version"`
+	}
+
+	err = env.decode("status", &health.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	err = env.decode("system_info", &info)
+	if err != nil {
+		return nil, err
+	}
+
+	health.Environment, health.Version = info.Environment, info.Version
+
+	return &health, nil
+}
@@ -0,0 +1,246 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Movie is a movie as the API returns it.
+type Movie struct {
+	ID        int64      `json:"id"`
+	Title     string     `json:"title"`
+	Year      int32      `json:"year"`
+	Runtime   int32      `json:"runtime"` // In minutes
+	Genres    []string   `json:"genres"`
+	Version   int32      `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at"` // Only set for movies in the trash
+}
+
+// MovieInput holds the fields of a new movie.
+type MovieInput struct {
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime int32    `json:"runtime"` // In minutes
+	Genres  []string `json:"genres"`
+}
+
+// MovieUpdate holds the fields of a movie to change. Only the fields which aren't nil
+// are changed.
+type MovieUpdate struct {
+	Title   *string  `json:"title,omitempty"`
+	Year    *int32   `json:"year,omitempty"`
+	Runtime *int32   `json:"runtime,omitempty"` // In minutes
+	Genres  []string `json:"genres,omitempty"`
+}
+
+// Pagination describes a page of a list.
+type Pagination struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+// ListMoviesOptions filters and sorts a list of movies. The zero value lists every movie
+// in ID order, using the API's default page size.
+type ListMoviesOptions struct {
+	Title    string   // Only movies whose title contains these words
+	Genres   []string // Only movies which have all of these genres
+	Sort     string   // id, title, year or runtime, with a - prefix for descending order
+	Page     int      // The page to start from (1 if 0)
+	PageSize int      // The number of movies on each page, up to 100
+}
+
+func (opts ListMoviesOptions) query() url.Values {
+	q := url.Values{}
+
+	if opts.Title != "" {
+		q.Set("title", opts.Title)
+	}
+	if len(opts.Genres) > 0 {
+		q.Set("genres", strings.Join(opts.Genres, ","))
+	}
+	if opts.Sort != "" {
+		q.Set("sort", opts.Sort)
+	}
+	if opts.Page > 0 {
+		q.Set("page", strconv.Itoa(opts.Page))
+	}
+	if opts.PageSize > 0 {
+		q.Set("page_size", strconv.Itoa(opts.PageSize))
+	}
+
+	return q
+}
+
+// moviePath returns the path of a movie, or of one of its sub-resources.
+func moviePath(id int64, parts ...string) string {
+	return "/v2/movies/" + strings.Join(append([]string{strconv.FormatInt(id, 10)}, parts...), "/")
+}
+
+// decodeMovie decodes the movie in a response envelope.
+func decodeMovie(env envelope) (*Movie, error) {
+	var movie Movie
+
+	err := env.decode("movie", &movie)
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
+
+// CreateMovie adds a new movie. It needs the movies:write permission.
+func (c *Client) CreateMovie(ctx context.Context, input MovieInput) (*Movie, error) {
+	env, _, err := c.do(ctx, http.MethodPost, "/v2/movies", nil, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMovie(env)
+}
+
+// GetMovie returns a movie by its ID. It needs the movies:read permission.
+func (c *Client) GetMovie(ctx context.Context, id int64) (*Movie, error) {
+	env, _, err := c.do(ctx, http.MethodGet, moviePath(id), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMovie(env)
+}
+
+// UpdateMovie changes the fields of a movie which are set in update, and returns the
+// updated movie. It needs the movies:write permission. If the movie is changed by
+// another request at the same time, the error matches ErrEditConflict, and the update
+// can be tried again.
+func (c *Client) UpdateMovie(ctx context.Context, id int64, update MovieUpdate) (*Movie, error) {
+	env, _, err := c.do(ctx, http.MethodPatch, moviePath(id), nil, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMovie(env)
+}
+
+// DeleteMovie moves a movie to the trash. It needs the movies:write permission.
+func (c *Client) DeleteMovie(ctx context.Context, id int64) error {
+	_, _, err := c.do(ctx, http.MethodDelete, moviePath(id), nil, nil)
+	return err
+}
+
+// RestoreMovie takes a movie back out of the trash. It needs the movies:write
+// permission.
+func (c *Client) RestoreMovie(ctx context.Context, id int64) (*Movie, error) {
+	env, _, err := c.do(ctx, http.MethodPost, moviePath(id, "restore"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMovie(env)
+}
+
+// ListMovies returns a single page of movies, and the pagination details for the list.
+// It needs the movies:read permission. Use Movies() to go through every page.
+func (c *Client) ListMovies(ctx context.Context, opts ListMoviesOptions) ([]*Movie, Pagination, error) {
+	env, _, err := c.do(ctx, http.MethodGet, "/v2/movies", opts.query(), nil)
+	if err != nil {
+		return nil, Pagination{}, err
+	}
+
+	var movies []*Movie
+	var pagination Pagination
+
+	err = env.decode("movies", &movies)
+	if err != nil {
+		return nil, Pagination{}, err
+	}
+
+	err = env.decode("metadata", &pagination)
+	if err != nil {
+		return nil, Pagination{}, err
+	}
+
+	return movies, pagination, nil
+}
+
+// Movies returns an iterator over every movie in a list, starting from opts.Page, which
+// fetches the pages as they are needed:
+//
+//	it := c.Movies(client.ListMoviesOptions{Genres: []string{"drama"}})
+//	for it.Next(ctx) {
+//		movie := it.Movie()
+//		// ...
+//	}
+//	if err := it.Err(); err != nil {
+//		// ...
+//	}
+//
+// Movies which are added or deleted while the list is being read can shift the pages,
+// so a movie may be skipped or seen twice.
+func (c *Client) Movies(opts ListMoviesOptions) *MovieIterator {
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+
+	return &MovieIterator{client: c, opts: opts}
+}
+
+// A MovieIterator goes through the pages of a list of movies. It isn't safe to use from
+// more than one goroutine at once.
+type MovieIterator struct {
+	client     *Client
+	opts       ListMoviesOptions
+	page       []*Movie
+	current    *Movie
+	pagination Pagination
+	done       bool
+	err        error
+}
+
+// Next moves to the next movie, fetching the next page if needed. It returns false once
+// there are no more movies, or a page can't be fetched, which Err() reports.
+func (it *MovieIterator) Next(ctx context.Context) bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			it.current = nil
+			return false
+		}
+
+		it.page, it.pagination, it.err = it.client.ListMovies(ctx, it.opts)
+		if it.err != nil {
+			it.current = nil
+			return false
+		}
+
+		// The last page has been fetched once the current page reaches it (or the list
+		// is empty, when the last page is 0).
+		it.done = it.pagination.CurrentPage >= it.pagination.LastPage || len(it.page) == 0
+		it.opts.Page++
+	}
+
+	it.current, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+// Movie returns the current movie.
+func (it *MovieIterator) Movie() *Movie {
+	return it.current
+}
+
+// Pagination returns the pagination details of the most recently fetched page, which
+// include the total number of movies in the list.
+func (it *MovieIterator) Pagination() Pagination {
+	return it.pagination
+}
+
+// Err returns the error which stopped the iterator, if any.
+func (it *MovieIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+// The client is tested against the real handlers in cmd/api. These tests cover the
+// responses which need a database, using a stand-in server.
+
+func TestMovieIterator(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		assert.Equal(t, r.URL.Query().Get("genres"), "drama,war")
+
+		// Five movies, two to a page.
+		var movies string
+		for id := page*2 - 1; id <= page*2 && id <= 5; id++ {
+			if movies != "" {
+				movies += ","
+			}
+			movies += fmt.Sprintf(`{"id": %d, "title": "Movie %d", "runtime": 100, "genres": ["drama", "war"], "version": 1, "created_at": "2024-01-01T12:00:00Z", "deleted_at": null}`, id, id)
+		}
+
+		fmt.Fprintf(w, `{"movies": [%s], "metadata": {"current_page": %d, "page_size": 2, "first_page": 1, "last_page": 3, "total_records": 5}}`, movies, page)
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL, "")
+	it := c.Movies(ListMoviesOptions{Genres: []string{"drama", "war"}, PageSize: 2})
+
+	var ids []int64
+	for it.Next(context.Background()) {
+		ids = append(ids, it.Movie().ID)
+	}
+
+	assert.NilError(t, it.Err())
+	assert.Equal(t, fmt.Sprint(ids), "[1 2 3 4 5]")
+	assert.Equal(t, it.Pagination().TotalRecords, 5)
+
+	// An empty list stops straight away.
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"movies": [], "metadata": {"current_page": 1, "page_size": 20, "first_page": 1, "last_page": 0, "total_records": 0}}`)
+	}))
+	defer empty.Close()
+
+	it = New(empty.URL, "").Movies(ListMoviesOptions{})
+	assert.Equal(t, it.Next(context.Background()), false)
+	assert.NilError(t, it.Err())
+}
+
+func TestUpdateMovieEditConflict(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Method, http.MethodPatch)
+		assert.Equal(t, r.URL.Path, "/v2/movies/1")
+		assert.Equal(t, r.Header.Get("Authorization"), "Bearer TOKEN")
+
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"type": "urn:greenlight:problem:edit_conflict", "title": "Conflict", "status": 409, "code": "edit_conflict", "detail": "unable to update the record due to an edit conflict, please try again", "instance": "/v2/movies/1"}`)
+	}))
+	defer ts.Close()
+
+	title := "Casablanca"
+
+	_, err := New(ts.URL, "TOKEN").UpdateMovie(context.Background(), 1, MovieUpdate{Title: &title})
+	assert.Equal(t, errors.Is(err, ErrEditConflict), true)
+	assert.Equal(t, errors.Is(err, ErrNotFound), false)
+	assert.Equal(t, err.Error(), "greenlight: unable to update the record due to an edit conflict, please try again (409 edit_conflict)")
+}
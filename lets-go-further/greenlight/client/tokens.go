@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// A Token is a token issued by the API. Only its plaintext is sent to the client.
+type Token struct {
+	Plaintext string    `json:"token"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// A TOTPRequiredError is returned by CreateAuthenticationToken() when the user has
+// two-factor authentication turned on. The challenge token is exchanged for an
+// authentication token with CreateTOTPAuthenticationToken(), along with a code from the
+// user's authenticator app.
+type TOTPRequiredError struct {
+	ChallengeToken *Token
+}
+
+func (e *TOTPRequiredError) Error() string {
+	return "greenlight: a two-factor authentication code is required"
+}
+
+// CreateAuthenticationToken signs a user in with their email address and password, and
+// returns an authentication token for the Client's Token field. If the user has
+// two-factor authentication turned on, a *TOTPRequiredError is returned instead.
+func (c *Client) CreateAuthenticationToken(ctx context.Context, email, password string) (*Token, error) {
+	input := map[string]string{"email": email, "password": password}
+
+	env, status, err := c.do(ctx, http.MethodPost, "/v1/tokens/authentication", nil, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+
+	if status == http.StatusAccepted {
+		err = env.decode("challenge_token", &token)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, &TOTPRequiredError{ChallengeToken: &token}
+	}
+
+	err = env.decode("authentication_token", &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// CreateTOTPAuthenticationToken completes a two-factor sign in, exchanging the challenge
+// token from a *TOTPRequiredError and a code from the user's authenticator app for an
+// authentication token. Set recovery to true to send one of the user's recovery codes
+// in place of an authenticator code.
+func (c *Client) CreateTOTPAuthenticationToken(ctx context.Context, challengeToken, code string, recovery bool) (*Token, error) {
+	input := map[string]string{"challenge_token": challengeToken}
+
+	if recovery {
+		input["recovery_code"] = code
+	} else {
+		input["code"] = code
+	}
+
+	env, _, err := c.do(ctx, http.MethodPost, "/v1/tokens/authentication/totp", nil, input)
+	if err != nil {
+		return nil, err
+	}
+
+	var token Token
+
+	err = env.decode("authentication_token", &token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// A User is a user account as the API returns it.
+type User struct {
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	Activated    bool      `json:"activated"`
+	Locale       string    `json:"locale"`
+	PendingEmail *string   `json:"pending_email"` // Set while an email change is unconfirmed
+}
+
+// RegisterUserInput holds the details of a new user. The Locale is optional, and
+// defaults to "en".
+type RegisterUserInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Locale   string `json:"locale,omitempty"`
+}
+
+// UserUpdate holds the fields of the current user to change. Only the fields which
+// aren't nil are changed. CurrentPassword is needed to change the password.
+type UserUpdate struct {
+	Name            *string `json:"name,omitempty"`
+	Locale          *string `json:"locale,omitempty"`
+	Password        *string `json:"password,omitempty"`
+	CurrentPassword string  `json:"current_password,omitempty"`
+}
+
+// decodeUser decodes the user in a response envelope.
+func decodeUser(env envelope) (*User, error) {
+	var user User
+
+	err := env.decode("user", &user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// RegisterUser creates a new user, and the API emails them an activation token. The
+// user can't sign in until ActivateUser() is called with the token.
+func (c *Client) RegisterUser(ctx context.Context, input RegisterUserInput) (*User, error) {
+	env, _, err := c.do(ctx, http.MethodPost, "/v1/users", nil, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeUser(env)
+}
+
+// ActivateUser activates a user with the token from their activation email.
+func (c *Client) ActivateUser(ctx context.Context, token string) (*User, error) {
+	env, _, err := c.do(ctx, http.MethodPut, "/v1/users/activated", nil, map[string]string{"token": token})
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeUser(env)
+}
+
+// CurrentUser returns the user that the client is authenticated as.
+func (c *Client) CurrentUser(ctx context.Context) (*User, error) {
+	env, _, err := c.do(ctx, http.MethodGet, "/v1/users/me", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeUser(env)
+}
+
+// UpdateCurrentUser changes the details of the user that the client is authenticated
+// as.
+func (c *Client) UpdateCurrentUser(ctx context.Context, update UserUpdate) (*User, error) {
+	env, _, err := c.do(ctx, http.MethodPatch, "/v1/users/me", nil, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeUser(env)
+}
+
+// DeleteCurrentUser deletes the account of the user that the client is authenticated
+// as, after checking their password.
+func (c *Client) DeleteCurrentUser(ctx context.Context, password string) error {
+	_, _, err := c.do(ctx, http.MethodDelete, "/v1/users/me", nil, map[string]string{"password": password})
+	return err
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+// loginFailureKeys returns the keys which failed sign in attempts are counted under: one
+// for the account (whether or not it exists, so that the responses don't give away which
+// email addresses are registered) and one for the client's IP address.
+func loginFailureKeys(r *http.Request, email string) (accountKey string, ipKey string, err error) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return "", "", err
+	}
+
+	return accountFailureKey(email), "ip:" + ip, nil
+}
+
+// accountFailureKey returns the key which failed sign in attempts for an account are
+// counted under. Email addresses are case-insensitive, so the key is too.
+func accountFailureKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// checkLoginBlocked sends an error response and returns true if sign in attempts for the
+// account or IP address are currently blocked.
+func (app *application) checkLoginBlocked(w http.ResponseWriter, r *http.Request, accountKey, ipKey string) bool {
+	for _, key := range []string{accountKey, ipKey} {
+		failure, err := app.models.AuthFailures.Get(key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return true
+		}
+
+		if blocked, remaining := failure.Blocked(); blocked {
+			if failure.Locked && key == accountKey {
+				app.accountLockedResponse(w, r, remaining)
+			} else {
+				app.tooManyLoginAttemptsResponse(w, r, remaining)
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordLoginFailure counts a failed sign in attempt against the account and the IP
+// address. Once either has failed delayAfter times, each further failure blocks attempts
+// for a delay which doubles every time, and once it has failed lockoutAfter times it is
+// locked out. The user (which is nil if the account doesn't exist) is emailed when their
+// account is locked.
+func (app *application) recordLoginFailure(user *data.User, accountKey, ipKey string) error {
+	cfg := app.config.auth
+
+	account, err := app.models.AuthFailures.RecordFailure(accountKey, cfg.window)
+	if err != nil {
+		return err
+	}
+
+	// The record returned by RecordFailure() still holds the block from before this
+	// failure, so it tells us whether the account was already locked out.
+	blocked, _ := account.Blocked()
+	wasLocked := blocked && account.Locked
+
+	locked, err := app.blockAfterFailure(account, cfg.delayAfter, cfg.lockoutAfter)
+	if err != nil {
+		return err
+	}
+
+	// Only send the email when the account becomes locked, rather than for every
+	// attempt made while it is locked.
+	if locked && !wasLocked && user != nil {
+		err = app.models.Jobs.Enqueue(data.JobSendEmail, data.EmailJob{
+			Recipient: user.Email,
+			Locale:    user.Locale,
+			Template:  "account_locked.tmpl",
+			Data: map[string]interface{}{
+				"failures":       account.Failures,
+				"lockoutMinutes": int(cfg.lockoutDuration.Minutes()),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	ip, err := app.models.AuthFailures.RecordFailure(ipKey, cfg.window)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.blockAfterFailure(ip, cfg.ipDelayAfter, cfg.ipLockoutAfter)
+	return err
+}
+
+// checkCurrentPassword checks the password which a signed in user has sent to confirm a
+// change to their account. Wrong passwords count towards the same delays and lockout as
+// failed sign ins, so that a stolen authentication token can't be used to guess the
+// password, and no password is checked at all while the account or IP address is
+// blocked. If ok is false, an error response has already been sent. Otherwise match
+// reports whether the password was right, and the caller sends its own response if not.
+func (app *application) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user *data.User, plaintext string) (match bool, ok bool) {
+	accountKey, ipKey, err := loginFailureKeys(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false, false
+	}
+
+	if app.checkLoginBlocked(w, r, accountKey, ipKey) {
+		return false, false
+	}
+
+	match, err = user.Password.Matches(plaintext)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false, false
+	}
+
+	if !match {
+		err = app.recordLoginFailure(user, accountKey, ipKey)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false, false
+		}
+	}
+
+	return match, true
+}
+
+// blockAfterFailure applies a delay or lockout to failure, depending on how many times it
+// has failed, and reports whether it is now locked out.
+func (app *application) blockAfterFailure(failure *data.AuthFailure, delayAfter, lockoutAfter int) (bool, error) {
+	cfg := app.config.auth
+
+	switch {
+	case lockoutAfter > 0 && failure.Failures >= lockoutAfter:
+		return true, app.models.AuthFailures.Block(failure.Key, time.Now().Add(cfg.lockoutDuration), true)
+
+	case delayAfter > 0 && failure.Failures >= delayAfter:
+		delay := backoff(cfg.delay, failure.Failures-delayAfter+1)
+		if delay > cfg.maxDelay {
+			delay = cfg.maxDelay
+		}
+
+		return false, app.models.AuthFailures.Block(failure.Key, time.Now().Add(delay), false)
+	}
+
+	return false, nil
+}
+
+// The cleanupAuthFailures() method starts a background worker which removes failed
+// sign in records that have expired, so that the table doesn't keep growing during a
+// credential stuffing attack spread across many IP addresses.
+func (app *application) cleanupAuthFailures() {
+	app.worker(time.Hour, func() {
+		deleted, err := app.models.AuthFailures.DeleteExpired(app.config.auth.window)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		if deleted > 0 {
+			app.logger.PrintInfo("deleted expired sign in failures", map[string]string{
+				"count": strconv.FormatInt(deleted, 10),
+			})
+		}
+	})
+}
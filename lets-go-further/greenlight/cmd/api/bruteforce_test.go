@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+func TestRecordLoginFailureLockoutEmail(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int64
+		blocked   interface{}
+		locked    bool
+		wantEmail bool
+	}{
+		{
+			name:     "Below the lockout",
+			failures: 2,
+		},
+		{
+			name:      "Reaches the lockout",
+			failures:  3,
+			wantEmail: true,
+		},
+		{
+			name:      "Passes the lockout without having been locked",
+			failures:  5,
+			wantEmail: true,
+		},
+		{
+			name:     "Already locked",
+			failures: 4,
+			blocked:  time.Now().Add(time.Hour),
+			locked:   true,
+		},
+		{
+			name:      "Locked again after the lockout has expired",
+			failures:  4,
+			blocked:   time.Now().Add(-time.Minute),
+			locked:    true,
+			wantEmail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// RecordFailure() returns the count after this failure, along with the block
+			// from before it.
+			db := &testDB{answer: func(query string, args []driver.Value) ([][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "INSERT INTO auth_failures"):
+					if args[0] == "ip:192.0.2.1" {
+						return [][]driver.Value{{int64(1), nil, false}}, nil
+					}
+					return [][]driver.Value{{tt.failures, tt.blocked, tt.locked}}, nil
+
+				case strings.Contains(query, "UPDATE auth_failures"), strings.Contains(query, "INSERT INTO jobs"):
+					return nil, nil
+				}
+
+				return nil, fmt.Errorf("unexpected query: %s", query)
+			}}
+
+			app := newTestApplication(t)
+			app.models = data.NewModels(db.open(t))
+			app.config.auth.lockoutAfter = 3
+			app.config.auth.lockoutDuration = 15 * time.Minute
+			app.config.auth.window = time.Hour
+
+			user := &data.User{Email: "alice@example.com", Locale: data.DefaultLocale}
+
+			err := app.recordLoginFailure(user, accountFailureKey(user.Email), "ip:192.0.2.1")
+			assert.NilError(t, err)
+
+			jobs := db.executed("INSERT INTO jobs")
+			assert.Equal(t, len(jobs) == 1, tt.wantEmail)
+
+			if tt.wantEmail {
+				assert.StringContains(t, jobs[0].args[1].(string), "account_locked.tmpl")
+			}
+		})
+	}
+}
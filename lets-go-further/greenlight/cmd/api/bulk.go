@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com.go-learning.greenlight/internal/bulk"
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	// The mode query string parameter controls what happens when some rows are rejected.
+	// In "partial" mode the valid rows are still inserted, while in "atomic" mode the
+	// whole import is carried out in a single transaction which is only committed if
+	// every row was accepted.
+	mode := app.readString(r.URL.Query(), "mode", "partial")
+	v.Check(validator.In(mode, "partial", "atomic"), "mode", "must be either partial or atomic")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Rather than reading the whole body into memory with readJSON(), we pick a row
+	// reader based on the Content-Type header and decode the body one row at a time.
+	var readRows func(io.Reader, bulk.RowFunc) error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case contentTypeNDJSON, "application/ndjson":
+		readRows = bulk.ReadNDJSON
+		if app.contextGetAPIVersion(r) == apiV2 {
+			readRows = readNDJSONMoviesV2
+		}
+	case contentTypeCSV:
+		readRows = bulk.ReadCSV
+	default:
+		app.unsupportedMediaTypeResponse(w, r, contentTypeNDJSON, contentTypeCSV)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, app.config.bulk.importMaxBytes)
+
+	report := &bulk.Report{Mode: mode, Rows: []bulk.Row{}}
+
+	importer := &bulk.Importer{
+		UserID:    app.contextGetUser(r).ID,
+		Atomic:    mode == "atomic",
+		BatchSize: app.config.bulk.importBatchSize,
+		MaxRows:   app.config.bulk.importReportRows,
+		Report:    report,
+	}
+
+	var err error
+
+	if importer.Atomic {
+		err = app.models.Transaction(func(tx data.Models) error {
+			importer.Models = tx
+			return importer.Run(body, readRows)
+		})
+	} else {
+		importer.Models = app.models
+		err = importer.Run(body, readRows)
+	}
+
+	// Outside of a transaction each batch is committed as soon as it is inserted, so
+	// in partial mode the accepted rows are kept even if the body was cut short.
+	var importErr *bulk.BodyError
+	report.Committed = err == nil || (!importer.Atomic && errors.As(err, &importErr))
+
+	if err != nil {
+		switch {
+		// In atomic mode a rejected row rolls back the import, and the report tells the
+		// client which rows need fixing.
+		case errors.Is(err, bulk.ErrRejected):
+			err = app.writeResponse(w, r, http.StatusUnprocessableEntity, envelope{"import": report}, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+			}
+		// If the body itself couldn't be read we send a 400 Bad Request, along with the
+		// report so far so that the client knows which rows were already imported.
+		case errors.As(err, &importErr):
+			app.problemResponse(w, r, http.StatusBadRequest, codeImportFailed, importErr.Error(), envelope{"import": report})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// moviesCSV returns a function which gives the CSV rows for a list of movies, for the
+// writeList() helper.
+func moviesCSV(movies []*data.Movie) func() [][]string {
+	return func() [][]string {
+		rows := [][]string{bulk.CSVHeader}
+
+		for _, movie := range movies {
+			rows = append(rows, bulk.CSVRecord(movie))
+		}
+
+		return rows
+	}
+}
+
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	// The export accepts the same title and genres filters as listMoviesHandler, but has
+	// no pagination: every matching movie is sent, in ID order.
+	qs := r.URL.Query()
+	title := app.readString(qs, "title", "")
+	genres := app.readCSV(qs, "genres", []string{})
+
+	// The output format is chosen from the Accept header, defaulting to NDJSON.
+	format := app.negotiate(r, contentTypeNDJSON, contentTypeCSV)
+	if format == "" {
+		app.notAcceptableResponse(w, r, contentTypeNDJSON, contentTypeCSV)
+		return
+	}
+
+	// The ResponseWriter is wrapped by the metrics middleware, but httpsnoop preserves
+	// the http.Flusher interface so this assertion holds for the real server.
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("response writer does not support flushing"))
+		return
+	}
+
+	// The movies are represented for the API version of the route, like the other movie
+	// responses.
+	exporter := newMovieExporter(w, flusher, format, app.contextGetAPIVersion(r))
+
+	// Nothing is written to the client until the first movie has been fetched, so that
+	// we can still send a proper error response if the export fails to start.
+	started := false
+
+	start := func() error {
+		w.Header().Set("Content-Type", format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "movies."+exporter.extension))
+		w.WriteHeader(http.StatusOK)
+		started = true
+
+		return exporter.writeHeader()
+	}
+
+	batchSize := app.config.bulk.exportBatchSize
+	written := 0
+
+	// The request context is canceled when the client disconnects, which makes the
+	// next FETCH fail and rolls back the transaction holding the cursor.
+	err := app.models.Transaction(func(tx data.Models) error {
+		return tx.Movies.Export(r.Context(), title, genres, batchSize, func(movie *data.Movie) error {
+			if !started {
+				err := start()
+				if err != nil {
+					return err
+				}
+			}
+
+			err := exporter.writeMovie(movie)
+			if err != nil {
+				return err
+			}
+
+			// Flush after every batch, so that the client receives the export as it is
+			// read from the database rather than in one go at the end.
+			written++
+			if written%batchSize == 0 {
+				exporter.flush()
+			}
+
+			return nil
+		})
+	})
+
+	switch {
+	// A client going away part way through is expected, so we don't log it as an error.
+	case r.Context().Err() != nil:
+		return
+	case err != nil && !started:
+		app.serverErrorResponse(w, r, err)
+		return
+	case err != nil:
+		// Once the status code has been sent there is no way to report the error to
+		// the client, so we just log it and stop.
+		app.logError(r, err)
+		return
+	}
+
+	// An export with no matching movies still gets a successful (empty) response.
+	if !started {
+		err = start()
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+	}
+
+	exporter.flush()
+}
+
+// movieExporter writes the movies of an export in one of the supported formats.
+type movieExporter struct {
+	extension   string
+	writeHeader func() error
+	writeMovie  func(*data.Movie) error
+	flush       func()
+}
+
+// newMovieExporter returns a movieExporter which writes to w in the given format (CSV or
+// NDJSON), representing the NDJSON movies for an API version.
+func newMovieExporter(w io.Writer, flusher http.Flusher, format string, version apiVersion) movieExporter {
+	switch format {
+	case contentTypeCSV:
+		cw := csv.NewWriter(w)
+
+		// The header uses the same column names as the import endpoint, so that an
+		// export can be imported again as-is.
+		return movieExporter{
+			extension: "csv",
+			writeHeader: func() error {
+				cw.Write(bulk.CSVHeader)
+				return cw.Error()
+			},
+			writeMovie: func(movie *data.Movie) error {
+				cw.Write(bulk.CSVRecord(movie))
+				return cw.Error()
+			},
+			// The csv.Writer has its own buffer, which needs flushing before the
+			// ResponseWriter's.
+			flush: func() {
+				cw.Flush()
+				flusher.Flush()
+			},
+		}
+	default:
+		enc := json.NewEncoder(w)
+
+		return movieExporter{
+			extension:   "ndjson",
+			writeHeader: func() error { return nil },
+			writeMovie:  func(movie *data.Movie) error { return enc.Encode(represent(version, movie)) },
+			flush:       flusher.Flush,
+		}
+	}
+}
+
+// readNDJSONMoviesV2 works in the same way as bulk.ReadNDJSON(), but each line has the
+// shape of the POST /v2/movies request body. The created_at and deleted_at fields are
+// also accepted and ignored, so that a v2 export can be imported as-is.
+func readNDJSONMoviesV2(body io.Reader, fn bulk.RowFunc) error {
+	return bulk.ReadNDJSONConverted(body, fn, func(raw []byte) ([]byte, error) {
+		return movieBodyFromV2(raw, "created_at", "deleted_at")
+	})
+}
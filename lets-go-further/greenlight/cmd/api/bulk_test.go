@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/bulk"
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+func TestMovieExporter(t *testing.T) {
+	movies := []*data.Movie{
+		{ID: 1, CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama", "romance"}, Version: 1},
+		{ID: 2, CreatedAt: time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), Title: "Vertigo, Again", Year: 1958, Runtime: 128, Genres: []string{"thriller"}, Version: 3},
+	}
+
+	tests := []struct {
+		name          string
+		format        string
+		version       apiVersion
+		readRows      func(io.Reader, bulk.RowFunc) error
+		wantExtension string
+		wantBody      string
+	}{
+		{
+			name:          "CSV",
+			format:        contentTypeCSV,
+			version:       apiV1,
+			readRows:      bulk.ReadCSV,
+			wantExtension: "csv",
+			wantBody: "id,title,year,runtime,genres,version\n" +
+				"1,Casablanca,1942,102,\"drama,romance\",1\n" +
+				"2,\"Vertigo, Again\",1958,128,thriller,3\n",
+		},
+		{
+			name:          "NDJSON v1",
+			format:        contentTypeNDJSON,
+			version:       apiV1,
+			readRows:      bulk.ReadNDJSON,
+			wantExtension: "ndjson",
+			wantBody: `{"id":1,"title":"Casablanca","year":1942,"runtime":"102 mins","genres":["drama","romance"],"version":1}` + "\n" +
+				`{"id":2,"title":"Vertigo, Again","year":1958,"runtime":"128 mins","genres":["thriller"],"version":3}` + "\n",
+		},
+		{
+			name:          "NDJSON v2",
+			format:        contentTypeNDJSON,
+			version:       apiV2,
+			readRows:      readNDJSONMoviesV2,
+			wantExtension: "ndjson",
+			wantBody: `{"id":1,"title":"Casablanca","year":1942,"runtime":102,"genres":["drama","romance"],"version":1,"created_at":"2024-01-01T12:00:00Z","deleted_at":null}` + "\n" +
+				`{"id":2,"title":"Vertigo, Again","year":1958,"runtime":128,"genres":["thriller"],"version":3,"created_at":"2024-01-02T12:00:00Z","deleted_at":null}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			exporter := newMovieExporter(rr, rr, tt.format, tt.version)
+			assert.Equal(t, exporter.extension, tt.wantExtension)
+
+			assert.NilError(t, exporter.writeHeader())
+			for _, movie := range movies {
+				assert.NilError(t, exporter.writeMovie(movie))
+			}
+			exporter.flush()
+
+			assert.Equal(t, rr.Body.String(), tt.wantBody)
+			assert.Equal(t, rr.Flushed, true)
+
+			// An export can be imported again as-is.
+			var imported []string
+
+			err := tt.readRows(strings.NewReader(tt.wantBody), func(line int, movie *data.Movie, rowErr error) error {
+				assert.NilError(t, rowErr)
+				imported = append(imported, fmt.Sprintf("%s %d %d %q", movie.Title, movie.Year, movie.Runtime, movie.Genres))
+				return nil
+			})
+			assert.NilError(t, err)
+			assert.Equal(t, strings.Join(imported, "\n"), "Casablanca 1942 102 [\"drama\" \"romance\"]\nVertigo, Again 1958 128 [\"thriller\"]")
+		})
+	}
+}
+
+func TestExportMoviesNotAcceptable(t *testing.T) {
+	app := newTestApplication(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies/export", nil)
+	r.Header.Set("Accept", "application/json")
+	r = app.contextSetAPIVersion(r, apiV1)
+
+	rr := httptest.NewRecorder()
+	app.exportMoviesHandler(rr, r)
+
+	assert.Equal(t, rr.Code, http.StatusNotAcceptable)
+}
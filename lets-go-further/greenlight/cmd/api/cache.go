@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// cachedResponse is a response recorded from a handler, so that it can be sent again
+// without running the handler.
+type cachedResponse struct {
+	key      string
+	status   int
+	header   http.Header
+	body     []byte
+	storedAt time.Time
+
+	// refreshing is set while one request is running the handler to replace a stale
+	// response, so that other requests keep being sent the stale one in the meantime
+	// rather than all running the handler at once.
+	refreshing bool
+}
+
+// responseCache is a bounded LRU cache of responses. A response is fresh for ttl after it
+// was stored, and then stale for a further staleTTL, during which it is still sent to
+// every request except the one which refreshes it.
+//
+// Invalidating the cache empties it and increments the generation. A request records the
+// generation when it starts, and its response is only stored if the generation is the
+// same when it finishes, so a response built from data read before a write can't be
+// cached after the write has invalidated the cache.
+type responseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	staleTTL   time.Duration
+	generation uint64
+	entries    map[string]*list.Element
+	lru        *list.List
+
+	// Counters for the expvar metrics.
+	hits          int64
+	misses        int64
+	staleServes   int64
+	stores        int64
+	evictions     int64
+	invalidations int64
+}
+
+func newResponseCache(maxEntries int, ttl, staleTTL time.Duration) *responseCache {
+	return &responseCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		staleTTL:   staleTTL,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// lookup returns the response to send for key, and whether it is stale, or nil if the
+// caller should run the handler and store the result. It also returns the current
+// generation, to pass to store().
+func (c *responseCache) lookup(key string) (*cachedResponse, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false, c.generation
+	}
+
+	resp := elem.Value.(*cachedResponse)
+	age := time.Since(resp.storedAt)
+
+	switch {
+	case age < c.ttl:
+		c.hits++
+		c.lru.MoveToFront(elem)
+		return resp, false, c.generation
+
+	case age < c.ttl+c.staleTTL && resp.refreshing:
+		c.staleServes++
+		c.lru.MoveToFront(elem)
+		return resp, true, c.generation
+
+	case age < c.ttl+c.staleTTL:
+		// This request refreshes the stale response.
+		resp.refreshing = true
+		c.misses++
+		return nil, false, c.generation
+
+	default:
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		c.misses++
+		return nil, false, c.generation
+	}
+}
+
+// store adds a response to the cache, unless the cache has been invalidated since
+// generation. The least recently used responses are evicted to keep the cache within
+// maxEntries.
+func (c *responseCache) store(resp *cachedResponse, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	resp.storedAt = time.Now()
+
+	if elem, ok := c.entries[resp.key]; ok {
+		elem.Value = resp
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[resp.key] = c.lru.PushFront(resp)
+	}
+
+	c.stores++
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+		c.evictions++
+	}
+}
+
+// abandon is called when a request which was refreshing a stale response didn't get a
+// response worth caching, so that the next request tries again.
+func (c *responseCache) abandon(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cachedResponse).refreshing = false
+	}
+}
+
+// invalidate empties the cache.
+func (c *responseCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.invalidations++
+}
+
+// stats returns the cache's counters for the expvar handler.
+func (c *responseCache) stats() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]int64{
+		"entries":       int64(c.lru.Len()),
+		"hits":          c.hits,
+		"misses":        c.misses,
+		"stale_serves":  c.staleServes,
+		"stores":        c.stores,
+		"evictions":     c.evictions,
+		"invalidations": c.invalidations,
+	}
+}
+
+// cacheRecorder is a ResponseWriter which records a response instead of sending it.
+type cacheRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *cacheRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	return rec.body.Write(b)
+}
+
+// writeCachedResponse sends a recorded response to the client. The X-Cache header says
+// whether it came from the cache (HIT), came from the cache but is being refreshed
+// (STALE), or was just generated (MISS).
+func writeCachedResponse(w http.ResponseWriter, resp *cachedResponse, status string) {
+	// The cached header values are shared by every request, so they're copied rather
+	// than letting later middleware append to them. Vary is merged with the values
+	// which the outer middleware has already set (such as Origin and Authorization)
+	// rather than replacing them.
+	for key, value := range resp.header {
+		if key == "Vary" {
+			for _, v := range value {
+				addVary(w.Header(), v)
+			}
+			continue
+		}
+
+		w.Header()[key] = append([]string(nil), value...)
+	}
+
+	w.Header().Set("X-Cache", status)
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// addVary adds the header names in value to the Vary header, leaving out any which are
+// already listed.
+func addVary(h http.Header, value string) {
+	listed := map[string]bool{}
+
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			listed[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+
+	for _, name := range strings.Split(value, ",") {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name != "" && !listed[name] {
+			h.Add("Vary", name)
+			listed[name] = true
+		}
+	}
+}
+
+// responseCacheKey builds the cache key for a request from its path, its query string
+// (with the parameters sorted, so that the order they're given in doesn't matter), the
+// Accept header (which picks the response format) and the caller's permissions.
+func (app *application) responseCacheKey(r *http.Request) string {
+	qs := r.URL.Query()
+	for _, values := range qs {
+		sort.Strings(values)
+	}
+
+	permissions := append([]string(nil), app.contextGetPermissions(r)...)
+	sort.Strings(permissions)
+
+	return strings.Join([]string{
+		r.URL.Path,
+		url.Values(qs).Encode(),
+		r.Header.Get("Accept"),
+		strings.Join(permissions, ","),
+	}, "\n")
+}
+
+// The cacheResponse() middleware serves successful responses from the movie response
+// cache. It must be wrapped by requirePermission(), which puts the caller's permissions
+// in the request context.
+func (app *application) cacheResponse(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.cache.enabled {
+			next(w, r)
+			return
+		}
+
+		key := app.responseCacheKey(r)
+
+		cached, stale, generation := app.movieCache.lookup(key)
+		if cached != nil {
+			status := "HIT"
+			if stale {
+				status = "STALE"
+			}
+
+			writeCachedResponse(w, cached, status)
+			return
+		}
+
+		rec := &cacheRecorder{header: http.Header{}}
+		next(rec, r)
+
+		// A handler which doesn't write anything sends an empty 200 OK response, just as
+		// it would with a real ResponseWriter.
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		resp := &cachedResponse{
+			key:    key,
+			status: rec.status,
+			header: rec.header,
+			body:   rec.body.Bytes(),
+		}
+
+		// Only successful responses are cached, so that an error (such as the database
+		// timing out) isn't sent to everyone else as well.
+		if resp.status == http.StatusOK {
+			app.movieCache.store(resp, generation)
+		} else {
+			app.movieCache.abandon(key)
+		}
+
+		writeCachedResponse(w, resp, "MISS")
+	}
+}
+
+// The invalidatesMovieCache() middleware empties the movie response cache when a
+// request which changes movies succeeds. The cache is invalidated as the status code is
+// written, which is after the change has been committed but before the client sees the
+// response, so a client can't read its own change back and get an out of date response.
+func (app *application) invalidatesMovieCache(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var once sync.Once
+
+		invalidate := func(status int) {
+			once.Do(func() {
+				if status < http.StatusBadRequest {
+					app.movieCache.invalidate()
+				}
+			})
+		}
+
+		// httpsnoop keeps the interfaces implemented by the ResponseWriter, such as
+		// http.Flusher, which the streaming import endpoint needs.
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(status int) {
+					invalidate(status)
+					next(status)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					invalidate(http.StatusOK)
+					return next(b)
+				}
+			},
+		})
+
+		next(w, r)
+	}
+}
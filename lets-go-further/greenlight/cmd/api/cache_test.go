@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+func TestCacheResponseEmptyHandler(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.cache.enabled = true
+
+	// A handler which writes nothing at all.
+	h := app.cacheResponse(func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, want := range []string{"MISS", "HIT"} {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+		assert.Equal(t, rr.Code, http.StatusOK)
+		assert.Equal(t, rr.Header().Get("X-Cache"), want)
+		assert.Equal(t, rr.Body.Len(), 0)
+	}
+}
+
+func TestCacheResponseVary(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.cache.enabled = true
+
+	// The handler varies on Accept (as writeResponse() does), while the outer middleware
+	// has already set Vary: Origin and Vary: Authorization.
+	h := app.cacheResponse(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		w.Header().Add("Vary", "Origin")
+	})
+
+	for _, want := range []string{"MISS", "HIT"} {
+		rr := httptest.NewRecorder()
+		rr.Header().Add("Vary", "Origin")
+		rr.Header().Add("Vary", "Authorization")
+
+		h(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+		assert.Equal(t, rr.Header().Get("X-Cache"), want)
+		assert.Equal(t, strings.Join(rr.Header().Values("Vary"), ", "), "Origin, Authorization, Accept")
+	}
+}
+
+// lookupKeys returns the keys which lookup() finds a response for, in order.
+func lookupKeys(c *responseCache, keys ...string) []string {
+	var found []string
+
+	for _, key := range keys {
+		if resp, _, _ := c.lookup(key); resp != nil {
+			found = append(found, key)
+		}
+	}
+
+	return found
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	tests := []struct {
+		name          string
+		steps         func(c *responseCache)
+		wantKeys      string
+		wantEvictions int64
+	}{
+		{
+			name: "Within the limit",
+			steps: func(c *responseCache) {
+				c.store(&cachedResponse{key: "a"}, 0)
+				c.store(&cachedResponse{key: "b"}, 0)
+			},
+			wantKeys: "[a b]",
+		},
+		{
+			name: "Oldest evicted",
+			steps: func(c *responseCache) {
+				c.store(&cachedResponse{key: "a"}, 0)
+				c.store(&cachedResponse{key: "b"}, 0)
+				c.store(&cachedResponse{key: "c"}, 0)
+			},
+			wantKeys:      "[b c]",
+			wantEvictions: 1,
+		},
+		{
+			name: "Lookup makes an entry recently used",
+			steps: func(c *responseCache) {
+				c.store(&cachedResponse{key: "a"}, 0)
+				c.store(&cachedResponse{key: "b"}, 0)
+				c.lookup("a")
+				c.store(&cachedResponse{key: "c"}, 0)
+			},
+			wantKeys:      "[a c]",
+			wantEvictions: 1,
+		},
+		{
+			name: "Storing again replaces the entry",
+			steps: func(c *responseCache) {
+				c.store(&cachedResponse{key: "a"}, 0)
+				c.store(&cachedResponse{key: "b"}, 0)
+				c.store(&cachedResponse{key: "a"}, 0)
+				c.store(&cachedResponse{key: "c"}, 0)
+			},
+			wantKeys:      "[a c]",
+			wantEvictions: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newResponseCache(2, time.Minute, 0)
+			tt.steps(c)
+
+			assert.Equal(t, fmt.Sprint(lookupKeys(c, "a", "b", "c")), tt.wantKeys)
+			assert.Equal(t, c.stats()["entries"], int64(2))
+			assert.Equal(t, c.stats()["evictions"], tt.wantEvictions)
+		})
+	}
+}
+
+func TestResponseCacheGeneration(t *testing.T) {
+	c := newResponseCache(10, time.Minute, 0)
+
+	_, _, generation := c.lookup("a")
+	c.store(&cachedResponse{key: "a"}, generation)
+
+	// A request which started before the cache was invalidated finishes afterwards. Its
+	// response may be out of date, so it isn't stored.
+	_, _, before := c.lookup("b")
+	c.invalidate()
+	c.store(&cachedResponse{key: "b"}, before)
+
+	assert.Equal(t, len(lookupKeys(c, "a", "b")), 0)
+	assert.Equal(t, c.stats()["invalidations"], int64(1))
+
+	// A request which starts after the invalidation is stored as usual.
+	_, _, after := c.lookup("b")
+	assert.Equal(t, after, before+1)
+
+	c.store(&cachedResponse{key: "b"}, after)
+	assert.Equal(t, fmt.Sprint(lookupKeys(c, "a", "b")), "[b]")
+}
+
+func TestResponseCacheStale(t *testing.T) {
+	c := newResponseCache(10, time.Minute, time.Minute)
+
+	c.store(&cachedResponse{key: "a"}, 0)
+
+	resp, stale, _ := c.lookup("a")
+	assert.Equal(t, resp != nil, true)
+	assert.Equal(t, stale, false)
+
+	// Age the response past its ttl. The first request runs the handler to refresh it,
+	// while the others are sent the stale response in the meantime.
+	resp.storedAt = time.Now().Add(-90 * time.Second)
+
+	resp, _, _ = c.lookup("a")
+	assert.Equal(t, resp == nil, true)
+
+	resp, stale, _ = c.lookup("a")
+	assert.Equal(t, resp != nil, true)
+	assert.Equal(t, stale, true)
+
+	// If the refresh fails, the next request tries again.
+	c.abandon("a")
+
+	resp, _, _ = c.lookup("a")
+	assert.Equal(t, resp == nil, true)
+
+	// Once the stale period is over too, the response is removed.
+	c.abandon("a")
+	c.entries["a"].Value.(*cachedResponse).storedAt = time.Now().Add(-3 * time.Minute)
+
+	resp, _, _ = c.lookup("a")
+	assert.Equal(t, resp == nil, true)
+	assert.Equal(t, c.stats()["entries"], int64(0))
+}
+
+func TestInvalidatesMovieCache(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		wantInvalidate bool
+	}{
+		{"Created", http.StatusCreated, true},
+		{"OK", http.StatusOK, true},
+		{"Validation failed", http.StatusUnprocessableEntity, false},
+		{"Server error", http.StatusInternalServerError, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.movieCache.store(&cachedResponse{key: "a"}, 0)
+
+			h := app.invalidatesMovieCache(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("{}"))
+			})
+
+			h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/movies", nil))
+
+			assert.Equal(t, len(lookupKeys(app.movieCache, "a")) == 0, tt.wantInvalidate)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/client"
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+// These tests run the client package against the real handlers. There is no database in
+// the tests, so they only cover requests which are answered before the database would be
+// used, which is enough to check how responses and errors are decoded.
+
+func TestClientHealthcheck(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	c := client.New(ts.URL, "")
+	c.HTTPClient = ts.Client()
+
+	health, err := c.Healthcheck(context.Background())
+	assert.NilError(t, err)
+	assert.Equal(t, health.Status, "available")
+	assert.Equal(t, health.Environment, "testing")
+	assert.Equal(t, health.Version, version)
+}
+
+func TestClientErrors(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name         string
+		legacyErrors bool
+		token        string
+		call         func(c *client.Client) error
+		wantStatus   int
+		wantErr      error
+		wantFields   map[string]string
+	}{
+		{
+			name: "Authentication required",
+			call: func(c *client.Client) error {
+				_, err := c.CreateMovie(ctx, client.MovieInput{Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama"}})
+				return err
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    client.ErrAuthenticationRequired,
+		},
+		{
+			name:  "Invalid token",
+			token: "too-short",
+			call: func(c *client.Client) error {
+				_, err := c.GetMovie(ctx, 1)
+				return err
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantErr:    client.ErrInvalidAuthenticationToken,
+		},
+		{
+			name: "Validation failed",
+			call: func(c *client.Client) error {
+				_, err := c.RegisterUser(ctx, client.RegisterUserInput{Email: "not-an-email", Password: "correct horse battery staple"})
+				return err
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantErr:    client.ErrValidationFailed,
+			wantFields: map[string]string{
+				"name":  "must be provided",
+				"email": "must be a valid email address",
+			},
+		},
+		{
+			name:         "Legacy validation failed",
+			legacyErrors: true,
+			call: func(c *client.Client) error {
+				_, err := c.CreateAuthenticationToken(ctx, "not-an-email", "")
+				return err
+			},
+			wantStatus: http.StatusUnprocessableEntity,
+			wantErr:    client.ErrValidationFailed,
+			wantFields: map[string]string{
+				"email":    "must be a valid email address",
+				"password": "must be provided",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.legacyErrors = tt.legacyErrors
+			ts := newTestServer(t, app.routes())
+
+			c := client.New(ts.URL, tt.token)
+			c.HTTPClient = ts.Client()
+
+			err := tt.call(c)
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+
+			var apiErr *client.Error
+			assert.Equal(t, errors.As(err, &apiErr), true)
+			assert.Equal(t, apiErr.Status, tt.wantStatus)
+			assert.Equal(t, len(apiErr.Fields), len(tt.wantFields))
+
+			for field, message := range tt.wantFields {
+				assert.Equal(t, apiErr.Fields[field], message)
+			}
+		})
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.rps = 2
+	app.config.limiter.burst = 1
+	ts := newTestServer(t, app.routes())
+
+	c := client.New(ts.URL, "")
+	c.HTTPClient = ts.Client()
+	c.MaxRetries = 0
+
+	ctx := context.Background()
+
+	_, err := c.Healthcheck(ctx)
+	assert.NilError(t, err)
+
+	// Without retries, the second request is refused with the delay until the limiter
+	// allows another, rounded up to a whole second.
+	_, err = c.Healthcheck(ctx)
+	assert.Equal(t, errors.Is(err, client.ErrRateLimited), true)
+
+	var apiErr *client.Error
+	assert.Equal(t, errors.As(err, &apiErr), true)
+	assert.Equal(t, apiErr.RetryAfter, time.Second)
+
+	// With retries, the client waits for the delay and then succeeds.
+	c.MaxRetries = 1
+
+	start := time.Now()
+
+	_, err = c.Healthcheck(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, time.Since(start) >= time.Second, true)
+}
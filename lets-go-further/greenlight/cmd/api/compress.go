@@ -0,0 +1,314 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressionEncoder is a content coding that responses can be compressed with. The
+// encoders are kept in a pool, as each one allocates several hundred KB of state.
+type compressionEncoder struct {
+	name string
+	pool *sync.Pool
+}
+
+// compressionEncoders lists the content codings we support, in order of preference
+// when the client accepts more than one equally. Brotli comes first, as it makes JSON
+// noticeably smaller than gzip does. Its level is kept low, because the higher levels
+// are many times slower than gzip for little extra gain.
+var compressionEncoders = []compressionEncoder{
+	{
+		name: "br",
+		pool: &sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(io.Discard, 4) }},
+	},
+	{
+		name: "gzip",
+		pool: &sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }},
+	},
+	{
+		name: "deflate",
+		pool: &sync.Pool{New: func() interface{} {
+			fw, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+			return fw
+		}},
+	},
+}
+
+// pooledWriter is the interface shared by brotli.Writer, gzip.Writer and flate.Writer.
+type pooledWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// negotiateEncoding picks the encoder which best matches an Accept-Encoding header, or
+// returns nil if the response should be sent uncompressed. A coding with q=0 is refused,
+// and "*" matches any coding which isn't listed separately.
+func negotiateEncoding(header string) *compressionEncoder {
+	if header == "" {
+		return nil
+	}
+
+	qs := map[string]float64{}
+
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			var err error
+
+			q, err = strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				q = 0
+			}
+		}
+
+		qs[name] = q
+	}
+
+	var best *compressionEncoder
+	bestQ := 0.0
+
+	for i, enc := range compressionEncoders {
+		q, ok := qs[enc.name]
+		if !ok {
+			q = qs["*"]
+		}
+
+		if q > bestQ {
+			best, bestQ = &compressionEncoders[i], q
+		}
+	}
+
+	return best
+}
+
+// incompressibleContentType reports whether responses with a content type are already
+// compressed, so that compressing them again would only waste time.
+func incompressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "font/woff"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-bzip2", "application/x-xz", "application/x-7z-compressed":
+		return true
+	}
+
+	return false
+}
+
+// compressResponseWriter compresses a response on its way to the client. The body is
+// buffered until it reaches the minimum size, so that small responses (which compression
+// would barely shrink, or even grow) can be sent as they are, and the decision is made
+// once that size is reached, the handler finishes, or the handler flushes. A flushed
+// response is a stream, such as the movie event stream, so it is compressed whatever its
+// size, and each flush sends everything compressed so far.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoder *compressionEncoder
+	minSize int
+
+	status  int
+	buf     []byte
+	decided bool
+	writer  pooledWriter
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	if cw.decided || cw.status != 0 {
+		return
+	}
+
+	cw.status = status
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+
+		err := cw.decide(true)
+		return len(b), err
+	}
+
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends everything written so far to the client. The ResponseWriter is wrapped by
+// the metrics middleware, but httpsnoop preserves the http.Flusher interface.
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+
+		if cw.decide(true) != nil {
+			return
+		}
+	}
+
+	if cw.writer != nil {
+		if cw.writer.Flush() != nil {
+			return
+		}
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide sends the status code and headers, and the body buffered so far, compressing
+// the response if it is allowed to be compressed and compress is true.
+func (cw *compressResponseWriter) decide(compress bool) error {
+	cw.decided = true
+
+	h := cw.ResponseWriter.Header()
+
+	// Responses which can't have a body, which the handler has already encoded, or which
+	// are of an already compressed type are sent as they are.
+	bodyless := cw.status < 200 || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified
+	incompressible := h.Get("Content-Encoding") != "" || incompressibleContentType(h.Get("Content-Type"))
+
+	if !bodyless && !incompressible {
+		// The response depends on the Accept-Encoding header whether or not we compress
+		// it this time, so caches have to know that.
+		h.Add("Vary", "Accept-Encoding")
+
+		if compress {
+			// Go would otherwise sniff the content type from the compressed bytes, so
+			// sniff it from the uncompressed ones first.
+			if h.Get("Content-Type") == "" {
+				h.Set("Content-Type", http.DetectContentType(cw.buf))
+			}
+
+			h.Set("Content-Encoding", cw.encoder.name)
+			h.Del("Content-Length")
+
+			cw.writer = cw.encoder.pool.Get().(pooledWriter)
+			cw.writer.Reset(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if cw.writer != nil {
+		_, err := cw.writer.Write(buf)
+		return err
+	}
+
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends anything still buffered and finishes the compressed stream, then returns
+// the encoder to its pool.
+func (cw *compressResponseWriter) close() error {
+	if !cw.decided {
+		// The handler didn't write anything at all, or wrote less than the minimum
+		// size, so the response is sent uncompressed.
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+
+		err := cw.decide(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cw.writer == nil {
+		return nil
+	}
+
+	err := cw.writer.Close()
+
+	cw.writer.Reset(io.Discard)
+	cw.encoder.pool.Put(cw.writer)
+	cw.writer = nil
+
+	return err
+}
+
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.compression.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// If the client doesn't accept any of our encodings (or this is a HEAD request,
+		// which has no body to compress), there's nothing to do other than say that the
+		// response would have been different if it had.
+		encoder := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoder == nil || r.Method == http.MethodHead {
+			w.Header().Add("Vary", "Accept-Encoding")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{
+			ResponseWriter: w,
+			encoder:        encoder,
+			minSize:        app.config.compression.minSize,
+		}
+
+		// Close the writer even if the handler panics, so that the pooled encoder isn't
+		// lost. The recoverPanic() middleware is further down the chain, so the panic
+		// has normally already been turned into an error response by this point.
+		defer func() {
+			err := cw.close()
+			if err != nil {
+				app.logError(r, err)
+			}
+		}()
+
+		next.ServeHTTP(cw, r)
+	})
+}
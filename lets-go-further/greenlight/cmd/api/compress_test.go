@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"No header", "", ""},
+		{"Brotli", "br", "br"},
+		{"Gzip", "gzip", "gzip"},
+		{"Deflate", "deflate", "deflate"},
+		{"Browser default", "gzip, deflate, br", "br"},
+		{"Brotli preferred when equal", "gzip, br", "br"},
+		{"Higher q wins", "br;q=0.5, gzip;q=0.8", "gzip"},
+		{"Brotli refused", "br;q=0, gzip", "gzip"},
+		{"Wildcard", "*", "br"},
+		{"Wildcard with brotli refused", "*, br;q=0", "gzip"},
+		{"Identity only", "identity", ""},
+		{"Unknown coding", "zstd", ""},
+		{"Case insensitive", "GZIP", "gzip"},
+		{"Spaces", " gzip ; q=0.5 ", "gzip"},
+		{"Bad q value", "br;q=x, gzip", "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc := negotiateEncoding(tt.header)
+
+			got := ""
+			if enc != nil {
+				got = enc.name
+			}
+
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+// decodeBody decompresses a response body with the given content coding.
+func decodeBody(t *testing.T, encoding string, body []byte) string {
+	var r io.Reader
+
+	switch encoding {
+	case "":
+		return string(body)
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	case "gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		assert.NilError(t, err)
+		r = gz
+	default:
+		t.Fatalf("unexpected Content-Encoding %q", encoding)
+	}
+
+	b, err := io.ReadAll(r)
+	assert.NilError(t, err)
+
+	return string(b)
+}
+
+func TestCompressBrotli(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.compression.enabled = true
+
+	body := strings.Repeat(`{"title": "Casablanca", "year": 1942}`, 100)
+
+	h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate, br")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	assert.Equal(t, rr.Header().Get("Content-Encoding"), "br")
+	assert.Equal(t, rr.Body.Len() < len(body), true)
+	assert.Equal(t, decodeBody(t, "br", rr.Body.Bytes()), body)
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"title": "Casablanca", "year": 1942}`, 100)
+	small := `{"title": "Casablanca"}`
+
+	tests := []struct {
+		name           string
+		disabled       bool
+		method         string
+		acceptEncoding string
+		status         int
+		contentType    string
+		encoded        bool
+		body           string
+		flush          bool
+		wantEncoding   string
+		wantVary       bool
+	}{
+		{name: "Large body", acceptEncoding: "gzip", body: large, wantEncoding: "gzip", wantVary: true},
+		{name: "Small body", acceptEncoding: "gzip", body: small, wantVary: true},
+		{name: "Small body flushed", acceptEncoding: "gzip", body: small, flush: true, wantEncoding: "gzip", wantVary: true},
+		{name: "No Accept-Encoding", body: large, wantVary: true},
+		{name: "Nothing acceptable", acceptEncoding: "zstd", body: large, wantVary: true},
+		{name: "HEAD request", method: http.MethodHead, acceptEncoding: "gzip", body: large, wantVary: true},
+		{name: "Already compressed type", acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "Already encoded", acceptEncoding: "gzip", encoded: true, body: large},
+		{name: "No content", acceptEncoding: "gzip", status: http.StatusNoContent},
+		{name: "Not modified", acceptEncoding: "gzip", status: http.StatusNotModified},
+		{name: "Empty body", acceptEncoding: "gzip", wantVary: true},
+		{name: "Turned off", disabled: true, acceptEncoding: "gzip", body: large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.compression.enabled = !tt.disabled
+
+			contentType := tt.contentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+
+			h := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", contentType)
+				if tt.encoded {
+					w.Header().Set("Content-Encoding", "identity")
+				}
+				if tt.body != "" {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				}
+
+				status := tt.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				w.WriteHeader(status)
+
+				w.Write([]byte(tt.body))
+				if tt.flush {
+					w.(http.Flusher).Flush()
+				}
+			}))
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			r := httptest.NewRequest(method, "/v1/movies", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, r)
+
+			if tt.status != 0 {
+				assert.Equal(t, rr.Code, tt.status)
+			}
+
+			wantVary := 0
+			if tt.wantVary {
+				wantVary = 1
+			}
+			assert.Equal(t, len(rr.Header().Values("Vary")), wantVary)
+
+			if tt.encoded {
+				assert.Equal(t, rr.Header().Get("Content-Encoding"), "identity")
+				return
+			}
+			assert.Equal(t, rr.Header().Get("Content-Encoding"), tt.wantEncoding)
+
+			// The length of the compressed body isn't known in advance, so the handler's
+			// Content-Length has to go.
+			wantLength := ""
+			if tt.body != "" && tt.wantEncoding == "" {
+				wantLength = strconv.Itoa(len(tt.body))
+			}
+			assert.Equal(t, rr.Header().Get("Content-Length"), wantLength)
+
+			assert.Equal(t, decodeBody(t, tt.wantEncoding, rr.Body.Bytes()), tt.body)
+		})
+	}
+}
@@ -15,6 +15,14 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// The permissionsContextKey is the key for the permissions of the user making the
+// request, which the requirePermission() middleware adds to the request context.
+const permissionsContextKey = contextKey("permissions")
+
+// The apiVersionContextKey is the key for the version of the API that a request was
+// made to, which the useAPIVersion() middleware adds to the request context.
+const apiVersionContextKey = contextKey("api_version")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as
 // the key
@@ -35,3 +43,36 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+// The contextSetPermissions() method returns a new copy of the request with the user's
+// permissions added to the context.
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// The contextGetPermissions() method retrieves the user's permissions from the request
+// context. Unlike the user, these are only there for requests which have passed through
+// requirePermission(), so a missing value is returned as nil rather than panicking.
+func (app *application) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, _ := r.Context().Value(permissionsContextKey).(data.Permissions)
+	return permissions
+}
+
+// The contextSetAPIVersion() method returns a new copy of the request with the API
+// version added to the context.
+func (app *application) contextSetAPIVersion(r *http.Request, version apiVersion) *http.Request {
+	ctx := context.WithValue(r.Context(), apiVersionContextKey, version)
+	return r.WithContext(ctx)
+}
+
+// The contextGetAPIVersion() method retrieves the API version from the request context.
+// Only the /v2 routes set it, so a missing value means the request was made to v1.
+func (app *application) contextGetAPIVersion(r *http.Request) apiVersion {
+	version, ok := r.Context().Value(apiVersionContextKey).(apiVersion)
+	if !ok {
+		return apiV1
+	}
+
+	return version
+}
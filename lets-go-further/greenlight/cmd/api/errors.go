@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -14,63 +18,219 @@ func (app *application) logError(r *http.Request, err error) {
 	})
 }
 
-// The errorResponse() method is a generic helper for sending JSON-formatted error
-// messages to the client with a given status code. Note that we're using an interface{}
-// type for the message parameter, rather than just a string type, as this gives us
-// more flexibility over the values that we can include in the response.
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message interface{}) {
-	env := envelope{"error": message}
+// Define the machine-readable error codes sent in the "code" member of every error
+// response. Clients should switch on these rather than the human-readable messages, which
+// may change. Each code always comes with the same HTTP status code.
+const (
+	codeServerError                = "server_error"
+	codeNotFound                   = "not_found"
+	codeMethodNotAllowed           = "method_not_allowed"
+	codeBadRequest                 = "bad_request"
+	codeValidationFailed           = "validation_failed"
+	codeUnsupportedMediaType       = "unsupported_media_type"
+	codeNotAcceptable              = "not_acceptable"
+	codeEditConflict               = "edit_conflict"
+	codeRateLimited                = "rate_limited"
+	codeInvalidCredentials         = "invalid_credentials"
+	codeTooManyLoginAttempts       = "too_many_login_attempts"
+	codeAccountLocked              = "account_locked"
+	codeInvalidAuthenticationToken = "invalid_authentication_token"
+	codeAuthenticationRequired     = "authentication_required"
+	codeInactiveAccount            = "inactive_account"
+	codeNotPermitted               = "not_permitted"
+	codeImportFailed               = "import_failed"
+	codePatchTestFailed            = "patch_test_failed"
+	codeUnprocessablePatch         = "unprocessable_patch"
+	codeIdempotencyKeyReused       = "idempotency_key_reused"
+	codeIdempotencyKeyInProgress   = "idempotency_key_in_progress"
+)
+
+// problemTypePrefix is prepended to an error code to make the "type" URI of an RFC 7807
+// problem details response.
+const problemTypePrefix = "urn:greenlight:problem:"
+
+// fieldError describes a single field which failed validation, in the "errors" array of
+// a validation problem response.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// The errorResponse() method is a generic helper for sending error messages to the
+// client with a given status code and machine-readable error code. Note that we're using
+// an interface{} type for the message parameter, rather than just a string type, so that
+// the map of validation errors from a Validator can be passed in as well.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message interface{}) {
+	app.problemResponse(w, r, status, code, message, nil)
+}
+
+// The problemResponse() method does the work for errorResponse(), and also takes extra
+// members to add to the response body. By default the response is an RFC 7807
+// application/problem+json document. If the -legacy-errors flag is set, it is the old
+// {"error": message} envelope instead, for clients which haven't been updated yet.
+func (app *application) problemResponse(w http.ResponseWriter, r *http.Request, status int, code string, message interface{}, extensions envelope) {
+	var env envelope
+	var headers http.Header
+
+	if app.config.legacyErrors {
+		env = envelope{"error": message}
+	} else {
+		env = envelope{
+			"type":     problemTypePrefix + code,
+			"title":    http.StatusText(status),
+			"status":   status,
+			"code":     code,
+			"instance": r.URL.Path,
+		}
+
+		switch message := message.(type) {
+		case string:
+			env["detail"] = message
+		case map[string]string:
+			env["detail"] = "one or more fields failed validation"
+			env["errors"] = fieldErrors(message)
+		}
+
+		headers = http.Header{"Content-Type": {"application/problem+json"}}
+	}
+
+	for key, value := range extensions {
+		env[key] = value
+	}
 
 	// Write the response using the writeJSON() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeJSON(w, r, status, env, headers)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(500)
 	}
 }
 
+// fieldErrors converts a map of validation errors to a slice, sorted by field name so
+// that the order is the same on every request.
+func fieldErrors(errors map[string]string) []fieldError {
+	fields := make([]fieldError, 0, len(errors))
+
+	for field, message := range errors {
+		fields = append(fields, fieldError{Field: field, Message: message})
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Field < fields[j].Field
+	})
+
+	return fields
+}
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+	app.errorResponse(w, r, http.StatusInternalServerError, codeServerError, message)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, codeNotFound, message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, codeBadRequest, err.Error())
 }
 
 // Note that the errors parameter here has the type map[string]string, which is exactly
 // the same as the errors map contained in our Validator type
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, codeValidationFailed, errors)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the %q content type is not supported for this resource, use one of: %s", r.Header.Get("Content-Type"), strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, message)
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("unable to produce a response matching the Accept header, use one of: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusNotAcceptable, codeNotAcceptable, message)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, codeEditConflict, message)
 }
 
-func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+// The patchTestFailedResponse() method is used when a test operation in a JSON Patch
+// finds a different value to the one it expects, which means that the resource has
+// changed since the client last read it.
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, codePatchTestFailed, err.Error())
+}
+
+// The unprocessablePatchResponse() method is used when a well-formed patch can't be
+// applied to the resource, such as when one of its paths doesn't exist.
+func (app *application) unprocessablePatchResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, codeUnprocessablePatch, err.Error())
+}
+
+// The idempotencyKeyReusedResponse() method is used when an Idempotency-Key header is
+// sent again with a different request to the one it was first used for.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Idempotency-Key has already been used for a different request"
+	app.errorResponse(w, r, http.StatusConflict, codeIdempotencyKeyReused, message)
+}
+
+// The idempotencyKeyInProgressResponse() method is used when a request is retried with
+// the same Idempotency-Key before the first request has finished.
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+
+	message := "a request with this Idempotency-Key is still being processed, please try again"
+	app.errorResponse(w, r, http.StatusConflict, codeIdempotencyKeyInProgress, message)
+}
+
+// The rateLimitExceededResponse() method is used when a client has used up its rate
+// limit. The Retry-After header tells it how long until its next request is allowed.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeRateLimited, message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidCredentials, message)
+}
+
+// The tooManyLoginAttemptsResponse() method is used when sign in attempts for an account
+// or IP address are being delayed after repeated failures.
+func (app *application) tooManyLoginAttemptsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+
+	message := "too many failed sign in attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, codeTooManyLoginAttempts, message)
+}
+
+// The accountLockedResponse() method is used when an account has been locked after too
+// many failed sign in attempts.
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+
+	message := "this account has been temporarily locked after too many failed sign in attempts"
+	app.errorResponse(w, r, http.StatusLocked, codeAccountLocked, message)
+}
+
+// retryAfterSeconds formats a duration for the Retry-After header, rounding up to a whole
+// number of seconds.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
@@ -78,20 +238,20 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	w.Header().Set("WWW-Authenticate", "Bearer")
 
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeInvalidAuthenticationToken, message)
 }
 
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, codeAuthenticationRequired, message)
 }
 
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeInactiveAccount, message)
 }
 
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, codeNotPermitted, message)
 }
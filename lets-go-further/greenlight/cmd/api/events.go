@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+// The server's WriteTimeout applies to the whole response, so an event stream can't stay
+// open forever. Instead we end each stream a little before the timeout, and the client
+// reconnects (after the retry delay) with the Last-Event-ID header, picking up anything
+// it missed from the replay buffer.
+const (
+	eventStreamMaxDuration = 25 * time.Second
+	eventStreamHeartbeat   = 10 * time.Second
+	eventStreamRetry       = 2 * time.Second
+)
+
+// movieEventBroker fans movie events out to every connected event stream, and keeps the
+// most recent events so that a reconnecting client can catch up.
+type movieEventBroker struct {
+	mu          sync.Mutex
+	replaySize  int
+	replay      []*data.MovieEvent
+	subscribers map[chan *data.MovieEvent]struct{}
+}
+
+func newMovieEventBroker(replaySize int) *movieEventBroker {
+	return &movieEventBroker{
+		replaySize:  replaySize,
+		subscribers: make(map[chan *data.MovieEvent]struct{}),
+	}
+}
+
+// publish adds an event to the replay buffer and sends it to every subscriber. A nil
+// event means that events may have been lost, so the replay buffer is emptied and the
+// subscribers are told to reset. A subscriber which isn't keeping up is disconnected,
+// rather than being allowed to hold up everyone else.
+func (b *movieEventBroker) publish(event *data.MovieEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if event == nil {
+		b.replay = nil
+	} else if b.replaySize > 0 {
+		b.replay = append(b.replay, event)
+		if len(b.replay) > b.replaySize {
+			b.replay = b.replay[len(b.replay)-b.replaySize:]
+		}
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a new subscriber, and returns the buffered events which follow
+// lastEventID. Events are buffered in the order Postgres delivered them (which is not
+// necessarily ID order), so we look for the position of lastEventID rather than
+// comparing IDs. The returned bool is false if lastEventID is no longer in the buffer,
+// meaning the client has missed events which can't be replayed.
+func (b *movieEventBroker) subscribe(lastEventID string) (chan *data.MovieEvent, []*data.MovieEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *data.MovieEvent, 64)
+	b.subscribers[ch] = struct{}{}
+
+	if lastEventID == "" {
+		return ch, nil, true
+	}
+
+	for i, event := range b.replay {
+		if strconv.FormatInt(event.ID, 10) == lastEventID {
+			replay := make([]*data.MovieEvent, len(b.replay)-i-1)
+			copy(replay, b.replay[i+1:])
+			return ch, replay, true
+		}
+	}
+
+	return ch, nil, false
+}
+
+func (b *movieEventBroker) unsubscribe(ch chan *data.MovieEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// The listenMovieEvents() method launches a background goroutine which receives movie
+// events from Postgres and publishes them to the broker.
+func (app *application) listenMovieEvents() error {
+	listener, err := data.NewMovieEventListener(app.config.db.DSN, func(err error) {
+		app.logger.PrintError(err, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			event, err := listener.Next()
+			if err != nil {
+				if errors.Is(err, data.ErrListenerClosed) {
+					return
+				}
+				app.logger.PrintError(err, nil)
+				continue
+			}
+
+			app.events.publish(event)
+
+			// Every change to a movie, made by this or any other instance of the API,
+			// empties the response cache. A nil event means the listener reconnected
+			// and may have missed changes, so that empties it too.
+			if app.config.cache.notify {
+				app.movieCache.invalidate()
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("response writer does not support flushing"))
+		return
+	}
+
+	// Clients can choose to only hear about movies in some genres. A movie matches if it
+	// has any of the requested genres.
+	genres := app.readCSV(r.URL.Query(), "genres", []string{})
+
+	// The movies in the events are represented for the API version of the route.
+	version := app.contextGetAPIVersion(r)
+
+	// Browsers send Last-Event-ID when reconnecting automatically, but there's no way to
+	// set it on the first request, so we accept it as a query string parameter too.
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = app.readString(r.URL.Query(), "last_event_id", "")
+	}
+
+	ch, replay, ok := app.events.subscribe(lastEventID)
+	defer app.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx (and similar proxies) from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventStreamRetry.Milliseconds())
+
+	// If the client's last event has dropped out of the replay buffer, tell it to reset
+	// (that is, re-fetch whatever it is displaying) before we carry on.
+	if !ok {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+
+	for _, event := range replay {
+		err := writeMovieEvent(w, version, event, genres)
+		if err != nil {
+			return
+		}
+	}
+
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	deadline := time.NewTimer(eventStreamMaxDuration)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case event, open := <-ch:
+			// The broker closes the channel if we fall too far behind. Ending the
+			// stream makes the client reconnect and catch up from the replay buffer.
+			if !open {
+				return
+			}
+
+			var err error
+			if event == nil {
+				_, err = fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+			} else {
+				err = writeMovieEvent(w, version, event, genres)
+			}
+			if err != nil {
+				return
+			}
+
+		// Send a comment line now and then, so that proxies don't close the connection
+		// while it is idle.
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+
+		case <-deadline.C:
+			return
+
+		// End the stream when the server shuts down, so that Shutdown() doesn't have to
+		// wait for it. The client reconnects to another instance, or once we are back.
+		case <-app.shutdown:
+			return
+
+		case <-r.Context().Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
+
+// writeMovieEvent writes a single event in the text/event-stream format, unless it is
+// filtered out by genres.
+func writeMovieEvent(w http.ResponseWriter, version apiVersion, event *data.MovieEvent, genres []string) error {
+	if len(genres) > 0 && !hasAnyGenre(event.Movie, genres) {
+		return nil
+	}
+
+	js, err := json.Marshal(envelope{"movie": represent(version, event.Movie)})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, js)
+	return err
+}
+
+func hasAnyGenre(movie *data.Movie, genres []string) bool {
+	for _, genre := range movie.Genres {
+		for _, g := range genres {
+			if genre == g {
+				return true
+			}
+		}
+	}
+
+	return false
+}
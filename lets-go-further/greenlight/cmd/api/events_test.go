@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+func testMovieEvent(id int64, genres ...string) *data.MovieEvent {
+	return &data.MovieEvent{
+		ID:    id,
+		Event: "movie.updated",
+		Movie: &data.Movie{ID: 1, Title: "Casablanca", Year: 1942, Runtime: 102, Genres: genres, Version: int32(id)},
+	}
+}
+
+// eventIDs returns the IDs of a list of events, for comparing in tests.
+func eventIDs(events []*data.MovieEvent) string {
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+
+	return fmt.Sprint(ids)
+}
+
+func TestMovieEventBrokerReplay(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastEventID string
+		reset       bool
+		wantReplay  string
+		wantOK      bool
+	}{
+		{name: "New stream", lastEventID: "", wantReplay: "[]", wantOK: true},
+		{name: "Catching up", lastEventID: "3", wantReplay: "[4 5]", wantOK: true},
+		{name: "Up to date", lastEventID: "5", wantReplay: "[]", wantOK: true},
+		{name: "Dropped out of the buffer", lastEventID: "2", wantReplay: "[]", wantOK: false},
+		{name: "Unknown ID", lastEventID: "99", wantReplay: "[]", wantOK: false},
+		{name: "After a reset", lastEventID: "5", reset: true, wantReplay: "[]", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newMovieEventBroker(3)
+
+			for id := int64(1); id <= 5; id++ {
+				b.publish(testMovieEvent(id))
+			}
+
+			if tt.reset {
+				b.publish(nil)
+			}
+
+			ch, replay, ok := b.subscribe(tt.lastEventID)
+			defer b.unsubscribe(ch)
+
+			assert.Equal(t, eventIDs(replay), tt.wantReplay)
+			assert.Equal(t, ok, tt.wantOK)
+		})
+	}
+}
+
+func TestMovieEventBrokerSubscribers(t *testing.T) {
+	b := newMovieEventBroker(10)
+
+	first, _, _ := b.subscribe("")
+	second, _, _ := b.subscribe("")
+
+	// Every subscriber gets each event, and a reset is sent as nil.
+	b.publish(testMovieEvent(1))
+	b.publish(nil)
+
+	for _, ch := range []chan *data.MovieEvent{first, second} {
+		assert.Equal(t, (<-ch).ID, int64(1))
+		assert.Equal(t, <-ch == nil, true)
+	}
+
+	// Unsubscribing closes the channel, and the subscriber gets nothing more.
+	b.unsubscribe(first)
+	_, open := <-first
+	assert.Equal(t, open, false)
+
+	// A subscriber which falls behind is disconnected rather than holding up publish().
+	for id := int64(2); id <= int64(cap(second))+2; id++ {
+		b.publish(testMovieEvent(id))
+	}
+
+	received := 0
+	for range second {
+		received++
+	}
+	assert.Equal(t, received, cap(second))
+
+	// Unsubscribing after being disconnected is harmless.
+	b.unsubscribe(second)
+}
+
+func TestWriteMovieEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		version apiVersion
+		genres  []string
+		want    string
+	}{
+		{
+			name:    "v1",
+			version: apiV1,
+			want:    "id: 7\nevent: movie.updated\ndata: {\"movie\":{\"id\":1,\"title\":\"Casablanca\",\"year\":1942,\"runtime\":\"102 mins\",\"genres\":[\"drama\",\"war\"],\"version\":7}}\n\n",
+		},
+		{
+			name:    "v2",
+			version: apiV2,
+			want:    "id: 7\nevent: movie.updated\ndata: {\"movie\":{\"id\":1,\"title\":\"Casablanca\",\"year\":1942,\"runtime\":102,\"genres\":[\"drama\",\"war\"],\"version\":7,\"created_at\":\"0001-01-01T00:00:00Z\",\"deleted_at\":null}}\n\n",
+		},
+		{
+			name:    "Matching genre",
+			version: apiV1,
+			genres:  []string{"comedy", "war"},
+			want:    "id: 7\nevent: movie.updated\ndata: {\"movie\":{\"id\":1,\"title\":\"Casablanca\",\"year\":1942,\"runtime\":\"102 mins\",\"genres\":[\"drama\",\"war\"],\"version\":7}}\n\n",
+		},
+		{
+			name:    "Filtered out",
+			version: apiV1,
+			genres:  []string{"comedy"},
+			want:    "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+
+			err := writeMovieEvent(rr, tt.version, testMovieEvent(7, "drama", "war"), tt.genres)
+			assert.NilError(t, err)
+			assert.Equal(t, rr.Body.String(), tt.want)
+		})
+	}
+}
+
+func TestMovieEventsHandlerReplay(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastEventID string
+		query       string
+		want        string
+	}{
+		{
+			name:        "Catching up",
+			lastEventID: "1",
+			want:        "retry: 2000\n\nid: 2\nevent: movie.updated\ndata: {\"movie\":{\"id\":1,\"title\":\"Casablanca\",\"year\":1942,\"runtime\":\"102 mins\",\"genres\":[\"war\"],\"version\":2}}\n\n",
+		},
+		{
+			name:  "Last event ID in the query string",
+			query: "?last_event_id=2",
+			want:  "retry: 2000\n\n",
+		},
+		{
+			name:        "Filtered by genre",
+			lastEventID: "1",
+			query:       "?genres=comedy",
+			want:        "retry: 2000\n\n",
+		},
+		{
+			name:        "Missed events",
+			lastEventID: "99",
+			want:        "retry: 2000\n\nevent: reset\ndata: {}\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.events.publish(testMovieEvent(1, "drama"))
+			app.events.publish(testMovieEvent(2, "war"))
+
+			// The client has already gone away, so the handler stops as soon as it has
+			// sent what it would send on connecting.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/events"+tt.query, nil).WithContext(ctx)
+			if tt.lastEventID != "" {
+				r.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			r = app.contextSetAPIVersion(r, apiV1)
+
+			rr := httptest.NewRecorder()
+			app.movieEventsHandler(rr, r)
+
+			assert.Equal(t, rr.Code, http.StatusOK)
+			assert.Equal(t, rr.Header().Get("Content-Type"), "text/event-stream")
+			assert.Equal(t, rr.Body.String(), tt.want)
+			assert.Equal(t, len(app.events.subscribers), 0)
+		})
+	}
+}
+
+func TestMovieEventsHandlerShutdown(t *testing.T) {
+	app := newTestApplication(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies/events", nil)
+	r = app.contextSetAPIVersion(r, apiV1)
+
+	done := make(chan struct{})
+
+	go func() {
+		app.movieEventsHandler(httptest.NewRecorder(), r)
+		close(done)
+	}()
+
+	close(app.shutdown)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the event stream didn't end on shutdown")
+	}
+}
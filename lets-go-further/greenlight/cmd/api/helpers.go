@@ -1,19 +1,36 @@
 package main
 
 import (
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com.go-learning.greenlight/internal/msgpack"
 	"github.com.go-learning.greenlight/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
 
+// Define the content types which the API can send, and which the bulk import and movie
+// update endpoints accept.
+const (
+	contentTypeJSON       = "application/json"
+	contentTypeMsgpack    = "application/msgpack"
+	contentTypeNDJSON     = "application/x-ndjson"
+	contentTypeCSV        = "text/csv"
+	contentTypeMergePatch = "application/merge-patch+json"
+	contentTypeJSONPatch  = "application/json-patch+json"
+)
+
 // Retrieve the "id" URL parameter from the current request context, then convert it to
 // an integer and return it. If the operation isn't successful, return 0 and an error
 // While this doesn't use any dependencies from the application struct, it's a good practice
@@ -32,19 +49,114 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+// The readIntParam() helper works in the same way as readIDParam(), but for any named
+// URL parameter which should hold a positive integer.
+func (app *application) readIntParam(r *http.Request, name string) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	i, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+
+	return i, nil
+}
+
+// The dispatchParam() helper works around httprouter not allowing a fixed path segment
+// (like /v1/movies/export) to share a position with a named parameter (like
+// /v1/movies/:id). The route is registered once with the named parameter, and requests
+// whose parameter value matches one of the keys in routes are sent to that handler
+// instead of the fallback.
+func (app *application) dispatchParam(name string, fallback http.HandlerFunc, routes map[string]http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := routes[params.ByName(name)]; ok {
+			handler(w, r)
+			return
+		}
+
+		fallback(w, r)
+	}
+}
+
+// The negotiate() helper picks the media type from offers which best matches the
+// request's Accept header. Each offer takes the q-value of the most specific media range
+// that matches it (so "text/csv;q=0, */*" rules out CSV), and ties are won by the offer
+// listed first. A missing Accept header matches the first offer. If none of the offers
+// are acceptable to the client, the empty string is returned.
+func (app *application) negotiate(r *http.Request, offers ...string) string {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offers[0]
+	}
+
+	best := ""
+	bestQ := 0.0
+
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+
+		for _, part := range strings.Split(accept, ",") {
+			mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || !mediaTypeMatches(mediaRange, offer) {
+				continue
+			}
+
+			// An exact match beats "type/*", which in turn beats "*/*".
+			s := 2 - strings.Count(mediaRange, "*")
+			if s <= specificity {
+				continue
+			}
+
+			specificity, q = s, 1.0
+			if value, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(value, 64)
+				if err != nil {
+					q = 0
+				}
+			}
+		}
+
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+
+	return best
+}
+
+// mediaTypeMatches reports whether a media range from an Accept header, such as
+// "text/*" or "*/*", includes the given media type.
+func mediaTypeMatches(mediaRange, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+
+	return strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+}
+
 // Define an envelope type. The empty interface type is basically like an "any" type
 type envelope map[string]interface{}
 
 // Define a writeJSON() helper for sending responses. This takes the destination
-// http.ResponseWriter, the HTTP status code to send, the data to encode to JSON, and a
-// header map containing any additional HTTP headers we want to include in the response
-// Change the data paramter to have the type envelope instead of interface{}
-func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	// Encode the data to JSON, returning the error if there was one.
-	// Use the json.MarshalIndent() function so that whitespace is added to the encoded
-	// JSON so it looks good in terminals. Here we use no line prefix ("") and tab indents ("\t")
-	// for each element.
-	js, err := json.MarshalIndent(data, "", "\t")
+// http.ResponseWriter, the request, the HTTP status code to send, the data to encode to
+// JSON, and a header map containing any additional HTTP headers we want to include in the
+// response. The JSON is compact, unless the request's query string contains ?pretty.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	// Encode the data to JSON, returning the error if there was one. Indenting the JSON
+	// makes it easy to read in a terminal, but for a large list it adds a lot to the
+	// size of the response, so we only do it when it's asked for.
+	var (
+		js  []byte
+		err error
+	)
+
+	if r.URL.Query().Has("pretty") {
+		js, err = json.MarshalIndent(data, "", "\t")
+	} else {
+		js, err = json.Marshal(data)
+	}
 	if err != nil {
 		return err
 	}
@@ -52,29 +164,159 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	// Append a newline to make it easier to view in terminal applications.
 	js = append(js, '\n')
 
+	return app.write(w, status, contentTypeJSON, js, headers)
+}
+
+// The writeMsgpack() helper works in the same way as writeJSON(), but sends the data in
+// the MessagePack format. The fields are the same as in the JSON response.
+func (app *application) writeMsgpack(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+	b, err := msgpack.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return app.write(w, status, contentTypeMsgpack, b, headers)
+}
+
+// The write() helper sends an encoded response body.
+func (app *application) write(w http.ResponseWriter, status int, contentType string, body []byte, headers http.Header) error {
 	// At this point, we know that we won't encounter any more errors before writing the
-	// response, so it's safe to add any headers that we want to include. We loop
-	// through the header map and add each header to the http.ResponseWriter header map.
-	// Note that it's OK if the provided header map is nil. Go doesn't throw an error
-	// if you try to range over (or generally, read from) a nil map.
+	// response, so it's safe to add the Content-Type header and any other headers that
+	// we want to include. We loop through the header map and add each header to the
+	// http.ResponseWriter header map, after the Content-Type so that it can be
+	// overridden (error responses use application/problem+json). Note that it's OK if
+	// the provided header map is nil. Go doesn't throw an error if you try to range over
+	// (or generally, read from) a nil map.
+	w.Header().Set("Content-Type", contentType)
+
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
 
-	// Add the "Content-Type: application/json" header, then write the status code and
-	// JSON response
-	w.Header().Set("Content-Type", "application/json")
+	// Responses vary on the Accept header, so caches mustn't send one format to a client
+	// which asked for another.
+	w.Header().Add("Vary", "Accept")
+
+	// Write the status code and response body
 	w.WriteHeader(status)
-	w.Write(js)
+	w.Write(body)
 
 	return nil
 }
 
+// The writeResponse() helper sends data in the format which best matches the request's
+// Accept header: JSON (the default) or MessagePack. If the client accepts neither, a GET
+// or HEAD request gets a 406 Not Acceptable response. Any other request has already
+// made its changes by the time the response is written, so we send JSON anyway rather
+// than hide that it succeeded, which RFC 9110 allows.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	data = app.representEnvelope(r, data)
+
+	switch app.negotiate(r, contentTypeJSON, contentTypeMsgpack) {
+	case contentTypeMsgpack:
+		return app.writeMsgpack(w, status, data, headers)
+	case contentTypeJSON:
+		return app.writeJSON(w, r, status, data, headers)
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		app.notAcceptableResponse(w, r, contentTypeJSON, contentTypeMsgpack)
+		return nil
+	}
+
+	return app.writeJSON(w, r, status, data, headers)
+}
+
+// The writeList() helper sends the response for a list endpoint, where env holds the
+// list under key (and optionally its pagination metadata under "metadata"). As well as
+// the formats supported by writeResponse(), the list can be sent as newline-delimited
+// JSON with one item per line, and, if toCSV isn't nil, as CSV with the rows it returns.
+// For those two formats the pagination metadata is sent in X-Current-Page,
+// X-Page-Size, X-First-Page, X-Last-Page and X-Total-Records headers instead.
+func (app *application) writeList(w http.ResponseWriter, r *http.Request, env envelope, key string, toCSV func() [][]string) error {
+	offers := []string{contentTypeJSON, contentTypeMsgpack, contentTypeNDJSON}
+	if toCSV != nil {
+		offers = append(offers, contentTypeCSV)
+	}
+
+	format := app.negotiate(r, offers...)
+
+	switch format {
+	case contentTypeJSON, contentTypeMsgpack:
+		return app.writeResponse(w, r, http.StatusOK, env, nil)
+	case "":
+		app.notAcceptableResponse(w, r, offers...)
+		return nil
+	}
+
+	env = app.representEnvelope(r, env)
+	headers := paginationHeaders(env["metadata"])
+
+	var buf bytes.Buffer
+
+	if format == contentTypeCSV {
+		cw := csv.NewWriter(&buf)
+
+		err := cw.WriteAll(toCSV())
+		if err != nil {
+			return err
+		}
+	} else {
+		enc := json.NewEncoder(&buf)
+
+		// The list is a slice of some type, so we use reflection to encode each item
+		// in turn.
+		items := reflect.ValueOf(env[key])
+
+		for i := 0; i < items.Len(); i++ {
+			err := enc.Encode(items.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return app.write(w, http.StatusOK, format, buf.Bytes(), headers)
+}
+
+// readBody reads the whole request body into memory, for when it isn't decoded as JSON
+// by readJSON(), such as a patch document. It is limited to 1MB in the same way.
+func (app *application) readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		if errors.As(err, &maxBytesError) {
+			return nil, fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+		}
+		return nil, err
+	}
+
+	return body, nil
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
 	// Use http.MaxBytesReader() to limit the size of the request body to 1MB.
 	maxBytes := 1_048_576
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
+	// A v2 movie has its runtime as a number of minutes, so convert the body to the v1
+	// shape that the handlers decode. This needs the whole body in memory, which is fine
+	// as it is at most 1MB.
+	if app.contextGetAPIVersion(r) == apiV2 {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		body, err = movieBodyFromV2(body)
+		if err != nil {
+			return fmt.Errorf("body contains %w", err)
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
 	// before decoding. This means that if the JSON from the client now includes any
 	// feild which cannot be mapped to the target destination, the decoder will return
@@ -229,3 +471,65 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+// The worker() helper runs fn in a background goroutine every interval, until the
+// server starts shutting down. Like background(), the goroutine is tracked by the
+// WaitGroup, so shutting down waits for a run of fn which has already started. A panic
+// in fn is recovered and logged, and fn runs again on the next tick.
+func (app *application) worker(interval time.Duration, fn func()) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-app.shutdown:
+				return
+			case <-ticker.C:
+				func() {
+					defer func() {
+						if err := recover(); err != nil {
+							app.logger.PrintError(fmt.Errorf("%s", err), nil)
+						}
+					}()
+
+					fn()
+				}()
+			}
+		}
+	}()
+}
+
+// shuttingDown reports whether the server has started shutting down, for workers which
+// do several things in one run to check between them.
+func (app *application) shuttingDown() bool {
+	select {
+	case <-app.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns how long to wait before retrying something which has failed the given
+// number of times. The wait starts at base and doubles after every attempt, up to a
+// maximum of 24 hours.
+func backoff(base time.Duration, attempts int) time.Duration {
+	const maxBackoff = 24 * time.Hour
+
+	d := base
+
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+
+	return d
+}
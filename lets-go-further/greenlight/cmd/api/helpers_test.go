@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+func TestWorker(t *testing.T) {
+	app := newTestApplication(t)
+
+	var runs int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	app.worker(time.Millisecond, func() {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			close(started)
+			<-release
+		}
+		panic("panics are recovered and logged")
+	})
+
+	// Start shutting down while the first run is still going, and check that the
+	// WaitGroup waits for it to finish.
+	<-started
+	close(app.shutdown)
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("the WaitGroup didn't wait for the run in progress")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the worker didn't stop after shutting down")
+	}
+
+	assert.Equal(t, app.shuttingDown(), true)
+}
+
+func TestNegotiate(t *testing.T) {
+	app := newTestApplication(t)
+
+	offers := []string{contentTypeNDJSON, contentTypeCSV}
+
+	tests := []struct {
+		name   string
+		accept string
+		want   string
+	}{
+		{"No Accept header", "", contentTypeNDJSON},
+		{"Exact match", "text/csv", contentTypeCSV},
+		{"Any", "*/*", contentTypeNDJSON},
+		{"Type wildcard", "text/*", contentTypeCSV},
+		{"Higher q wins", "application/x-ndjson;q=0.5, text/csv;q=0.8", contentTypeCSV},
+		{"Equal q goes to the first offer", "text/csv, application/x-ndjson", contentTypeNDJSON},
+		{"Specific range beats wildcard", "text/csv;q=0, */*", contentTypeNDJSON},
+		{"Specific range beats wildcard in any order", "*/*;q=0.1, text/csv", contentTypeCSV},
+		{"Refused", "text/csv;q=0, application/x-ndjson;q=0", ""},
+		{"Nothing acceptable", "application/json", ""},
+		{"Parameters and spaces", " text/csv ; charset=utf-8 ", contentTypeCSV},
+		{"Invalid q", "text/csv;q=high, application/x-ndjson;q=0.1", contentTypeNDJSON},
+		{"Invalid media range", "nonsense, text/csv", contentTypeCSV},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/export", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+
+			assert.Equal(t, app.negotiate(r, offers...), tt.want)
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com/felixge/httpsnoop"
+)
+
+// idempotencyKeyMaxLength is the longest Idempotency-Key header which is accepted.
+// Clients usually send a UUID.
+const idempotencyKeyMaxLength = 255
+
+// idempotencyFingerprint returns a hash of the parts of a request which make a retry
+// "the same request": its method, URL, content type and body.
+func idempotencyFingerprint(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%s\n%s\n%s\n", r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"))
+	h.Write(body)
+
+	return h.Sum(nil)
+}
+
+// anonymousIdempotencyKey returns the key which an anonymous request is stored under:
+// a hash of the client's key and the request fingerprint, so that one client can't be
+// sent the response to another's request by guessing or reusing its key.
+func anonymousIdempotencyKey(key string, fingerprint []byte) string {
+	h := sha256.New()
+
+	h.Write(fingerprint)
+	h.Write([]byte(key))
+
+	return "anonymous:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// The idempotent() middleware honours the Idempotency-Key header, so that a client which
+// retries a POST request after a timeout doesn't carry it out twice. The first request
+// with a key is run as normal, and its response is stored with the key. A retry with the
+// same key and an identical request is sent the stored response, with an
+// Idempotent-Replayed: true header, without running the handler again. Reusing a key for
+// a different request, or retrying while the first request is still running, gets a 409
+// Conflict response.
+//
+// Keys are scoped to the user who sent them, and are forgotten after the
+// -idempotency-key-ttl window. Anonymous requests (such as registering) have no user to
+// scope their keys to, and clients can't be told apart reliably by IP address, so their
+// keys are stored under a hash of the key and the request instead. Only a retry of the
+// identical request, which for registration includes the password, is then sent the
+// stored response. It also means that reusing an anonymous key for a different request
+// isn't spotted, and simply runs the new request. Server errors aren't stored, so the
+// request can be retried with the same key. It must be wrapped by the authenticate()
+// middleware (which all the routes are), and by requirePermission() on routes which need
+// it, so that a replay is only sent to a user who could make the request.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || app.config.idempotency.ttl <= 0 {
+			next(w, r)
+			return
+		}
+
+		if len(key) > idempotencyKeyMaxLength {
+			app.badRequestResponse(w, r, fmt.Errorf("the Idempotency-Key header must not be more than %d bytes long", idempotencyKeyMaxLength))
+			return
+		}
+
+		// The body is read here to fingerprint the request, and then put back so that
+		// the handler can read it as usual.
+		body, err := app.readBody(w, r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		r.Body = readCloser{bytes.NewReader(body), r.Body}
+
+		user := app.contextGetUser(r)
+
+		record := &data.IdempotencyKey{
+			UserID:      user.ID,
+			Key:         key,
+			RequestHash: idempotencyFingerprint(r, body),
+		}
+
+		if user.IsAnonymous() {
+			record.Key = anonymousIdempotencyKey(key, record.RequestHash)
+		}
+
+		err = app.models.IdempotencyKeys.Reserve(record, app.config.idempotency.ttl)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrIdempotencyKeyInUse):
+				app.replayIdempotentResponse(w, r, record)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		// If the handler panics, the key is released so that the client can retry, and
+		// the panic carries on up to the recoverPanic() middleware.
+		completed := false
+
+		defer func() {
+			if !completed {
+				app.releaseIdempotencyKey(r, record)
+			}
+		}()
+
+		// Record the response as it is sent. Only the headers which the handler sets
+		// are stored, as the outer middleware sets the others again on a replay.
+		before := w.Header().Clone()
+
+		var (
+			status int
+			header http.Header
+			buf    bytes.Buffer
+		)
+
+		writeHeader := func(code int) {
+			if status != 0 {
+				return
+			}
+
+			status = code
+			header = http.Header{}
+
+			for name, values := range w.Header() {
+				if !equalValues(before[name], values) {
+					header[name] = append([]string(nil), values...)
+				}
+			}
+		}
+
+		// httpsnoop keeps the interfaces implemented by the ResponseWriter.
+		rw := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					writeHeader(code)
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					writeHeader(http.StatusOK)
+					buf.Write(b)
+					return next(b)
+				}
+			},
+		})
+
+		next(rw, r)
+
+		writeHeader(http.StatusOK)
+		completed = true
+
+		if status >= http.StatusInternalServerError {
+			app.releaseIdempotencyKey(r, record)
+			return
+		}
+
+		record.Status = status
+		record.Header = header
+		record.Body = buf.Bytes()
+
+		// The response has already been sent, so a failure to store it can only be
+		// logged. The key is released, so that a retry runs the request again rather
+		// than being told that it is still in progress.
+		err = app.models.IdempotencyKeys.Complete(record)
+		if err != nil {
+			app.logError(r, err)
+			app.releaseIdempotencyKey(r, record)
+		}
+	}
+}
+
+// replayIdempotentResponse sends the stored response for a key which has already been
+// used, if the request matches the one it was first used for.
+func (app *application) replayIdempotentResponse(w http.ResponseWriter, r *http.Request, record *data.IdempotencyKey) {
+	stored, err := app.models.IdempotencyKeys.Get(record.UserID, record.Key, app.config.idempotency.ttl)
+	if err != nil {
+		switch {
+		// The key was released or expired just after Reserve() found it, so the first
+		// request must have only just finished.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.idempotencyKeyInProgressResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch {
+	case !bytes.Equal(stored.RequestHash, record.RequestHash):
+		app.idempotencyKeyReusedResponse(w, r)
+
+	case stored.Status == 0:
+		app.idempotencyKeyInProgressResponse(w, r)
+
+	default:
+		for name, values := range stored.Header {
+			w.Header()[name] = values
+		}
+
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.Status)
+		w.Write(stored.Body)
+	}
+}
+
+// releaseIdempotencyKey removes a key, logging any error as the response has usually
+// already been sent.
+func (app *application) releaseIdempotencyKey(r *http.Request, record *data.IdempotencyKey) {
+	err := app.models.IdempotencyKeys.Release(record.UserID, record.Key)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The cleanupIdempotencyKeys() method starts a background worker which removes
+// expired idempotency keys and their stored responses.
+func (app *application) cleanupIdempotencyKeys() {
+	if app.config.idempotency.ttl <= 0 {
+		return
+	}
+
+	app.worker(time.Hour, func() {
+		deleted, err := app.models.IdempotencyKeys.DeleteExpired(app.config.idempotency.ttl)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		if deleted > 0 {
+			app.logger.PrintInfo("deleted expired idempotency keys", map[string]string{
+				"count": strconv.FormatInt(deleted, 10),
+			})
+		}
+	})
+}
+
+// equalValues reports whether two header values are the same.
+func equalValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readCloser replaces a request body which has been read into memory, while still
+// closing the original body.
+type readCloser struct {
+	*bytes.Reader
+	body interface{ Close() error }
+}
+
+func (rc readCloser) Close() error {
+	return rc.body.Close()
+}
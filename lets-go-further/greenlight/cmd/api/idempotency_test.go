@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+func TestIdempotentAnonymous(t *testing.T) {
+	app := newTestApplication(t)
+	app.models = data.NewModels(newIdempotencyKeyDB(t))
+
+	// Echo the body back, so that each client can see whether it got its own response.
+	calls := 0
+	h := app.idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		var input struct {
+			Name string `json:"name"`
+		}
+
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		app.writeJSON(w, r, http.StatusCreated, envelope{"name": input.Name}, nil)
+	})
+
+	// Alice retries her registration, and Bob then happens to send the same key with
+	// a different request.
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		user         string
+		wantReplayed string
+		wantCalls    int
+	}{
+		{"First request", "192.0.2.1:1234", "Alice", "", 1},
+		{"Retry", "192.0.2.1:1234", "Alice", "true", 1},
+		{"Retry from another address", "192.0.2.3:1234", "Alice", "true", 1},
+		{"Same key for another request", "198.51.100.2:1234", "Bob", "", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(`{"name": "`+tt.user+`"}`))
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("Idempotency-Key", "same-key")
+			r = app.contextSetUser(r, data.AnonymousUser)
+
+			rr := httptest.NewRecorder()
+			h(rr, r)
+
+			assert.Equal(t, rr.Code, http.StatusCreated)
+			assert.Equal(t, rr.Header().Get("Idempotent-Replayed"), tt.wantReplayed)
+			assert.StringContains(t, rr.Body.String(), tt.user)
+			assert.Equal(t, calls, tt.wantCalls)
+		})
+	}
+}
+
+// newIdempotencyKeyDB returns a database which keeps the idempotency_keys table in
+// memory, by answering the queries which data.IdempotencyKeyModel makes. Each row holds
+// the created_at, request_hash, status, header and body columns, by user_id and key.
+// Keys don't expire.
+func newIdempotencyKeyDB(t *testing.T) *sql.DB {
+	rows := map[string][]driver.Value{}
+
+	db := &testDB{answer: func(query string, args []driver.Value) ([][]driver.Value, error) {
+		switch {
+		case strings.Contains(query, "INSERT INTO idempotency_keys"):
+			id := fmt.Sprint(args[0], "/", args[1])
+			if _, ok := rows[id]; ok {
+				return nil, nil
+			}
+
+			rows[id] = []driver.Value{time.Now(), args[2], nil, nil, nil}
+			return [][]driver.Value{{rows[id][0]}}, nil
+
+		case strings.Contains(query, "SELECT created_at, request_hash"):
+			row, ok := rows[fmt.Sprint(args[0], "/", args[1])]
+			if !ok {
+				return nil, nil
+			}
+			return [][]driver.Value{append([]driver.Value(nil), row...)}, nil
+
+		case strings.Contains(query, "UPDATE idempotency_keys"):
+			if row, ok := rows[fmt.Sprint(args[3], "/", args[4])]; ok {
+				row[2], row[3], row[4] = args[0], args[1], args[2]
+			}
+			return nil, nil
+
+		case strings.Contains(query, "DELETE FROM idempotency_keys"):
+			delete(rows, fmt.Sprint(args[0], "/", args[1]))
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("unexpected query: %s", query)
+	}}
+
+	return db.open(t)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+// jobHandlerFunc runs a single job, given its JSON payload.
+type jobHandlerFunc func(payload json.RawMessage) error
+
+// The runJobs() method launches the configured number of background workers, which
+// claim jobs from the queue one at a time and run them. A failed job is retried with
+// exponential backoff, and once it has used up all of its attempts it is marked as dead.
+func (app *application) runJobs() {
+	handlers := map[string]jobHandlerFunc{
+		data.JobSendEmail: app.sendEmailJob,
+	}
+
+	// Publish counters for the outcome of each job attempt, alongside the queue depth
+	// which is published in main().
+	succeeded := expvar.NewInt("jobs_succeeded")
+	failed := expvar.NewInt("jobs_failed")
+	dead := expvar.NewInt("jobs_dead")
+
+	// runNext claims and runs a single job, and reports whether there was one.
+	runNext := func() bool {
+		jobs, err := app.models.Jobs.Claim(1, app.config.jobs.lease)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return false
+		}
+
+		if len(jobs) == 0 {
+			return false
+		}
+
+		job := jobs[0]
+
+		err = app.runJob(handlers, job)
+		if err == nil {
+			succeeded.Add(1)
+
+			err = app.models.Jobs.MarkSucceeded(job.ID)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+			return true
+		}
+
+		failed.Add(1)
+
+		app.logger.PrintError(err, map[string]string{
+			"job_id":   fmt.Sprint(job.ID),
+			"kind":     job.Kind,
+			"attempts": fmt.Sprint(job.Attempts),
+		})
+
+		// Work out when to try again. A nil retryAt means the job has run out of
+		// attempts, and will be moved to the dead state.
+		var retryAt *time.Time
+
+		if job.Attempts < app.config.jobs.maxAttempts {
+			t := time.Now().Add(backoff(app.config.jobs.backoff, job.Attempts))
+			retryAt = &t
+		} else {
+			dead.Add(1)
+		}
+
+		err = app.models.Jobs.MarkFailed(job.ID, err.Error(), retryAt)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		return true
+	}
+
+	// Each worker checks the queue every poll interval, and then keeps running jobs until
+	// the queue is empty or the server starts shutting down. A job which has been
+	// started is always finished, so that it isn't left in the running state.
+	for i := 0; i < app.config.jobs.workers; i++ {
+		app.worker(app.config.jobs.pollInterval, func() {
+			for !app.shuttingDown() && runNext() {
+			}
+		})
+	}
+}
+
+// runJob runs a job with the handler for its kind, turning a panic into an error so
+// that a bad job can't take down the worker.
+func (app *application) runJob(handlers map[string]jobHandlerFunc, job *data.Job) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%s", e)
+		}
+	}()
+
+	handler, ok := handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+
+	return handler(job.Payload)
+}
+
+// sendEmailJob sends an email using the recipient, template and data in the payload.
+func (app *application) sendEmailJob(payload json.RawMessage) error {
+	var job data.EmailJob
+
+	// Decode numbers as json.Number rather than float64, so that values such as the
+	// user ID come out in the templates as they went in (and not as 1e+06).
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+
+	err := dec.Decode(&job)
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.Send(job.Recipient, job.Locale, job.Template, job.Data)
+}
+
+// welcomeEmail returns the email job which welcomes a new user and gives them their
+// activation token. As there are multiple pieces of data that we want to pass to the
+// email templates, we use a map to act as a 'holding structure' for the data.
+func welcomeEmail(user *data.User, token *data.Token) data.EmailJob {
+	return data.EmailJob{
+		Recipient: user.Email,
+		Locale:    user.Locale,
+		Template:  "user_welcome.tmpl",
+		Data: map[string]interface{}{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/mailer"
+)
+
+func TestSendEmailJob(t *testing.T) {
+	user := &data.User{ID: 1000000, Email: "alice@example.com"}
+	token := &data.Token{Plaintext: "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}
+
+	frenchUser := *user
+	frenchUser.Locale = "fr"
+
+	tests := []struct {
+		name        string
+		job         data.EmailJob
+		wantSubject string
+		wantBody    []string
+	}{
+		{
+			name:        "Welcome",
+			job:         welcomeEmail(user, token),
+			wantSubject: "Welcome to Greenlight!",
+			wantBody:    []string{`{"token": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}`, "your user ID number is 1000000"},
+		},
+		{
+			name:        "Welcome in French",
+			job:         welcomeEmail(&frenchUser, token),
+			wantSubject: "Bienvenue sur Greenlight !",
+			wantBody:    []string{`{"token": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}`, "votre numéro d'utilisateur est le 1000000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			transport := mailer.NewMemoryTransport()
+
+			var err error
+			app.mailer, err = mailer.New(transport, "Greenlight <no-reply@greenlight.test>", 1, 0)
+			assert.NilError(t, err)
+
+			// Run the job from its JSON payload, as a worker would after claiming it.
+			payload, err := json.Marshal(tt.job)
+			assert.NilError(t, err)
+
+			err = app.sendEmailJob(payload)
+			assert.NilError(t, err)
+
+			messages := transport.Messages()
+			assert.Equal(t, len(messages), 1)
+
+			msg := messages[0]
+			assert.Equal(t, msg.To, user.Email)
+			assert.Equal(t, msg.From, "Greenlight <no-reply@greenlight.test>")
+			assert.Equal(t, msg.Subject, tt.wantSubject)
+
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, msg.PlainBody, want)
+				assert.StringContains(t, msg.HTMLBody, want)
+			}
+		})
+	}
+}
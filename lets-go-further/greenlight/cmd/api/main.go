@@ -1,24 +1,22 @@
 package main
 
 import (
-	"context"
-	"database/sql"
 	"expvar"
 	"flag"
+	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/database"
 	"github.com.go-learning.greenlight/internal/jsonlog"
 	"github.com.go-learning.greenlight/internal/mailer"
-
-	// Import the pq driver so that it can register itself with the database/sql
-	// package. Note that we alias this import to the blank identifier, to stop the Go
-	// compiler complaining that the package isn't being used
-	_ "github.com/lib/pq"
+	"github.com.go-learning.greenlight/internal/openapi"
+	"github.com.go-learning.greenlight/internal/password"
 )
 
 // Declare a string containing the application version number. Later we'll generate
@@ -32,12 +30,14 @@ const version = "1.0.0"
 type config struct {
 	port int
 	env  string
-	db   struct {
-		dsn          string
-		maxOpenConns int
-		maxIdleConns int
-		maxIdleTime  string
-	}
+
+	// When legacyErrors is true, error responses use the old {"error": ...} envelope
+	// rather than RFC 7807 problem details, while clients are being migrated
+	legacyErrors bool
+
+	// The db settings are shared with greenlightctl, so they live in the database
+	// package
+	db database.Config
 
 	// Add a new limiter struct containing fields for the requests-per-second and burst
 	// values, and a boolean field which we can use to enable/disable rate limiting
@@ -55,10 +55,124 @@ type config struct {
 		sender   string
 	}
 
+	// The mail struct selects how emails are delivered: through the SMTP server above,
+	// written as .eml files to a directory, logged to stdout, or kept in memory (for
+	// tests). It also holds how many times the mailer tries to send each email
+	mail struct {
+		transport  string
+		dir        string
+		attempts   int
+		retryDelay time.Duration
+	}
+
+	// The compression struct holds whether responses are compressed, and the smallest
+	// body (in bytes) that is worth compressing
+	compression struct {
+		enabled bool
+		minSize int
+	}
+
 	// Add a cors struct and trustedOrigins field with the type []string
 	cors struct {
 		trustedOrigins []string
 	}
+
+	// The bulk struct holds the settings for the streaming import and export endpoints.
+	// Imports are read row by row, so the body size limit can be far larger than
+	// readJSON()'s 1MB. The import report is built up in memory, so it only lists the
+	// first importReportRows rows
+	bulk struct {
+		importMaxBytes   int64
+		importBatchSize  int
+		importReportRows int
+		exportBatchSize  int
+	}
+
+	// The trash struct holds how long deleted movies are kept before they are purged,
+	// and how often the background purge runs
+	trash struct {
+		retention     time.Duration
+		purgeInterval time.Duration
+	}
+
+	// The webhooks struct holds the settings for the background webhook delivery
+	// worker. The backoff is the wait after the first failed attempt, and doubles after
+	// each attempt after that
+	webhooks struct {
+		pollInterval time.Duration
+		batchSize    int
+		timeout      time.Duration
+		maxAttempts  int
+		backoff      time.Duration
+	}
+
+	// The cache struct holds the settings for the in-memory cache of GET /v1/movies and
+	// GET /v1/movies/:id responses. A response is fresh for ttl, and then sent stale for
+	// up to staleTTL while one request refreshes it. If notify is true the cache is also
+	// emptied whenever any instance of the API changes a movie, using the movie_events
+	// notifications
+	cache struct {
+		enabled    bool
+		maxEntries int
+		ttl        time.Duration
+		staleTTL   time.Duration
+		notify     bool
+	}
+
+	// The events struct holds the number of recent movie events kept in memory, so that
+	// clients reconnecting to GET /v1/movies/events can catch up on what they missed
+	events struct {
+		replaySize int
+	}
+
+	// The auth struct holds the brute-force protection settings for signing in, and for
+	// the password which signed in users confirm changes to their account with. After
+	// delayAfter failures for an account (or ipDelayAfter for an IP address) each failure
+	// blocks further attempts for a delay which doubles every time, up to maxDelay. After
+	// lockoutAfter (or ipLockoutAfter) failures attempts are blocked for
+	// lockoutDuration. Failures are forgotten once there have been none for the length
+	// of the window
+	auth struct {
+		delayAfter      int
+		delay           time.Duration
+		maxDelay        time.Duration
+		lockoutAfter    int
+		lockoutDuration time.Duration
+		ipDelayAfter    int
+		ipLockoutAfter  int
+		window          time.Duration
+	}
+
+	// The password struct holds the policy new passwords have to meet. The minimum score
+	// is from 0 to 4 (see password.Score()), and the blocklist is a gzip-compressed file
+	// of SHA-1 hashes of breached passwords, which replaces the small built-in list
+	password struct {
+		minLength int
+		minScore  int
+		blocklist string
+	}
+
+	// The openapi struct holds whether every response is checked against the OpenAPI
+	// document, with any mismatches logged
+	openapi struct {
+		validate bool
+	}
+
+	// The jobs struct holds the settings for the background job workers. A claimed job
+	// is only picked up again by another worker once its lease has run out
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+		lease        time.Duration
+		maxAttempts  int
+		backoff      time.Duration
+	}
+
+	// The idempotency struct holds how long an Idempotency-Key, and the response stored
+	// with it, is remembered. A ttl of 0 turns off idempotency keys
+	idempotency struct {
+		ttl time.Duration
+	}
 }
 
 // Define an application struct to hold the depedencies for our HTTP handlers, helpers,
@@ -75,6 +189,19 @@ type application struct {
 	// sync.WaitGroup type is a valid, useable, sync.WaitGroup with a 'counter' value of 0,
 	// so we don't need to do anything else to initialize it before we can use it
 	wg sync.WaitGroup
+	// The shutdown channel is closed when the server starts shutting down, which tells
+	// the background workers to stop
+	shutdown chan struct{}
+	// The events broker fans movie change notifications out to the clients of
+	// GET /v1/movies/events
+	events *movieEventBroker
+	// The movie cache holds recent responses from the movie read endpoints
+	movieCache *responseCache
+	// The password policy is checked whenever a user chooses a new password
+	passwords *password.Policy
+	// The OpenAPI document describing the API, which responses are checked against when
+	// the -openapi-validate flag is set
+	spec *openapi.Document
 }
 
 func main() {
@@ -85,16 +212,11 @@ func main() {
 	// flags are provided
 	flag.IntVar(&cfg.port, "port", 4000, "API server port")
 	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	flag.BoolVar(&cfg.legacyErrors, "legacy-errors", false, "Send error responses in the legacy {\"error\": ...} format instead of application/problem+json")
 
-	// Read the DSN value from the db-dsn command-line flag into the config struct. We
-	// default to using our development DSN if no flag is provided.
-	// Use the value of the GREENLIGHT_DB_DSN environment variable as the default value
-	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PosgreSQL DSN")
-
-	// Read the connection pool settings from command-line flags into the config struct.
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PosgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL mex connection idle time")
+	// Read the DSN and connection pool settings from the -db-* command-line flags into
+	// the config struct. The DSN defaults to the GREENLIGHT_DB_DSN environment variable.
+	cfg.db.RegisterFlags(flag.CommandLine)
 
 	// Create command line flags to read the setting values into the config struct.
 	// Notice that we use true as the default for the 'enabled' setting
@@ -110,6 +232,17 @@ func main() {
 	flag.StringVar(&cfg.smtp.password, "smtp-password", "random", "SMTP password")
 	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@tiberiualex.github.io>", "SMTP sender")
 
+	// Read the mail transport settings into the config struct. The file and log
+	// transports mean that no mail server is needed for local development.
+	flag.StringVar(&cfg.mail.transport, "mail-transport", "smtp", "Mail transport (smtp|file|log|memory, which keeps messages in memory for tests)")
+	flag.StringVar(&cfg.mail.dir, "mail-dir", "tmp/mail", "Directory that the file mail transport writes .eml files to")
+	flag.IntVar(&cfg.mail.attempts, "mail-attempts", 3, "Number of times the mailer tries to send each email")
+	flag.DurationVar(&cfg.mail.retryDelay, "mail-retry-delay", 500*time.Millisecond, "Wait between the mailer's attempts to send an email")
+
+	// Read the response compression settings into the config struct.
+	flag.BoolVar(&cfg.compression.enabled, "compression-enabled", true, "Compress responses for clients which accept brotli, gzip or deflate")
+	flag.IntVar(&cfg.compression.minSize, "compression-min-size", 1024, "Smallest response body in bytes that is compressed")
+
 	// Use the flag.Func() function to process the -cors-trusted-origins command line
 	// flag. In this we use the strings.FIelds() function to split the flag value into a
 	// slice based on twhitespace characters and assign it to our config struct.
@@ -121,6 +254,69 @@ func main() {
 		return nil
 	})
 
+	// Read the bulk import settings into the config struct.
+	flag.Int64Var(&cfg.bulk.importMaxBytes, "import-max-bytes", 64<<20, "Maximum size of a movie import body in bytes")
+	flag.IntVar(&cfg.bulk.importBatchSize, "import-batch-size", 500, "Number of movies inserted per batch during an import (1-10000)")
+	flag.IntVar(&cfg.bulk.importReportRows, "import-report-rows", 10000, "Maximum number of rows listed in a movie import report (later rows are only counted)")
+	flag.IntVar(&cfg.bulk.exportBatchSize, "export-batch-size", 500, "Number of movies fetched (and flushed to the client) per batch during an export")
+
+	// Read the trash settings into the config struct. A retention of 0 keeps trashed
+	// movies until they are purged by hand.
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept in the trash (0 to keep forever)")
+	flag.DurationVar(&cfg.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often expired movies are purged from the trash")
+
+	// Read the webhook delivery settings into the config struct.
+	flag.DurationVar(&cfg.webhooks.pollInterval, "webhook-poll-interval", 5*time.Second, "How often the webhook delivery queue is checked for due deliveries")
+	flag.IntVar(&cfg.webhooks.batchSize, "webhook-batch-size", 20, "Maximum number of webhook deliveries sent per poll")
+	flag.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "Timeout for a single webhook delivery attempt")
+	flag.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 8, "Number of attempts before a webhook delivery is marked as dead")
+	flag.DurationVar(&cfg.webhooks.backoff, "webhook-backoff", 30*time.Second, "Wait before retrying a failed webhook delivery (doubled after each attempt)")
+
+	// Read the response cache settings into the config struct.
+	flag.BoolVar(&cfg.cache.enabled, "cache-enabled", true, "Cache movie responses in memory")
+	flag.IntVar(&cfg.cache.maxEntries, "cache-max-entries", 1000, "Maximum number of cached movie responses")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", 30*time.Second, "How long a cached movie response is fresh")
+	flag.DurationVar(&cfg.cache.staleTTL, "cache-stale-ttl", 30*time.Second, "How long a cached movie response can be sent stale while it is refreshed")
+	flag.BoolVar(&cfg.cache.notify, "cache-notify", true, "Empty the cache when another instance changes a movie (using Postgres NOTIFY)")
+
+	// Read the movie event stream settings into the config struct.
+	flag.IntVar(&cfg.events.replaySize, "events-replay-size", 1000, "Number of recent movie events kept for clients resuming with Last-Event-ID")
+
+	// Read the brute-force protection settings into the config struct. A threshold of 0
+	// turns that delay or lockout off.
+	flag.IntVar(&cfg.auth.delayAfter, "auth-delay-after", 3, "Failed sign ins for an account before attempts are delayed")
+	flag.DurationVar(&cfg.auth.delay, "auth-delay", time.Second, "First delay after repeated failed sign ins (doubled after each failure)")
+	flag.DurationVar(&cfg.auth.maxDelay, "auth-max-delay", time.Minute, "Longest delay after repeated failed sign ins")
+	flag.IntVar(&cfg.auth.lockoutAfter, "auth-lockout-after", 10, "Failed sign ins before an account is locked")
+	flag.DurationVar(&cfg.auth.lockoutDuration, "auth-lockout-duration", 15*time.Minute, "How long an account or IP address stays locked")
+	flag.IntVar(&cfg.auth.ipDelayAfter, "auth-ip-delay-after", 20, "Failed sign ins from an IP address before attempts are delayed")
+	flag.IntVar(&cfg.auth.ipLockoutAfter, "auth-ip-lockout-after", 100, "Failed sign ins before an IP address is locked")
+	flag.DurationVar(&cfg.auth.window, "auth-failure-window", time.Hour, "How long failed sign ins are remembered")
+
+	// Read the password policy settings into the config struct. Passwords are always
+	// at least 8 bytes long, whatever the minimum length is set to. The two settings
+	// work together: a score of 2 needs about 20 bits of entropy, which an 8 character
+	// password only reaches if it mixes upper case letters with digits or symbols, so
+	// with the defaults other passwords need at least 10 characters.
+	flag.IntVar(&cfg.password.minLength, "password-min-length", 8, "Minimum length of new passwords in bytes (the -password-min-score check can require more)")
+	flag.IntVar(&cfg.password.minScore, "password-min-score", 2, "Minimum strength score of new passwords (0-4); 2 needs 10 characters, or 8 mixing upper case with digits or symbols")
+	flag.StringVar(&cfg.password.blocklist, "password-blocklist", "", "Gzip-compressed file of SHA-1 hashes of breached passwords (defaults to a built-in list)")
+
+	// Read the OpenAPI validation setting into the config struct. This is meant for
+	// integration testing and staging, as every response body is held in memory while it
+	// is checked.
+	flag.BoolVar(&cfg.openapi.validate, "openapi-validate", false, "Check every response against the OpenAPI document and log any which don't match")
+
+	// Read the background job settings into the config struct.
+	flag.IntVar(&cfg.jobs.workers, "job-workers", 4, "Number of background job workers")
+	flag.DurationVar(&cfg.jobs.pollInterval, "job-poll-interval", time.Second, "How often an idle worker checks the job queue")
+	flag.DurationVar(&cfg.jobs.lease, "job-lease", 2*time.Minute, "How long a claimed job is hidden from other workers")
+	flag.IntVar(&cfg.jobs.maxAttempts, "job-max-attempts", 10, "Number of attempts before a job is marked as dead")
+	flag.DurationVar(&cfg.jobs.backoff, "job-backoff", 10*time.Second, "Wait before retrying a failed job (doubled after each attempt)")
+
+	// Read the idempotency key setting into the config struct.
+	flag.DurationVar(&cfg.idempotency.ttl, "idempotency-key-ttl", 24*time.Hour, "How long Idempotency-Key headers and their responses are remembered (0 to ignore the header)")
+
 	flag.Parse()
 
 	// Initialize a new logger which writes messages to the standard out stream,
@@ -131,10 +327,17 @@ func main() {
 	// severity level to the standard out stream
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
-	// Call the openDB() helper function to create the connection pool,
+	// Check the settings which would break the application if they were out of range,
+	// before anything is started
+	err := validateConfig(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// Call the database.Open() function to create the connection pool,
 	// passing in the config struct. If this returns an error, we log it and exit the
 	// application immediately
-	db, err := openDB(cfg)
+	db, err := database.Open(cfg.db)
 	if err != nil {
 		// Use the PrintFatal() method to write a log entry containing the error at the
 		// FATAL level and exit. We have no additional properties to include in the log
@@ -150,6 +353,37 @@ func main() {
 	// established
 	logger.PrintInfo("database connection pool established", nil)
 
+	// Create the mail transport selected by the -mail-transport flag, and a Mailer which
+	// uses it. The email templates are parsed here, so a broken template stops the
+	// application from starting rather than failing when an email is sent.
+	transport, err := newMailTransport(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	emailer, err := mailer.New(transport, cfg.smtp.sender, cfg.mail.attempts, cfg.mail.retryDelay)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// Load the breached password blocklist. Like the email templates, this is done once
+	// at startup, and nothing is ever fetched over the network.
+	blocklist, err := loadPasswordBlocklist(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	logger.PrintInfo("password blocklist loaded", map[string]string{
+		"hashes": strconv.Itoa(blocklist.Len()),
+	})
+
+	// Parse the embedded OpenAPI document, which also checks that its references all
+	// resolve.
+	spec, err := openapi.Load()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	// Publish a new "version" variable in the expvar handler containing our application
 	// version number (currently the constant "1.0.0")
 	expvar.NewString("version").Set(version)
@@ -177,55 +411,116 @@ func main() {
 		// Use the data.NewModels() function to initialize a Models struct, passing in the
 		// connection pool as a parameter
 		models: data.NewModels(db),
-		// Initialize a new Mailer instance using the settings from the command line
-		// flags, and add it to the application struct
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		// Add the Mailer instance, built from the settings in the command line flags, to
+		// the application struct
+		mailer:     emailer,
+		shutdown:   make(chan struct{}),
+		events:     newMovieEventBroker(cfg.events.replaySize),
+		movieCache: newResponseCache(cfg.cache.maxEntries, cfg.cache.ttl, cfg.cache.staleTTL),
+		passwords: &password.Policy{
+			MinLength: cfg.password.minLength,
+			MinScore:  cfg.password.minScore,
+			Blocklist: blocklist,
+		},
+		spec: spec,
 	}
 
+	// Publish the number of pending (and due) and dead jobs in the job queue. This reads
+	// from the models, so can only be published once the application struct exists.
+	expvar.Publish("jobs", expvar.Func(func() interface{} {
+		stats, err := app.models.Jobs.Stats()
+		if err != nil {
+			return nil
+		}
+		return stats
+	}))
+
+	// Publish the movie response cache's hit, miss, stale serve and invalidation counts.
+	expvar.Publish("movie_cache", expvar.Func(func() interface{} {
+		return app.movieCache.stats()
+	}))
+
+	// Start listening for movie changes made by any instance of the API, so that they can
+	// be pushed to event stream clients.
+	err = app.listenMovieEvents()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	// Start purging expired movies from the trash in the background.
+	app.purgeTrash()
+
+	// Start sending queued webhook deliveries in the background.
+	app.deliverWebhooks()
+
+	// Start removing expired failed sign in records in the background.
+	app.cleanupAuthFailures()
+
+	// Start removing expired idempotency keys in the background.
+	app.cleanupIdempotencyKeys()
+
+	// Start the background job workers.
+	app.runJobs()
+
 	err = app.serve()
 	logger.PrintFatal(err, nil)
 }
 
-// Returns a sql.DB connection pool
-func openDB(cfg config) (*sql.DB, error) {
-	// Use the sql.Open() to create an empty connection pool, using the DSN from the config
-	// struct
-	db, err := sql.Open("postgres", cfg.db.dsn)
-	if err != nil {
-		return nil, err
+// validateConfig checks the settings which can't be used as given.
+func validateConfig(cfg config) error {
+	if cfg.bulk.importBatchSize < 1 || cfg.bulk.importBatchSize > data.MaxInsertBatch {
+		return fmt.Errorf("-import-batch-size must be between 1 and %d", data.MaxInsertBatch)
 	}
 
-	// Set the maximum number of open (in-use + idle) connections in the pool. Note that
-	// passing a value less than or equal to 0 will mean there is no limit.
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+	if cfg.bulk.importReportRows < 1 {
+		return fmt.Errorf("-import-report-rows must be greater than zero")
+	}
 
-	// Set the maximum number of idle conections in the pool. Again, passing a value
-	// less than or equal to 0 will mean there is no limit.
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+	if cfg.bulk.exportBatchSize < 1 {
+		return fmt.Errorf("-export-batch-size must be greater than zero")
+	}
 
-	// Use the time.ParseDuration() function to convert the idle timeout duration string
-	// to a time.Duration type.
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
-	if err != nil {
-		return nil, err
+	if cfg.trash.purgeInterval <= 0 {
+		return fmt.Errorf("-trash-purge-interval must be greater than zero")
 	}
 
-	// Set the maximum idle timeout.
-	db.SetConnMaxIdleTime(duration)
+	if cfg.webhooks.pollInterval <= 0 {
+		return fmt.Errorf("-webhook-poll-interval must be greater than zero")
+	}
 
-	// Create a context with a 5-second timeout deadline
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	if cfg.jobs.workers < 1 {
+		return fmt.Errorf("-job-workers must be greater than zero")
+	}
 
-	// Use PingContext() to establish a new connection to the database, passing it the
-	// context we created above as a parameter. If the connection couldn't be
-	// established successfully within the 5 second deadline, then this will return an
-	// error
-	err = db.PingContext(ctx)
-	if err != nil {
-		return nil, err
+	if cfg.jobs.pollInterval <= 0 {
+		return fmt.Errorf("-job-poll-interval must be greater than zero")
+	}
+
+	return nil
+}
+
+// newMailTransport returns the mail transport selected in the config.
+func newMailTransport(cfg config) (mailer.Transport, error) {
+	switch cfg.mail.transport {
+	case "smtp":
+		return mailer.NewSMTPTransport(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password), nil
+	case "file":
+		return mailer.NewFileTransport(cfg.mail.dir)
+	case "log":
+		return mailer.NewLogTransport(os.Stdout), nil
+	case "memory":
+		return mailer.NewMemoryTransport(), nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.mail.transport)
+	}
+}
+
+// loadPasswordBlocklist returns the breached password blocklist from the file given in
+// the -password-blocklist flag, or the built-in list if there isn't one.
+func loadPasswordBlocklist(cfg config) (*password.Blocklist, error) {
+	if cfg.password.blocklist == "" {
+		return password.DefaultBlocklist()
 	}
 
-	// Return the sql.DB connection pool
-	return db, nil
+	return password.LoadBlocklistFile(cfg.password.blocklist)
 }
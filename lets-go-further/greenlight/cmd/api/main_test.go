@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+func TestValidateConfig(t *testing.T) {
+	// validConfig returns a config with the default values of the settings which
+	// validateConfig() checks.
+	validConfig := func() config {
+		var cfg config
+		cfg.bulk.importBatchSize = 500
+		cfg.bulk.importReportRows = 10000
+		cfg.bulk.exportBatchSize = 500
+		cfg.trash.purgeInterval = time.Hour
+		cfg.webhooks.pollInterval = 5 * time.Second
+		cfg.jobs.workers = 4
+		cfg.jobs.pollInterval = time.Second
+		return cfg
+	}
+
+	tests := []struct {
+		name    string
+		change  func(cfg *config)
+		wantErr string
+	}{
+		{
+			name:   "Defaults",
+			change: func(cfg *config) {},
+		},
+		{
+			name:   "Largest import batch",
+			change: func(cfg *config) { cfg.bulk.importBatchSize = 10000 },
+		},
+		{
+			name:    "Zero import batch",
+			change:  func(cfg *config) { cfg.bulk.importBatchSize = 0 },
+			wantErr: "-import-batch-size must be between 1 and 10000",
+		},
+		{
+			name:    "Import batch over the parameter limit",
+			change:  func(cfg *config) { cfg.bulk.importBatchSize = 20000 },
+			wantErr: "-import-batch-size must be between 1 and 10000",
+		},
+		{
+			name:    "Zero import report rows",
+			change:  func(cfg *config) { cfg.bulk.importReportRows = 0 },
+			wantErr: "-import-report-rows must be greater than zero",
+		},
+		{
+			name:    "Zero export batch",
+			change:  func(cfg *config) { cfg.bulk.exportBatchSize = 0 },
+			wantErr: "-export-batch-size must be greater than zero",
+		},
+		{
+			name:    "Zero trash purge interval",
+			change:  func(cfg *config) { cfg.trash.purgeInterval = 0 },
+			wantErr: "-trash-purge-interval must be greater than zero",
+		},
+		{
+			name:    "Negative trash purge interval",
+			change:  func(cfg *config) { cfg.trash.purgeInterval = -time.Minute },
+			wantErr: "-trash-purge-interval must be greater than zero",
+		},
+		{
+			name:    "Zero webhook poll interval",
+			change:  func(cfg *config) { cfg.webhooks.pollInterval = 0 },
+			wantErr: "-webhook-poll-interval must be greater than zero",
+		},
+		{
+			name:    "No job workers",
+			change:  func(cfg *config) { cfg.jobs.workers = 0 },
+			wantErr: "-job-workers must be greater than zero",
+		},
+		{
+			name:    "Zero job poll interval",
+			change:  func(cfg *config) { cfg.jobs.pollInterval = 0 },
+			wantErr: "-job-poll-interval must be greater than zero",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.change(&cfg)
+
+			err := validateConfig(cfg)
+
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("got no error; want %q", tt.wantErr)
+			}
+			assert.Equal(t, err.Error(), tt.wantErr)
+		})
+	}
+}
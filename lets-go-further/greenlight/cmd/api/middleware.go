@@ -105,8 +105,19 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 			// the request isn't allowed, unlock the mutex and send a 429 Too Many Requests
 			// response
 			if !clients[ip].limiter.Allow() {
+				// Find out how long until a token is available by reserving one, and then
+				// hand it straight back so that it can still be used. A reservation can
+				// only fail if the burst is 0, when no request is ever allowed.
+				retryAfter := time.Minute
+
+				reservation := clients[ip].limiter.Reserve()
+				if reservation.OK() {
+					retryAfter = reservation.Delay()
+					reservation.Cancel()
+				}
+
 				mu.Unlock()
-				app.rateLimitExceededResponse(w, r)
+				app.rateLimitExceededResponse(w, r, retryAfter)
 				return
 			}
 
@@ -240,6 +251,7 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
+			return
 		}
 
 		// Check if the slice includes the required permission. If it doesn't, then
@@ -250,7 +262,8 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 		}
 
 		// Otherwise they have the required permission so we call the next handler in
-		// the chain
+		// the chain, with the user's permissions in the request context
+		r = app.contextSetPermissions(r, permissions)
 		next.ServeHTTP(w, r)
 	}
 
@@ -284,7 +297,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 						// Set the necessary preflight response headers, as discussed
 						// previously
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key")
 
 						// Write the headers along with a 200 OK status and return from
 						// the middleware with no further action.
@@ -303,13 +316,18 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 }
 
 func (app *application) metrics(next http.Handler) http.Handler {
-	// Initialize the new expvar variables when the middleware chain is first built/registered
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSent := expvar.NewInt("total_responses_sent")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_micros")
+	// Initialize the new expvar variables when the middleware chain is first built/registered.
+	// If the chain is built more than once (as the tests do), the existing variables are
+	// reused, as expvar panics if a name is published twice
+	totalRequestsReceived := expvarInt("total_requests_received")
+	totalResponsesSent := expvarInt("total_responses_sent")
+	totalProcessingTimeMicroseconds := expvarInt("total_processing_time_micros")
 	// Declare a new expvar map to hold the count of responses for each HTTP status
 	// code
-	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status")
+	totalResponsesSentByStatus, ok := expvar.Get("total_responses_sent_by_status").(*expvar.Map)
+	if !ok {
+		totalResponsesSentByStatus = expvar.NewMap("total_responses_sent_by_status")
+	}
 
 	// The following cxode will be run for every request...
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -321,12 +339,10 @@ func (app *application) metrics(next http.Handler) http.Handler {
 
 		// Call the httpsnoop.CaptureMetrics() function, passing in the next handler in
 		// the chain along with the existing http.ResponseWriter and http.Request. This
-		// returns the metrics struct that we saw above
+		// calls the next handler in the chain and returns the metrics struct that we saw
+		// above, so the handler mustn't be called again here
 		metrics := httpsnoop.CaptureMetrics(next, w, r)
 
-		// Call the next handler in the chain
-		next.ServeHTTP(w, r)
-
 		// On the way back up the middleware chain, increment the number of responses
 		// sent by 1
 		totalResponsesSent.Add(1)
@@ -342,3 +358,13 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
 	})
 }
+
+// expvarInt returns the published expvar.Int with the given name, publishing a new one if
+// there isn't one yet.
+func expvarInt(name string) *expvar.Int {
+	if v, ok := expvar.Get(name).(*expvar.Int); ok {
+		return v
+	}
+
+	return expvar.NewInt(name)
+}
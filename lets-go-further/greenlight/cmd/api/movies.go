@@ -2,10 +2,11 @@ package main
 
 import (
 	"errors"
-	"fmt"
+	"mime"
 	"net/http"
 
 	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/jsonpatch"
 	"github.com.go-learning.greenlight/internal/validator"
 )
 
@@ -63,7 +64,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// Call the Insert() method on our movies model, passing in a pointer to the
 	// validated movie struct. This will create a record in the database and update the
 	// movie struct with the system-generated information
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -74,11 +75,11 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// empty http.Header map and then use the Set() method to add a new Location header,
 	// interpolating the system-generated ID for our new movie in the URL.
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", app.versionedPath(r, "/movies/%d", movie.ID))
 
 	// Write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -105,9 +106,9 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Create an envelope{"movie": movie} instance and pass it to writeJSON() instead
+	// Create an envelope{"movie": movie} instance and pass it to writeResponse() instead
 	// of passing the plain movie struct
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.logger.PrintError(err, nil)
 		app.serverErrorResponse(w, r, err)
@@ -135,53 +136,84 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Declare an input struct to hold the expected data from the client.
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
+	v := validator.New()
 
-	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
+	// As well as the JSON object of fields to change, which this endpoint has always
+	// accepted, the changes can be sent as a JSON Merge Patch (RFC 7396) or a JSON Patch
+	// (RFC 6902). These can clear fields, add or remove single genres, and check values
+	// with test operations before changing them.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case contentTypeMergePatch, contentTypeJSONPatch:
+		patch, err := app.readBody(w, r)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 
-	// Copy the values from the request body to the appropriate fields of the movie
-	// record.
-	// If the input.Title value is nil, then we that know no corresponding "title" key/
-	// /value pair was provided in the JSON request body. So we move on and leave the
-	// movie record unchanged. Otherwise, we update the movie record with the new title
-	// value. Importantly, because input.Title is now a pointer to a string, we need to
-	// dereference the pointer using the * operator to get the underlying value
-	// before assigning it to our movie record.
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres // Note that we don't need to dereference a slice.
+		err = applyMoviePatch(app.contextGetAPIVersion(r), movie, mediaType, patch, v)
+		if err != nil {
+			switch {
+			case errors.Is(err, jsonpatch.ErrInvalidPatch):
+				app.badRequestResponse(w, r, err)
+			case errors.Is(err, jsonpatch.ErrTestFailed):
+				app.patchTestFailedResponse(w, r, err)
+			case errors.Is(err, jsonpatch.ErrNotApplicable), errors.Is(err, errUnprocessablePatch):
+				app.unprocessablePatchResponse(w, r, err)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+	default:
+		// Declare an input struct to hold the expected data from the client.
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+
+		// Read the JSON request body data into the input struct.
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		// Copy the values from the request body to the appropriate fields of the movie
+		// record.
+		// If the input.Title value is nil, then we that know no corresponding "title" key/
+		// /value pair was provided in the JSON request body. So we move on and leave the
+		// movie record unchanged. Otherwise, we update the movie record with the new title
+		// value. Importantly, because input.Title is now a pointer to a string, we need to
+		// dereference the pointer using the * operator to get the underlying value
+		// before assigning it to our movie record.
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+		if input.Genres != nil {
+			movie.Genres = input.Genres // Note that we don't need to dereference a slice.
+		}
 	}
 
 	// Valdiate the update movie record, sending the client to a 422 Unprocessable Entity
 	// response if any checks fail.
-	v := validator.New()
-
 	if data.ValidateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// Pass the updated movie record to our new Update() method.
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	// Intercept any ErrEditConflict error and call the new editConflictResponse() helper
 	if err != nil {
 		switch {
@@ -194,7 +226,7 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -210,7 +242,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Delete the movie from the database, sending a 404 Not Found response to the
 	// client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(id, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -222,7 +254,7 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -277,9 +309,9 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Send a JSON response containing the movie data.
-	// Include the metadata in the response envelope
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	// Send a response containing the movie data, including the metadata in the
+	// response envelope. The list can be sent as JSON, MessagePack, NDJSON or CSV.
+	err = app.writeList(w, r, envelope{"movies": movies, "metadata": metadata}, "movies", moviesCSV(movies))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
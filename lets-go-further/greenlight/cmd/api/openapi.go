@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com.go-learning.greenlight/internal/openapi"
+	"github.com/felixge/httpsnoop"
+)
+
+// validateResponseMaxBytes is the most of a response body that the validateResponses()
+// middleware keeps. Longer responses (such as exports and event streams, which aren't
+// JSON anyway) only have their status code and content type checked.
+const validateResponseMaxBytes = 1 << 20
+
+// The openAPIHandler sends the OpenAPI document which describes the API. It is sent as
+// it is embedded, rather than through writeResponse(), so it is always JSON.
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.write(w, http.StatusOK, contentTypeJSON, openapi.JSON(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The docsHandler sends a page which renders the OpenAPI document as documentation.
+func (app *application) docsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.write(w, http.StatusOK, "text/html; charset=utf-8", openapi.DocsHTML(), nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The validateResponses() middleware checks every response against the OpenAPI
+// document, and logs an error for any which don't match it. It is turned on with the
+// -openapi-validate flag when running integration tests or in staging, so that a
+// handler changing its responses without the document being updated is noticed. The
+// response is still sent to the client as it is.
+//
+// It sits inside the compress() middleware, so that it sees the uncompressed body.
+func (app *application) validateResponses(next http.Handler) http.Handler {
+	if !app.config.openapi.validate {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			status    int
+			body      bytes.Buffer
+			truncated bool
+		)
+
+		// httpsnoop keeps the interfaces implemented by the ResponseWriter, such as
+		// http.Flusher for the event stream.
+		w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(next httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					if status == 0 {
+						status = code
+					}
+					next(code)
+				}
+			},
+			Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					if status == 0 {
+						status = http.StatusOK
+					}
+
+					if body.Len()+len(b) > validateResponseMaxBytes {
+						truncated = true
+					} else if !truncated {
+						body.Write(b)
+					}
+
+					return next(b)
+				}
+			},
+		})
+
+		next.ServeHTTP(w, r)
+
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		checked := body.Bytes()
+		if truncated {
+			checked = nil
+		}
+
+		err := app.spec.ValidateResponse(r.Method, r.URL.Path, status, w.Header().Get("Content-Type"), checked)
+		if err != nil {
+			app.logError(r, err)
+		}
+	})
+}
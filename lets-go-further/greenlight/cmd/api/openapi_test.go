@@ -0,0 +1,322 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/jsonlog"
+)
+
+// registeredRouteRX matches the router.HandlerFunc() and router.Handler() calls in
+// routes.go, capturing the method and path.
+var registeredRouteRX = regexp.MustCompile(`router\.Handler(?:Func)?\(http\.Method(\w+), "([^"]+)"`)
+
+// matchRoute reports whether a path template from the OpenAPI document (with {name}
+// parameters) matches a route registered with httprouter (with :name parameters). A
+// parameter in the registered route matches any segment, as the routes dispatched by
+// dispatchParam() (such as /v1/movies/export) are registered as /v1/movies/:id.
+func matchRoute(registered, documented string) bool {
+	r := strings.Split(registered, "/")
+	d := strings.Split(documented, "/")
+
+	if len(r) != len(d) {
+		return false
+	}
+
+	for i := range r {
+		switch {
+		case strings.HasPrefix(r[i], ":"):
+		case strings.HasPrefix(d[i], "{"):
+			return false
+		case r[i] != d[i]:
+			return false
+		}
+	}
+
+	return true
+}
+
+// TestOpenAPIRoutes checks that every route registered in routes.go is described by
+// the OpenAPI document, and that everything in the document is served by a registered
+// route.
+func TestOpenAPIRoutes(t *testing.T) {
+	app := newTestApplication(t)
+
+	source, err := os.ReadFile("routes.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type route struct{ method, path string }
+
+	var registered []route
+	for _, match := range registeredRouteRX.FindAllStringSubmatch(string(source), -1) {
+		registered = append(registered, route{strings.ToUpper(match[1]), match[2]})
+	}
+
+	if len(registered) == 0 {
+		t.Fatal("found no routes in routes.go")
+	}
+
+	documented := app.spec.Routes()
+
+	for _, r := range registered {
+		found := false
+		for _, d := range documented {
+			if d.Method == r.method && matchRoute(r.path, d.Path) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Errorf("%s %s is registered but not in the OpenAPI document", r.method, r.path)
+		}
+	}
+
+	for _, d := range documented {
+		found := false
+		for _, r := range registered {
+			if d.Method == r.method && matchRoute(r.path, d.Path) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Errorf("%s %s is in the OpenAPI document but isn't registered", d.Method, d.Path)
+		}
+	}
+}
+
+// TestOpenAPIResponses sends requests to the live handlers and checks that the
+// responses match the OpenAPI document. There is no database in the tests, so the
+// requests are ones which are answered before the database would be used.
+func TestOpenAPIResponses(t *testing.T) {
+	tests := []struct {
+		name         string
+		legacyErrors bool
+		method       string
+		urlPath      string
+		headers      http.Header
+		body         string
+		wantCode     int
+	}{
+		{
+			name:     "Healthcheck",
+			method:   http.MethodGet,
+			urlPath:  "/v1/healthcheck",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Healthcheck pretty",
+			method:   http.MethodGet,
+			urlPath:  "/v1/healthcheck?pretty",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Healthcheck MessagePack",
+			method:   http.MethodGet,
+			urlPath:  "/v1/healthcheck",
+			headers:  http.Header{"Accept": {"application/msgpack"}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Healthcheck not acceptable",
+			method:   http.MethodGet,
+			urlPath:  "/v1/healthcheck",
+			headers:  http.Header{"Accept": {"text/html"}},
+			wantCode: http.StatusNotAcceptable,
+		},
+		{
+			name:     "OpenAPI document",
+			method:   http.MethodGet,
+			urlPath:  "/v1/openapi.json",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Docs page",
+			method:   http.MethodGet,
+			urlPath:  "/v1/docs",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Not found",
+			method:   http.MethodGet,
+			urlPath:  "/v1/missing",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Method not allowed",
+			method:   http.MethodPut,
+			urlPath:  "/v1/healthcheck",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name:     "Create movie without authentication",
+			method:   http.MethodPost,
+			urlPath:  "/v1/movies",
+			body:     `{"title": "Casablanca", "year": 1942, "runtime": "102 mins", "genres": ["drama"]}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Create v2 movie without authentication",
+			method:   http.MethodPost,
+			urlPath:  "/v2/movies",
+			body:     `{"title": "Casablanca", "year": 1942, "runtime": 102, "genres": ["drama"]}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Show v2 movie without authentication",
+			method:   http.MethodGet,
+			urlPath:  "/v2/movies/1",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Import movies without authentication",
+			method:   http.MethodPost,
+			urlPath:  "/v1/movies/import",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "List movies with an invalid token",
+			method:   http.MethodGet,
+			urlPath:  "/v1/movies",
+			headers:  http.Header{"Authorization": {"Bearer not-a-token"}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Show current user without authentication",
+			method:   http.MethodGet,
+			urlPath:  "/v1/users/me",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Register user with badly-formed JSON",
+			method:   http.MethodPost,
+			urlPath:  "/v1/users",
+			body:     `{"name": `,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Register user with invalid fields",
+			method:   http.MethodPost,
+			urlPath:  "/v1/users",
+			body:     `{"name": "", "email": "not-an-email", "password": "pa55"}`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Register user with a too long idempotency key",
+			method:   http.MethodPost,
+			urlPath:  "/v1/users",
+			headers:  http.Header{"Idempotency-Key": {strings.Repeat("k", 256)}},
+			body:     `{"name": "Alice", "email": "alice@example.com", "password": "pa55word"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Sign in with invalid fields",
+			method:   http.MethodPost,
+			urlPath:  "/v1/tokens/authentication",
+			body:     `{"email": "not-an-email", "password": ""}`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:     "Activate user with an invalid token",
+			method:   http.MethodPut,
+			urlPath:  "/v1/users/activated",
+			body:     `{"token": "short"}`,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "Legacy not found",
+			legacyErrors: true,
+			method:       http.MethodGet,
+			urlPath:      "/v1/missing",
+			wantCode:     http.StatusNotFound,
+		},
+		{
+			name:         "Legacy validation errors",
+			legacyErrors: true,
+			method:       http.MethodPost,
+			urlPath:      "/v1/users",
+			body:         `{"name": "", "email": "not-an-email", "password": "pa55"}`,
+			wantCode:     http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.legacyErrors = tt.legacyErrors
+
+			ts := newTestServer(t, app.routes())
+
+			code, header, body := ts.do(t, tt.method, tt.urlPath, tt.headers, tt.body)
+
+			assert.Equal(t, code, tt.wantCode)
+
+			path := strings.SplitN(tt.urlPath, "?", 2)[0]
+			assert.NilError(t, app.spec.ValidateResponse(tt.method, path, code, header.Get("Content-Type"), body))
+		})
+	}
+}
+
+// TestValidateResponsesMiddleware checks that the -openapi-validate middleware logs a
+// response which doesn't match the document, and leaves one which does alone.
+func TestValidateResponsesMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantLog bool
+	}{
+		{
+			name: "Matching",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"status": "available", "system_info": {"environment": "testing", "version": "1.0.0"}}`))
+			},
+			wantLog: false,
+		},
+		{
+			name: "Missing property",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"status": "available"}`))
+			},
+			wantLog: true,
+		},
+		{
+			name: "Undocumented status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			wantLog: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log strings.Builder
+
+			app := newTestApplication(t)
+			app.config.openapi.validate = true
+			app.logger = jsonlog.New(&log, jsonlog.LevelInfo)
+
+			rr := httptest.NewRecorder()
+
+			r, err := http.NewRequest(http.MethodGet, "/v1/healthcheck", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			app.validateResponses(tt.handler).ServeHTTP(rr, r)
+
+			assert.Equal(t, strings.Contains(log.String(), "doesn't match the document"), tt.wantLog)
+		})
+	}
+}
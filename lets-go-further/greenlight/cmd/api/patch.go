@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/jsonpatch"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+// errUnprocessablePatch is returned by applyMoviePatch() when a patch applies cleanly but
+// the result isn't a movie, such as when a field has the wrong type.
+var errUnprocessablePatch = errors.New("the patched movie is invalid")
+
+// movieEditableFields are the fields of a movie which a patch can change. Every other
+// field in the patched document, such as the ID and version, must be left as it was.
+var movieEditableFields = map[string]bool{"title": true, "year": true, "runtime": true, "genres": true}
+
+// applyMoviePatch applies a JSON Merge Patch or JSON Patch document to a movie, as it is
+// represented in the given API version, so that paths such as /genres/- and values such
+// as runtimes are the same as in the responses. The changes are copied back to movie.
+//
+// A patch which tries to change a read-only field (or removes it) adds an error for the
+// field to v, rather than failing, so that the client gets every problem with the
+// patched movie at once along with those from ValidateMovie().
+func applyMoviePatch(version apiVersion, movie *data.Movie, mediaType string, patch []byte, v *validator.Validator) error {
+	original, err := json.Marshal(represent(version, movie))
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+
+	switch mediaType {
+	case contentTypeMergePatch:
+		patched, err = jsonpatch.MergePatch(original, patch)
+	default:
+		patched, err = jsonpatch.Apply(original, patch)
+	}
+	if err != nil {
+		return err
+	}
+
+	var before, after map[string]json.RawMessage
+
+	err = json.Unmarshal(original, &before)
+	if err != nil {
+		return err
+	}
+
+	// The patch can replace the whole document, so it might not be an object anymore.
+	err = json.Unmarshal(patched, &after)
+	if err != nil || after == nil {
+		return fmt.Errorf("%w: it must be a JSON object", errUnprocessablePatch)
+	}
+
+	for field, value := range before {
+		if movieEditableFields[field] {
+			continue
+		}
+
+		v.Check(bytes.Equal(after[field], value), field, "cannot be changed")
+		delete(after, field)
+	}
+
+	body, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+
+	if version == apiV2 {
+		body, err = movieBodyFromV2(body)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errUnprocessablePatch, err)
+		}
+	}
+
+	// Any field which the patch removed is left at its zero value, so that
+	// ValidateMovie() reports it as not provided.
+	var input struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&input)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		if errors.As(err, &unmarshalTypeError) {
+			return fmt.Errorf("%w: incorrect JSON type for field %q", errUnprocessablePatch, unmarshalTypeError.Field)
+		}
+
+		// Otherwise the runtime is in the wrong format, or the patch added a field
+		// which movies don't have.
+		return fmt.Errorf("%w: %s", errUnprocessablePatch, strings.TrimPrefix(err.Error(), "json: "))
+	}
+
+	movie.Title = input.Title
+	movie.Year = input.Year
+	movie.Runtime = input.Runtime
+	movie.Genres = input.Genres
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/jsonpatch"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+func TestApplyMoviePatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     apiVersion
+		mediaType   string
+		patch       string
+		wantTitle   string
+		wantYear    int32
+		wantRuntime data.Runtime
+		wantGenres  string
+		wantInvalid string
+		wantErr     error
+	}{
+		{
+			name:        "Merge patch",
+			version:     apiV1,
+			mediaType:   contentTypeMergePatch,
+			patch:       `{"title": "Casablanca (1942)", "runtime": "103 mins"}`,
+			wantTitle:   "Casablanca (1942)",
+			wantYear:    1942,
+			wantRuntime: 103,
+			wantGenres:  "drama,romance",
+		},
+		{
+			name:        "Merge patch clearing a field",
+			version:     apiV1,
+			mediaType:   contentTypeMergePatch,
+			patch:       `{"year": null}`,
+			wantTitle:   "Casablanca",
+			wantYear:    0,
+			wantRuntime: 102,
+			wantGenres:  "drama,romance",
+		},
+		{
+			name:        "v2 merge patch",
+			version:     apiV2,
+			mediaType:   contentTypeMergePatch,
+			patch:       `{"runtime": 103}`,
+			wantTitle:   "Casablanca",
+			wantYear:    1942,
+			wantRuntime: 103,
+			wantGenres:  "drama,romance",
+		},
+		{
+			name:        "Append a genre",
+			version:     apiV1,
+			mediaType:   contentTypeJSONPatch,
+			patch:       `[{"op": "add", "path": "/genres/-", "value": "war"}]`,
+			wantTitle:   "Casablanca",
+			wantYear:    1942,
+			wantRuntime: 102,
+			wantGenres:  "drama,romance,war",
+		},
+		{
+			name:        "Remove a genre after testing it",
+			version:     apiV2,
+			mediaType:   contentTypeJSONPatch,
+			patch:       `[{"op": "test", "path": "/version", "value": 3}, {"op": "test", "path": "/genres/0", "value": "drama"}, {"op": "remove", "path": "/genres/0"}]`,
+			wantTitle:   "Casablanca",
+			wantYear:    1942,
+			wantRuntime: 102,
+			wantGenres:  "romance",
+		},
+		{
+			name:      "Failed test",
+			version:   apiV1,
+			mediaType: contentTypeJSONPatch,
+			patch:     `[{"op": "test", "path": "/version", "value": 2}, {"op": "replace", "path": "/title", "value": "Casablanca (1942)"}]`,
+			wantErr:   jsonpatch.ErrTestFailed,
+		},
+		{
+			name:      "Missing path",
+			version:   apiV1,
+			mediaType: contentTypeJSONPatch,
+			patch:     `[{"op": "remove", "path": "/genres/5"}]`,
+			wantErr:   jsonpatch.ErrNotApplicable,
+		},
+		{
+			name:      "Malformed patch",
+			version:   apiV1,
+			mediaType: contentTypeJSONPatch,
+			patch:     `[{"op": "remove"}]`,
+			wantErr:   jsonpatch.ErrInvalidPatch,
+		},
+		{
+			name:        "Read-only field",
+			version:     apiV1,
+			mediaType:   contentTypeMergePatch,
+			patch:       `{"id": 2, "version": null}`,
+			wantTitle:   "Casablanca",
+			wantYear:    1942,
+			wantRuntime: 102,
+			wantGenres:  "drama,romance",
+			wantInvalid: "id,version",
+		},
+		{
+			name:      "Wrong type",
+			version:   apiV1,
+			mediaType: contentTypeMergePatch,
+			patch:     `{"year": "1942"}`,
+			wantErr:   errUnprocessablePatch,
+		},
+		{
+			name:      "v1 runtime in minutes",
+			version:   apiV1,
+			mediaType: contentTypeMergePatch,
+			patch:     `{"runtime": 103}`,
+			wantErr:   errUnprocessablePatch,
+		},
+		{
+			name:      "Unknown field",
+			version:   apiV1,
+			mediaType: contentTypeMergePatch,
+			patch:     `{"rating": 5}`,
+			wantErr:   errUnprocessablePatch,
+		},
+		{
+			name:      "Not an object",
+			version:   apiV1,
+			mediaType: contentTypeJSONPatch,
+			patch:     `[{"op": "replace", "path": "", "value": []}]`,
+			wantErr:   errUnprocessablePatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			movie := &data.Movie{
+				ID:        1,
+				CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+				Title:     "Casablanca",
+				Year:      1942,
+				Runtime:   102,
+				Genres:    []string{"drama", "romance"},
+				Version:   3,
+			}
+
+			v := validator.New()
+
+			err := applyMoviePatch(tt.version, movie, tt.mediaType, []byte(tt.patch), v)
+
+			if tt.wantErr != nil {
+				assert.Equal(t, errors.Is(err, tt.wantErr), true)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, movie.Title, tt.wantTitle)
+			assert.Equal(t, movie.Year, tt.wantYear)
+			assert.Equal(t, movie.Runtime, tt.wantRuntime)
+			assert.Equal(t, strings.Join(movie.Genres, ","), tt.wantGenres)
+
+			var invalid []string
+			for _, field := range []string{"id", "version", "created_at", "deleted_at"} {
+				if _, ok := v.Errors[field]; ok {
+					invalid = append(invalid, field)
+				}
+			}
+			assert.Equal(t, strings.Join(invalid, ","), tt.wantInvalid)
+
+			// The version is never changed by a patch, so that the edit conflict check
+			// in Update() still works.
+			assert.Equal(t, movie.Version, int32(3))
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+// apiVersion is the version of the API that a request was made to. The /v1 and /v2
+// route trees share their handlers and data models, and only differ in how movies are
+// represented in request and response bodies, which is handled by this file.
+type apiVersion int
+
+const (
+	apiV1 apiVersion = 1
+	apiV2 apiVersion = 2
+)
+
+// The useAPIVersion() middleware records the API version that a route belongs to in the
+// request context, so that the response helpers know which representation to use.
+func (app *application) useAPIVersion(version apiVersion, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, app.contextSetAPIVersion(r, version))
+	}
+}
+
+// versionedPath returns a path within the API version that a request was made to, such
+// as /v2/movies/1 for a Location header.
+func (app *application) versionedPath(r *http.Request, format string, args ...interface{}) string {
+	return fmt.Sprintf("/v%d", app.contextGetAPIVersion(r)) + fmt.Sprintf(format, args...)
+}
+
+// movieV2 is the v2 representation of a movie. Unlike v1, the runtime is a number of
+// minutes, the creation time is included, and every field is always present.
+type movieV2 struct {
+	ID        int64    `json:"id"`
+	Title     string   `json:"title"`
+	Year      int32    `json:"year"`
+	Runtime   int32    `json:"runtime"`
+	Genres    []string `json:"genres"`
+	Version   int32    `json:"version"`
+	CreatedAt string   `json:"created_at"`
+	DeletedAt *string  `json:"deleted_at"`
+}
+
+// paginationV2 is the v2 pagination block. Unlike v1 it has the real page size and the
+// first page, and is sent even when there are no records.
+type paginationV2 struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+// movieSnapshotV2 and movieRevisionV2 are the v2 representations of a movie's history,
+// with the runtimes as numbers of minutes.
+type movieSnapshotV2 struct {
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime int32    `json:"runtime"`
+	Genres  []string `json:"genres"`
+	Deleted bool     `json:"deleted"`
+}
+
+type movieRevisionV2 struct {
+	MovieID   int64                       `json:"movie_id"`
+	Version   int32                       `json:"version"`
+	Operation string                      `json:"operation"`
+	UserID    *int64                      `json:"user_id"`
+	CreatedAt string                      `json:"created_at"`
+	Snapshot  movieSnapshotV2             `json:"snapshot"`
+	Changes   map[string]data.FieldChange `json:"changes"`
+}
+
+// timestampV2 formats a time for v2, as RFC 3339 in UTC.
+func timestampV2(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// nonNilStrings returns an empty slice in place of a nil one, so that it is encoded as
+// [] rather than null.
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+
+	return s
+}
+
+func representMovieV2(movie *data.Movie) movieV2 {
+	m := movieV2{
+		ID:        movie.ID,
+		Title:     movie.Title,
+		Year:      movie.Year,
+		Runtime:   int32(movie.Runtime),
+		Genres:    nonNilStrings(movie.Genres),
+		Version:   movie.Version,
+		CreatedAt: timestampV2(movie.CreatedAt),
+	}
+
+	if movie.DeletedAt != nil {
+		deletedAt := timestampV2(*movie.DeletedAt)
+		m.DeletedAt = &deletedAt
+	}
+
+	return m
+}
+
+func representRevisionV2(revision *data.MovieRevision) movieRevisionV2 {
+	changes := make(map[string]data.FieldChange, len(revision.Changes))
+
+	for field, change := range revision.Changes {
+		changes[field] = data.FieldChange{From: runtimeMinutes(change.From), To: runtimeMinutes(change.To)}
+	}
+
+	return movieRevisionV2{
+		MovieID:   revision.MovieID,
+		Version:   revision.Version,
+		Operation: revision.Operation,
+		UserID:    revision.UserID,
+		CreatedAt: timestampV2(revision.CreatedAt),
+		Snapshot: movieSnapshotV2{
+			Title:   revision.Snapshot.Title,
+			Year:    revision.Snapshot.Year,
+			Runtime: int32(revision.Snapshot.Runtime),
+			Genres:  nonNilStrings(revision.Snapshot.Genres),
+			Deleted: revision.Snapshot.Deleted,
+		},
+		Changes: changes,
+	}
+}
+
+// runtimeMinutes converts a runtime in a revision's changes to a plain number of
+// minutes, leaving the values of other fields alone.
+func runtimeMinutes(value interface{}) interface{} {
+	if runtime, ok := value.(data.Runtime); ok {
+		return int32(runtime)
+	}
+
+	return value
+}
+
+// metadataV1 returns the pagination block as v1 has always sent it, which clients
+// depend on: it is empty when there are no records, the page size is always 1, and the
+// first page is left out.
+func metadataV1(metadata data.Metadata) data.Metadata {
+	if metadata.TotalRecords == 0 {
+		return data.Metadata{}
+	}
+
+	return data.Metadata{
+		CurrentPage:  metadata.CurrentPage,
+		PageSize:     1,
+		LastPage:     metadata.LastPage,
+		TotalRecords: metadata.TotalRecords,
+	}
+}
+
+// represent returns a value as it should be sent to a client of the given API version.
+// Values which are the same in every version are returned unchanged.
+func represent(version apiVersion, value interface{}) interface{} {
+	if version == apiV1 {
+		if metadata, ok := value.(data.Metadata); ok {
+			return metadataV1(metadata)
+		}
+		return value
+	}
+
+	switch value := value.(type) {
+	case *data.Movie:
+		return representMovieV2(value)
+
+	case []*data.Movie:
+		movies := make([]movieV2, len(value))
+		for i, movie := range value {
+			movies[i] = representMovieV2(movie)
+		}
+		return movies
+
+	case []*data.MovieRevision:
+		revisions := make([]movieRevisionV2, len(value))
+		for i, revision := range value {
+			revisions[i] = representRevisionV2(revision)
+		}
+		return revisions
+
+	case data.Metadata:
+		return paginationV2(value)
+	}
+
+	return value
+}
+
+// representEnvelope returns a copy of an envelope with each of its values represented
+// for the API version that the request was made to.
+func (app *application) representEnvelope(r *http.Request, env envelope) envelope {
+	version := app.contextGetAPIVersion(r)
+
+	represented := make(envelope, len(env))
+	for key, value := range env {
+		represented[key] = represent(version, value)
+	}
+
+	return represented
+}
+
+// paginationHeaders returns the X-Current-Page, X-Page-Size, X-First-Page, X-Last-Page and
+// X-Total-Records headers for a represented pagination block, which writeList() sends
+// with NDJSON and CSV lists in place of the block itself. No headers are returned for
+// an empty v1 block.
+func paginationHeaders(metadata interface{}) http.Header {
+	var p paginationV2
+
+	switch metadata := metadata.(type) {
+	case data.Metadata:
+		if metadata == (data.Metadata{}) {
+			return nil
+		}
+		p = paginationV2(metadata)
+	case paginationV2:
+		p = metadata
+	default:
+		return nil
+	}
+
+	return http.Header{
+		"X-Current-Page":  {strconv.Itoa(p.CurrentPage)},
+		"X-Page-Size":     {strconv.Itoa(p.PageSize)},
+		"X-First-Page":    {strconv.Itoa(p.FirstPage)},
+		"X-Last-Page":     {strconv.Itoa(p.LastPage)},
+		"X-Total-Records": {strconv.Itoa(p.TotalRecords)},
+	}
+}
+
+// errRuntimeMinutes is returned by movieBodyFromV2() when a v2 runtime isn't a whole
+// number of minutes.
+var errRuntimeMinutes = errors.New(`incorrect JSON type for field "runtime"`)
+
+// movieBodyFromV2 converts a v2 movie in a request body to the v1 form that the handlers
+// decode, by replacing its runtime in minutes with a "<n> mins" string. The fields named
+// in drop are removed, which lets a v2 export (whose movies include their created_at and
+// deleted_at times) be imported as it is. A body which isn't a JSON object is returned
+// unchanged, so that the usual decoding error is reported for it.
+func movieBodyFromV2(body []byte, drop ...string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+
+	err := json.Unmarshal(body, &fields)
+	if err != nil || fields == nil {
+		return body, nil
+	}
+
+	for _, name := range drop {
+		delete(fields, name)
+	}
+
+	if runtime, ok := fields["runtime"]; ok && string(runtime) != "null" {
+		var minutes int32
+
+		err := json.Unmarshal(runtime, &minutes)
+		if err != nil {
+			return nil, errRuntimeMinutes
+		}
+
+		fields["runtime"] = json.RawMessage(strconv.Quote(fmt.Sprintf("%d mins", minutes)))
+	}
+
+	return json.Marshal(fields)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+func TestRepresent(t *testing.T) {
+	deletedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+
+	movie := &data.Movie{
+		ID:        1,
+		CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+		Title:     "Casablanca",
+		Year:      1942,
+		Runtime:   102,
+		Version:   3,
+		DeletedAt: &deletedAt,
+	}
+
+	metadata := data.Metadata{CurrentPage: 2, PageSize: 20, FirstPage: 1, LastPage: 3, TotalRecords: 45}
+
+	tests := []struct {
+		name    string
+		version apiVersion
+		value   interface{}
+		want    string
+	}{
+		{
+			name:    "v1 movie",
+			version: apiV1,
+			value:   movie,
+			want:    `{"id":1,"title":"Casablanca","year":1942,"runtime":"102 mins","version":3,"deleted_at":"2024-01-02T03:04:05+01:00"}`,
+		},
+		{
+			name:    "v2 movie",
+			version: apiV2,
+			value:   movie,
+			want:    `{"id":1,"title":"Casablanca","year":1942,"runtime":102,"genres":[],"version":3,"created_at":"2024-01-01T12:00:00Z","deleted_at":"2024-01-02T02:04:05Z"}`,
+		},
+		{
+			name:    "v1 metadata",
+			version: apiV1,
+			value:   metadata,
+			want:    `{"current_page":2,"page_size":1,"last_page":3,"total_records":45}`,
+		},
+		{
+			name:    "v1 empty metadata",
+			version: apiV1,
+			value:   data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1},
+			want:    `{}`,
+		},
+		{
+			name:    "v2 pagination",
+			version: apiV2,
+			value:   metadata,
+			want:    `{"current_page":2,"page_size":20,"first_page":1,"last_page":3,"total_records":45}`,
+		},
+		{
+			name:    "v2 empty pagination",
+			version: apiV2,
+			value:   data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1},
+			want:    `{"current_page":1,"page_size":20,"first_page":1,"last_page":1,"total_records":0}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			js, err := json.Marshal(represent(tt.version, tt.value))
+			assert.NilError(t, err)
+
+			assert.Equal(t, string(js), tt.want)
+		})
+	}
+}
+
+func TestRepresentMatchesDocument(t *testing.T) {
+	app := newTestApplication(t)
+
+	movies := []*data.Movie{
+		{ID: 1, Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama"}, Version: 1},
+	}
+
+	env := envelope{"movies": movies, "metadata": data.Metadata{CurrentPage: 1, PageSize: 20, FirstPage: 1, LastPage: 1, TotalRecords: 1}}
+
+	for _, path := range []string{"/v1/movies", "/v2/movies"} {
+		version := apiV1
+		if path == "/v2/movies" {
+			version = apiV2
+		}
+
+		represented := make(envelope)
+		for key, value := range env {
+			represented[key] = represent(version, value)
+		}
+
+		js, err := json.Marshal(represented)
+		assert.NilError(t, err)
+
+		assert.NilError(t, app.spec.ValidateResponse(http.MethodGet, path, http.StatusOK, contentTypeJSON, js))
+	}
+}
+
+func TestMovieBodyFromV2(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		drop    []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "Runtime in minutes",
+			body: `{"title":"Casablanca","runtime":102}`,
+			want: `{"runtime":"102 mins","title":"Casablanca"}`,
+		},
+		{
+			name: "No runtime",
+			body: `{"title":"Casablanca"}`,
+			want: `{"title":"Casablanca"}`,
+		},
+		{
+			name: "Dropped fields",
+			body: `{"title":"Casablanca","created_at":"2024-01-01T12:00:00Z","deleted_at":null}`,
+			drop: []string{"created_at", "deleted_at"},
+			want: `{"title":"Casablanca"}`,
+		},
+		{
+			name:    "Runtime as a string",
+			body:    `{"runtime":"102 mins"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Runtime with a fraction",
+			body:    `{"runtime":102.5}`,
+			wantErr: true,
+		},
+		{
+			name: "Not an object",
+			body: `["runtime"]`,
+			want: `["runtime"]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := movieBodyFromV2([]byte(tt.body), tt.drop...)
+
+			if tt.wantErr {
+				assert.Equal(t, errors.Is(err, errRuntimeMinutes), true)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, string(body), tt.want)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Fetch the full history for the movie, including the field-level changes made by
+	// each revision. Every movie has at least one revision, so an empty history means
+	// that the movie doesn't exist. A purged movie's history is still returned, and
+	// ends with its purge revision.
+	revisions, err := app.models.MovieRevisions.GetAllForMovie(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if len(revisions) == 0 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.writeList(w, r, envelope{"revisions": revisions}, "revisions", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// The :rev parameter is the movie version number that the revision produced.
+	rev, err := app.readIntParam(r, "rev")
+	if err != nil || rev > math.MaxInt32 {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// The client must pass the version of the movie that they were looking at when
+	// they chose to roll back, as a rollback without it could silently undo changes
+	// that they have never seen. It's checked before anything is read from the
+	// database.
+	v := validator.New()
+
+	expectedVersion := app.readInt(r.URL.Query(), "version", 0, v)
+	v.Check(expectedVersion > 0, "version", "must be provided")
+	v.Check(expectedVersion <= math.MaxInt32, "version", "must be a valid version number")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revision, err := app.models.MovieRevisions.Get(id, int32(rev))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the current movie record. A movie in the trash has to be restored from the
+	// trash before it can be rolled back, so it gets a 404 Not Found response here.
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// If the movie has changed since the client looked at it, we send an edit conflict
+	// rather than overwriting changes they haven't seen.
+	if int32(expectedVersion) != movie.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	// Copy the revision's values onto the movie, and save it like any other update. The
+	// validation rules may have changed since the revision was made, so we check the
+	// result again.
+	revision.Snapshot.ApplyTo(movie)
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Update() checks the version that we read above, so a change made by someone else
+	// in the meantime still results in an edit conflict.
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
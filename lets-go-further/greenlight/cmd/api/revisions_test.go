@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestRestoreMovieRevisionVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr string
+	}{
+		{
+			name:    "No version",
+			query:   "",
+			wantErr: "must be provided",
+		},
+		{
+			name:    "Zero version",
+			query:   "?version=0",
+			wantErr: "must be provided",
+		},
+		{
+			name:    "Version which isn't a number",
+			query:   "?version=latest",
+			wantErr: "must be an integer value",
+		},
+		{
+			name:    "Version out of range",
+			query:   "?version=4294967296",
+			wantErr: "must be a valid version number",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			// The handler is called directly, with the route parameters that the router
+			// would have set, as the route needs an authenticated user. The test
+			// application has no database, so this also checks that the version is
+			// validated before anything is read.
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies/1/revisions/2/restore"+tt.query, nil)
+			params := httprouter.Params{{Key: "id", Value: "1"}, {Key: "rev", Value: "2"}}
+			r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+
+			rr := httptest.NewRecorder()
+			app.restoreMovieRevisionHandler(rr, r)
+
+			assert.Equal(t, rr.Code, http.StatusUnprocessableEntity)
+
+			var problem struct {
+				Errors []fieldError `json:"errors"`
+			}
+			err := json.NewDecoder(rr.Body).Decode(&problem)
+			assert.NilError(t, err)
+			assert.Equal(t, len(problem.Errors), 1)
+			assert.Equal(t, problem.Errors[0], fieldError{Field: "version", Message: tt.wantErr})
+		})
+	}
+}
@@ -25,19 +25,76 @@ func (app *application) routes() http.Handler {
 	// http.MethodPost are constants which equate to the strings "GET" and "POST"
 	// respectively
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/openapi.json", app.openAPIHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/docs", app.docsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.idempotent(app.invalidatesMovieCache(app.createMovieHandler))))
+	// GET /v1/movies/events, GET /v1/movies/export, GET /v1/movies/trash and
+	// POST /v1/movies/import are dispatched from the /v1/movies/:id routes, as httprouter
+	// won't let us register a fixed segment in the same position as the :id parameter.
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.dispatchParam("id", app.methodNotAllowedResponse, map[string]http.HandlerFunc{
+		"import": app.requirePermission("movies:write", app.invalidatesMovieCache(app.importMoviesHandler)),
+	}))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.dispatchParam("id", app.requirePermission("movies:read", app.cacheResponse(app.showMovieHandler)), map[string]http.HandlerFunc{
+		"events": app.requirePermission("movies:read", app.movieEventsHandler),
+		"export": app.requirePermission("movies:read", app.exportMoviesHandler),
+		"trash":  app.requirePermission("movies:write", app.listTrashedMoviesHandler),
+	}))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.invalidatesMovieCache(app.restoreMovieHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:rev/restore", app.requirePermission("movies:write", app.invalidatesMovieCache(app.restoreMovieRevisionHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/purge", app.requirePermission("admin", app.invalidatesMovieCache(app.purgeMovieHandler)))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.invalidatesMovieCache(app.updateMovieHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.invalidatesMovieCache(app.deleteMovieHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.cacheResponse(app.listMoviesHandler)))
+
+	// The /v2 movie routes share the v1 handlers. They only differ in how movies are
+	// represented in request and response bodies (see representation.go), which the
+	// useAPIVersion() middleware selects.
+	v2 := func(next http.HandlerFunc) http.HandlerFunc {
+		return app.useAPIVersion(apiV2, next)
+	}
+
+	router.HandlerFunc(http.MethodPost, "/v2/movies", v2(app.requirePermission("movies:write", app.idempotent(app.invalidatesMovieCache(app.createMovieHandler)))))
+	router.HandlerFunc(http.MethodPost, "/v2/movies/:id", v2(app.dispatchParam("id", app.methodNotAllowedResponse, map[string]http.HandlerFunc{
+		"import": app.requirePermission("movies:write", app.invalidatesMovieCache(app.importMoviesHandler)),
+	})))
+	router.HandlerFunc(http.MethodGet, "/v2/movies/:id", v2(app.dispatchParam("id", app.requirePermission("movies:read", app.cacheResponse(app.showMovieHandler)), map[string]http.HandlerFunc{
+		"events": app.requirePermission("movies:read", app.movieEventsHandler),
+		"export": app.requirePermission("movies:read", app.exportMoviesHandler),
+		"trash":  app.requirePermission("movies:write", app.listTrashedMoviesHandler),
+	})))
+	router.HandlerFunc(http.MethodPost, "/v2/movies/:id/restore", v2(app.requirePermission("movies:write", app.invalidatesMovieCache(app.restoreMovieHandler))))
+	router.HandlerFunc(http.MethodGet, "/v2/movies/:id/revisions", v2(app.requirePermission("movies:read", app.listMovieRevisionsHandler)))
+	router.HandlerFunc(http.MethodPost, "/v2/movies/:id/revisions/:rev/restore", v2(app.requirePermission("movies:write", app.invalidatesMovieCache(app.restoreMovieRevisionHandler))))
+	router.HandlerFunc(http.MethodDelete, "/v2/movies/:id/purge", v2(app.requirePermission("admin", app.invalidatesMovieCache(app.purgeMovieHandler))))
+	router.HandlerFunc(http.MethodPatch, "/v2/movies/:id", v2(app.requirePermission("movies:write", app.invalidatesMovieCache(app.updateMovieHandler))))
+	router.HandlerFunc(http.MethodDelete, "/v2/movies/:id", v2(app.requirePermission("movies:write", app.invalidatesMovieCache(app.deleteMovieHandler))))
+	router.HandlerFunc(http.MethodGet, "/v2/movies", v2(app.requirePermission("movies:read", app.cacheResponse(app.listMoviesHandler))))
+
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission("admin", app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission("admin", app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission("admin", app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission("admin", app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission("admin", app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission("admin", app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery/retry", app.requirePermission("admin", app.retryWebhookDeliveryHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.idempotent(app.registerUserHandler))
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireAuthenticatedUser(app.deleteCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me/email", app.requireActivatedUser(app.changeUserEmailHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/email/confirmed", app.confirmUserEmailHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireActivatedUser(app.enrolTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp/confirm", app.requireActivatedUser(app.confirmTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireActivatedUser(app.disableTOTPHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication/totp", app.createTOTPAuthenticationTokenHandler)
 
 	// Register a new GET /debug/vars endpoint pointing to the expvar handler
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
 	// Wrap the router with the panic recovery middleware
 	// Add the enableCORS() middleware
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	return app.metrics(app.compress(app.validateResponses(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))))
 }
@@ -56,6 +56,13 @@ func (app *application) serve() error {
 			"signal": s.String(),
 		})
 
+		// Tell the background workers, and the open movie event streams, to stop. The
+		// streams would otherwise stay open until their own deadline, so Shutdown()
+		// would always run out of time while any client was listening. Each worker
+		// finishes what it is in the middle of first, such as a job which is sending an
+		// email.
+		close(app.shutdown)
+
 		// Create a context with a 5-second timeout
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -64,15 +71,8 @@ func (app *application) serve() error {
 		// Shutdown() will return nil if the graceful shutdown was successful, or an
 		// error (which may happen) because of a problem closing the listeners, or
 		// because the shutdown didn't complete before the 5-second context deadline is
-		// hit). We relay this return value to the shutdownError channel.
-		// shutdownError <- srv.Shutdown(ctx)
-
-		// Call Shutdown() on the server like before, but now we only send on the
-		// shutdownError channel if it returns an error
+		// hit).
 		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownError <- err
-		}
 
 		// Log a message to sayh that we're waiting for any background goroutines to
 		// complete their tasks.
@@ -81,11 +81,11 @@ func (app *application) serve() error {
 		})
 
 		// Call Wait() to block until our WaitGroup counter is zero --- essentially
-		// blocking until the background goroutines have finished. Then we return nil on
-		// the shutdownError channel, to indicate that the shutdown completed without
-		// any issues.
+		// blocking until the background goroutines have finished. We wait even if
+		// Shutdown() failed, so that no worker is killed part way through a task, and
+		// only then relay its return value to the shutdownError channel.
 		app.wg.Wait()
-		shutdownError <- nil
+		shutdownError <- err
 	}()
 
 	// Start the HTTP server.
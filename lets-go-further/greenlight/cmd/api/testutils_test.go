@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/jsonlog"
+	"github.com.go-learning.greenlight/internal/openapi"
+	"github.com.go-learning.greenlight/internal/password"
+)
+
+// Create a newTestApplication helper which returns an instance of our application struct
+// with the same settings as production, except that nothing is logged, rate limiting
+// and the response cache are turned off, and there is no database. The models are
+// created without a connection pool, so the tests can only exercise requests which are
+// answered before the database would be used (such as those which fail validation),
+// unless they replace the models with ones using a testDB.
+func newTestApplication(t *testing.T) *application {
+	blocklist, err := password.DefaultBlocklist()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.env = "testing"
+	cfg.compression.minSize = 1024
+	cfg.bulk.importMaxBytes = 1 << 20
+	cfg.cache.maxEntries = 10
+	cfg.cache.ttl = time.Second
+	cfg.idempotency.ttl = time.Hour
+
+	return &application{
+		config:     cfg,
+		logger:     jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		models:     data.NewModels(nil),
+		shutdown:   make(chan struct{}),
+		events:     newMovieEventBroker(10),
+		movieCache: newResponseCache(cfg.cache.maxEntries, cfg.cache.ttl, cfg.cache.staleTTL),
+		passwords: &password.Policy{
+			MinLength: 8,
+			MinScore:  2,
+			Blocklist: blocklist,
+		},
+		spec: spec,
+	}
+}
+
+// Define a custom testServer type which embeds a httptest.Server instance.
+type testServer struct {
+	*httptest.Server
+}
+
+// Create a newTestServer helper which initializes and returns a new instance of our
+// custom testServer type.
+func newTestServer(t *testing.T, h http.Handler) *testServer {
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+
+	return &testServer{ts}
+}
+
+// Implement a do() method on our custom testServer type. This makes a request with the
+// given method, URL path, headers and body using the test server client, and returns the
+// response status code, headers and body.
+func (ts *testServer) do(t *testing.T, method, urlPath string, headers http.Header, body string) (int, http.Header, []byte) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, ts.URL+urlPath, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	respBody, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, bytes.TrimSpace(respBody)
+}
+
+// A testDB stands in for the database in tests which go through the models. Each query
+// is passed to the answer function along with its arguments, and the rows it returns are
+// the query's result. A statement which doesn't return rows affects as many rows as the
+// answer returns. Transactions are accepted, but committing or rolling one back does
+// nothing. Every query is recorded, so that a test can check what was written.
+type testDB struct {
+	answer func(query string, args []driver.Value) ([][]driver.Value, error)
+
+	mu      sync.Mutex
+	queries []testQuery
+}
+
+// A testQuery is a query sent to a testDB.
+type testQuery struct {
+	query string
+	args  []driver.Value
+}
+
+// open returns a connection pool which sends its queries to the testDB.
+func (db *testDB) open(t *testing.T) *sql.DB {
+	pool := sql.OpenDB(db)
+	t.Cleanup(func() { pool.Close() })
+
+	return pool
+}
+
+// executed returns the queries which contain substr, in the order they were sent.
+func (db *testDB) executed(substr string) []testQuery {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var queries []testQuery
+
+	for _, q := range db.queries {
+		if strings.Contains(q.query, substr) {
+			queries = append(queries, q)
+		}
+	}
+
+	return queries
+}
+
+func (db *testDB) run(query string, args []driver.Value) ([][]driver.Value, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.queries = append(db.queries, testQuery{query, args})
+
+	return db.answer(query, args)
+}
+
+func (db *testDB) Connect(context.Context) (driver.Conn, error) {
+	return testConn{db}, nil
+}
+
+func (db *testDB) Driver() driver.Driver {
+	return nil
+}
+
+type testConn struct {
+	db *testDB
+}
+
+func (c testConn) Prepare(query string) (driver.Stmt, error) {
+	return testStmt{c.db, query}, nil
+}
+
+func (c testConn) Close() error {
+	return nil
+}
+
+func (c testConn) Begin() (driver.Tx, error) {
+	return testTx{}, nil
+}
+
+type testTx struct{}
+
+func (testTx) Commit() error {
+	return nil
+}
+
+func (testTx) Rollback() error {
+	return nil
+}
+
+type testStmt struct {
+	db    *testDB
+	query string
+}
+
+func (s testStmt) Close() error {
+	return nil
+}
+
+func (s testStmt) NumInput() int {
+	return -1
+}
+
+func (s testStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rows, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(len(rows)), nil
+}
+
+func (s testStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &testRows{rows}, nil
+}
+
+type testRows struct {
+	rows [][]driver.Value
+}
+
+func (r *testRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *testRows) Close() error {
+	return nil
+}
+
+func (r *testRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
@@ -32,14 +32,26 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Refuse to check the password at all while attempts for this account or IP address
+	// are being delayed, or are locked out, after earlier failures.
+	accountKey, ipKey, err := loginFailureKeys(r, input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.checkLoginBlocked(w, r, accountKey, ipKey) {
+		return
+	}
+
 	// Lookup the user record based on the email address. If no matching user was
-	// found, then we call the app.invalidCredentialsResponse() helper to send a 401
-	// Unauthorized response to the client
+	// found, then we count the failure and call the app.invalidCredentialsResponse()
+	// helper to send a 401 Unauthorized response to the client
 	user, err := app.models.Users.GetByEmail(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.loginFailedResponse(w, r, nil, accountKey, ipKey)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -53,15 +65,51 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	// If the passwords don't match, then we call the app.invalidCredentialsResponse()
-	// helper again and return
+	// If the passwords don't match, then we count the failure and call the
+	// app.invalidCredentialsResponse() helper again and return
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.loginFailedResponse(w, r, user, accountKey, ipKey)
 		return
 	}
 
-	// Otherwise, if the password is correct, we generate a new token with a 24-hour
-	// expiry time and the scope 'authentication'
+	// If the user has two-factor authentication turned on, the password isn't enough on
+	// its own. Instead we send back a short-lived challenge token, which the client
+	// exchanges for an authentication token (along with a code from the user's
+	// authenticator app) at POST /v1/tokens/authentication/totp.
+	t, err := app.models.TOTP.Get(user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if t != nil && t.Enabled() {
+		challenge, err := app.models.Tokens.New(user.ID, totpChallengeTTL, data.ScopeTOTPChallenge)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeResponse(w, r, http.StatusAccepted, envelope{"challenge_token": challenge}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Without a second factor, the sign in has now succeeded, which clears the failures
+	// for the account. (With one, they're only cleared once the code has been checked,
+	// as wrong codes count towards the same lockout.) The failures for the IP address
+	// are left to expire, as otherwise an attacker trying passwords for many accounts
+	// could reset the IP address limit by signing in to their own account every few
+	// attempts.
+	err = app.models.AuthFailures.Reset(accountKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Then we generate a new token with a 24-hour expiry time and the scope
+	// 'authentication'
 	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -70,9 +118,20 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 
 	// Encode the token to JSON and send it in the response along with a 201 Created
 	// status code
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
+}
+
+// loginFailedResponse records a failed sign in attempt, then sends the usual invalid
+// credentials response.
+func (app *application) loginFailedResponse(w http.ResponseWriter, r *http.Request, user *data.User, accountKey, ipKey string) {
+	err := app.recordLoginFailure(user, accountKey, ipKey)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
 
+	app.invalidCredentialsResponse(w, r)
 }
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/totp"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+// Define the issuer shown in authenticator apps, the number of recovery codes given to
+// each user, and how long a challenge token lasts.
+const (
+	totpIssuer        = "Greenlight"
+	totpRecoveryCodes = 10
+	totpChallengeTTL  = 5 * time.Minute
+)
+
+func (app *application) enrolTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	// The user confirms their password to start enrolling, in the same way as for any
+	// other change to how they sign in.
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	match, ok := app.checkCurrentPassword(w, r, user, input.Password)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Store the secret. Two-factor authentication isn't turned on until the user proves
+	// that their authenticator app is set up, by sending a code to the confirm endpoint.
+	err = app.models.TOTP.Enrol(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v.AddError("totp", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"totp": envelope{
+			"secret":           secret,
+			"provisioning_uri": totp.ProvisioningURI(secret, totpIssuer, user.Email),
+		},
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	t, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("totp", "two-factor authentication enrolment has not been started")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if t.Enabled() {
+		v.AddError("totp", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok := totp.Validate(t.Secret, input.Code, time.Now())
+	if !ok {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Turn two-factor authentication on and generate the recovery codes together, so
+	// that the user is never left with it on but no way to recover their account.
+	var codes []string
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.TOTP.Enable(user.ID, step)
+		if err != nil {
+			return err
+		}
+
+		codes, err = tx.TOTP.NewRecoveryCodes(user.ID, totpRecoveryCodes)
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The recovery codes are only stored as hashes, so this is the only time the user
+	// can see them.
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"recovery_codes": codes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	// Turning two-factor authentication off needs both the password and a second factor,
+	// so that neither a stolen password nor a stolen phone is enough on its own.
+	var input struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	match, ok := app.checkCurrentPassword(w, r, user, input.Password)
+	if !ok {
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	valid, err := app.verifySecondFactor(user.ID, input.Code, input.RecoveryCode)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !valid {
+		v.AddError("code", "invalid or expired code")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.TOTP.Delete(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "two-factor authentication disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createTOTPAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	// The client sends the challenge token it got from POST /v1/tokens/authentication,
+	// along with either a code from the user's authenticator app or a recovery code.
+	var input struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.ChallengeToken)
+	v.Check(input.Code != "" || input.RecoveryCode != "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeTOTPChallenge, input.ChallengeToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Wrong codes count towards the same delays and lockouts as wrong passwords, so a
+	// code can't be guessed by trying all million of them.
+	accountKey, ipKey, err := loginFailureKeys(r, user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.checkLoginBlocked(w, r, accountKey, ipKey) {
+		return
+	}
+
+	ok, err := app.verifySecondFactor(user.ID, input.Code, input.RecoveryCode)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.loginFailedResponse(w, r, user, accountKey, ipKey)
+		return
+	}
+
+	// Swap the challenge token for a real authentication token.
+	var token *data.Token
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllForUser(data.ScopeTOTPChallenge, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// As with passwords, only the failures for the account are cleared, and the
+		// failures for the IP address are left to expire.
+		err = tx.AuthFailures.Reset(accountKey)
+		if err != nil {
+			return err
+		}
+
+		token, err = tx.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// verifySecondFactor checks a TOTP code, or failing that a recovery code, for a user with
+// two-factor authentication enabled. Each code can only be used once. It returns
+// ErrRecordNotFound if the user doesn't have two-factor authentication enabled.
+func (app *application) verifySecondFactor(userID int64, code, recoveryCode string) (bool, error) {
+	t, err := app.models.TOTP.Get(userID)
+	if err != nil {
+		return false, err
+	}
+
+	if !t.Enabled() {
+		return false, data.ErrRecordNotFound
+	}
+
+	// A code which has already been used is turned away here, and UseStep() checks
+	// again when recording the step, in case the same code is sent twice at once.
+	if code != "" {
+		step, ok := totp.ValidateUnused(t.Secret, code, time.Now(), t.LastUsedStep)
+		if !ok {
+			return false, nil
+		}
+
+		return app.models.TOTP.UseStep(userID, step)
+	}
+
+	if recoveryCode != "" {
+		return app.models.TOTP.UseRecoveryCode(userID, recoveryCode)
+	}
+
+	return false, nil
+}
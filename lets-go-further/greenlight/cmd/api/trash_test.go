@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestPurgeMovie(t *testing.T) {
+	tests := []struct {
+		name       string
+		purged     int
+		wantStatus int
+	}{
+		{
+			name:       "In the trash",
+			purged:     1,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Not in the trash",
+			purged:     0,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &testDB{answer: func(query string, args []driver.Value) ([][]driver.Value, error) {
+				if strings.Contains(query, "DELETE FROM movies") {
+					return make([][]driver.Value, tt.purged), nil
+				}
+
+				return nil, fmt.Errorf("unexpected query: %s", query)
+			}}
+
+			app := newTestApplication(t)
+			app.models = data.NewModels(db.open(t))
+
+			r := httptest.NewRequest(http.MethodDelete, "/v1/movies/1/purge", nil)
+			params := httprouter.Params{{Key: "id", Value: "1"}}
+			r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params))
+			r = app.contextSetUser(r, &data.User{ID: 7})
+
+			rr := httptest.NewRecorder()
+			app.purgeMovieHandler(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantStatus)
+
+			// The movie is deleted in the same statement which records the purge
+			// revision, attributed to the admin who purged it.
+			purges := db.executed("DELETE FROM movies")
+			assert.Equal(t, len(purges), 1)
+			assert.StringContains(t, purges[0].query, "INSERT INTO movie_revisions")
+			assert.Equal(t, purges[0].args[1].(int64), int64(7))
+		})
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com.go-learning.greenlight/internal/data"
@@ -15,6 +17,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Locale   string `json:"locale"`
 	}
 
 	// Parse the request body into the anonymous struct.
@@ -32,6 +35,12 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Locale:    input.Locale,
+	}
+
+	// The locale is optional, and picks the language of the emails we send.
+	if user.Locale == "" {
+		user.Locale = data.DefaultLocale
 	}
 
 	// Use the Password.Set() method to generate and store the hashed and plaintext
@@ -44,15 +53,43 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	v := validator.New()
 
-	// Validate the user struct and return the error emssages to the client if any of
-	// the checks fail
-	if data.ValidateUser(v, user); !v.Valid() {
+	// Validate the user struct, and check the new password against the password policy,
+	// and return the error emssages to the client if any of the checks fail
+	data.ValidateUser(v, user)
+	app.passwords.Validate(v, input.Password, user.Name, user.Email)
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Insert the user data  into the database
-	err = app.models.Users.Insert(user)
+	// Insert the user, their permissions and activation token, and queue the welcome
+	// email, all in one transaction. If any step fails nothing is saved, and if it
+	// succeeds the email is guaranteed to be sent (or to end up in the dead jobs for
+	// someone to look at), even if this process dies straight afterwards.
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Insert(user)
+		if err != nil {
+			return err
+		}
+
+		// Add the "movies:read" permission for the new user.
+		err = tx.Permissions.AddPermissionsForUser(user.ID, "movies:read")
+		if err != nil {
+			return err
+		}
+
+		// After the user record has been created in the database, generate a new
+		// activation token for the user
+		token, err := tx.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			return err
+		}
+
+		// Queue the welcome email, which contains the plaintext version of the
+		// activation token for the user, along with their ID
+		return tx.Jobs.Enqueue(data.JobSendEmail, welcomeEmail(user, token))
+	})
 	if err != nil {
 		switch {
 		// If we get a ErrDuplicateEmail error, use  the v.AddError() method to manually
@@ -67,51 +104,14 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Add the "movies:read" permission for the new user.
-	err = app.models.Permissions.AddPermissionsForUser(user.ID, "movies:read")
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// After the user record has been created in the database, generate a new activation
-	// token for the user
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	// Use the background helper to execute an anonymous function that sends the welcome
-	// email.
-	app.background(func() {
-		// As there are now multiple pieces of data that we want to pass to our email
-		// templates, we create a map to act as a 'holding structrure' for the data. This
-		// contains the plaintext version of the activation token for the user, along
-		// with their ID
-		data := map[string]interface{}{
-			"activationToken": token.Plaintext,
-			"userID":          user.ID,
-		}
-
-		// Send the welcome email
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			// Importantly, if there is an error sending the email then we use the
-			// app.logger.PrintError() helper to manage it, instead of the
-			// app.serverErrorResponse() helper like before
-			app.logger.PrintError(err, nil)
-		}
-	})
-
 	// Write a JSON response containing the user data along with a 201 Created status
 	// code.
-	// err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+	// err = app.writeResponse(w, r, http.StatusCreated, envelope{"user": user}, nil)
 
 	// Note that we also change this to send the client a 202 Accepted status code.
 	// This status code indicates that the request has been accepted for processing,
 	// but the processing has not been completed
-	err = app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -177,7 +177,349 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 
 	// Send the updated user details to the client in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) changeUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// The client has to send their current password along with the new email address,
+	// so that a stolen authentication token isn't enough to take over the account.
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check the password against the authenticated user's current password hash.
+	user := app.contextGetUser(r)
+
+	match, ok := app.checkCurrentPassword(w, r, user, input.Password)
+	if !ok {
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	// The email column is case-insensitive, so we compare the addresses in the same way.
+	if strings.EqualFold(input.Email, user.Email) {
+		v.AddError("email", "must be different from the current email address")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Check that the new address isn't already taken. It could still be taken by the time
+	// the change is confirmed, so the confirmation checks again.
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	oldEmail := user.Email
+	user.PendingEmail = &input.Email
+
+	// Store the pending email, replace any earlier email change token with a new one,
+	// and queue the confirmation email to the new address and a notification to the old
+	// one, all in the same transaction.
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Jobs.Enqueue(data.JobSendEmail, data.EmailJob{
+			Recipient: input.Email,
+			Locale:    user.Locale,
+			Template:  "email_change_confirm.tmpl",
+			Data: map[string]interface{}{
+				"emailChangeToken": token.Plaintext,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		return tx.Jobs.Enqueue(data.JobSendEmail, data.EmailJob{
+			Recipient: oldEmail,
+			Locale:    user.Locale,
+			Template:  "email_change_notice.tmpl",
+			Data: map[string]interface{}{
+				"newEmail": input.Email,
+			},
+		})
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{"message": fmt.Sprintf("a confirmation email has been sent to %s", input.Email)}
+
+	err = app.writeResponse(w, r, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the plaintext email change token from the request body.
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Retrieve the user that the token belongs to. A token without a pending email can
+	// only be left over from a change which has already been made, so we treat it as
+	// invalid too.
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err == nil && user.PendingEmail == nil {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Swap in the new email address.
+	user.Email = *user.PendingEmail
+	user.PendingEmail = nil
+
+	// Save the change, and delete the user's email change and authentication tokens, so
+	// that every session has to sign in again with the new address.
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	})
+	if err != nil {
+		switch {
+		// Someone else has registered (or changed to) the address since the change was
+		// requested.
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// The authenticate() middleware has already read the user's record for us.
+	err := app.writeResponse(w, r, http.StatusOK, envelope{"user": app.contextGetUser(r)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Use pointers so that we only change the fields which are in the request body. To
+	// change their password the user has to send their current password too.
+	var input struct {
+		Name            *string `json:"name"`
+		Locale          *string `json:"locale"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// The user record was read by the authenticate() middleware at the start of this
+	// request, so saving it with Update() fails with an edit conflict if anything else
+	// has changed it in the meantime.
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+	if input.Locale != nil {
+		user.Locale = *input.Locale
+	}
+
+	if input.Password != nil {
+		match, ok := app.checkCurrentPassword(w, r, user, input.CurrentPassword)
+		if !ok {
+			return
+		}
+
+		if !match {
+			v.AddError("current_password", "is incorrect")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = user.Password.Set(*input.Password)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.passwords.Validate(v, *input.Password, user.Name, user.Email)
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// When the password changes, every authentication token is revoked along with the
+	// update, so any session using the old password has to sign in again.
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		if input.Password == nil {
+			return nil
+		}
+
+		// A new password also clears any failed sign in attempts for the account, so
+		// that a user who was locked out can get straight back in.
+		err = tx.AuthFailures.Reset(accountFailureKey(user.Email))
+		if err != nil {
+			return err
+		}
+
+		return tx.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	// Deleting an account can't be undone, so the user has to confirm it with their
+	// password.
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	match, ok := app.checkCurrentPassword(w, r, user, input.Password)
+	if !ok {
+		return
+	}
+
+	if !match {
+		v := validator.New()
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Delete the user, checking the version read at the start of the request. Their
+	// tokens and permissions go with them, and their movie revisions are anonymised.
+	err = app.models.Users.Delete(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "user account successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
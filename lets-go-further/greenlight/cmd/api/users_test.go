@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+// testUserRow returns a row of the users table, in the column order which the user
+// queries select.
+func testUserRow(email string, pendingEmail interface{}) []driver.Value {
+	return []driver.Value{int64(1), time.Now(), "Alice", email, []byte("hash"), true, data.DefaultLocale, pendingEmail, int64(1)}
+}
+
+func TestChangeUserEmail(t *testing.T) {
+	// Hashing the password is slow, so it is only done once and each test gets a copy
+	// of the user.
+	var alice data.User
+	alice.ID = 1
+	alice.Name = "Alice"
+	alice.Email = "alice@example.com"
+	alice.Activated = true
+	alice.Locale = data.DefaultLocale
+	alice.Version = 1
+
+	err := alice.Password.Set("pa55word1234")
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name         string
+		body         string
+		taken        bool
+		conflict     bool
+		locked       bool
+		wantStatus   int
+		wantBody     string
+		wantJobs     int
+		wantFailures int
+	}{
+		{
+			name:       "Invalid email",
+			body:       `{"email": "not an email", "password": "pa55word1234"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "must be a valid email address",
+		},
+		{
+			name:         "Wrong password",
+			body:         `{"email": "new@example.com", "password": "wrongpassword"}`,
+			wantStatus:   http.StatusUnauthorized,
+			wantBody:     "invalid authentication credentials",
+			wantFailures: 2,
+		},
+		{
+			name:       "Locked out",
+			body:       `{"email": "new@example.com", "password": "pa55word1234"}`,
+			locked:     true,
+			wantStatus: http.StatusLocked,
+			wantBody:   "account_locked",
+		},
+		{
+			name:       "Same email",
+			body:       `{"email": "ALICE@example.com", "password": "pa55word1234"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "must be different from the current email address",
+		},
+		{
+			name:       "Email taken",
+			body:       `{"email": "new@example.com", "password": "pa55word1234"}`,
+			taken:      true,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "a user with this email address already exists",
+		},
+		{
+			name:       "Edit conflict",
+			body:       `{"email": "new@example.com", "password": "pa55word1234"}`,
+			conflict:   true,
+			wantStatus: http.StatusConflict,
+			wantBody:   "edit conflict",
+		},
+		{
+			name:       "Valid",
+			body:       `{"email": "new@example.com", "password": "pa55word1234"}`,
+			wantStatus: http.StatusAccepted,
+			wantBody:   "a confirmation email has been sent to new@example.com",
+			wantJobs:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &testDB{answer: func(query string, args []driver.Value) ([][]driver.Value, error) {
+				switch {
+				// Sign in failures are counted for the account and the IP address.
+				case strings.Contains(query, "SELECT failures"):
+					if tt.locked && args[0] == accountFailureKey(alice.Email) {
+						return [][]driver.Value{{int64(10), time.Now().Add(time.Hour), true}}, nil
+					}
+					return nil, nil
+
+				case strings.Contains(query, "INSERT INTO auth_failures"):
+					return [][]driver.Value{{int64(1), nil, false}}, nil
+
+				case strings.Contains(query, "FROM users"):
+					if tt.taken {
+						return [][]driver.Value{testUserRow("new@example.com", nil)}, nil
+					}
+					return nil, nil
+
+				case strings.Contains(query, "UPDATE users"):
+					if tt.conflict {
+						return nil, nil
+					}
+					return [][]driver.Value{{int64(2)}}, nil
+
+				case strings.Contains(query, "tokens"), strings.Contains(query, "INSERT INTO jobs"):
+					return nil, nil
+				}
+
+				return nil, fmt.Errorf("unexpected query: %s", query)
+			}}
+
+			app := newTestApplication(t)
+			app.models = data.NewModels(db.open(t))
+
+			user := alice
+
+			r := httptest.NewRequest(http.MethodPatch, "/v1/users/me/email", strings.NewReader(tt.body))
+			r = app.contextSetUser(r, &user)
+
+			rr := httptest.NewRecorder()
+			app.changeUserEmailHandler(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantStatus)
+			assert.StringContains(t, rr.Body.String(), tt.wantBody)
+
+			assert.Equal(t, len(db.executed("INSERT INTO auth_failures")), tt.wantFailures)
+
+			// The confirmation goes to the new address, and the notice to the old one.
+			jobs := db.executed("INSERT INTO jobs")
+			assert.Equal(t, len(jobs), tt.wantJobs)
+
+			if tt.wantJobs > 0 {
+				assert.StringContains(t, jobs[0].args[1].(string), `"recipient":"new@example.com"`)
+				assert.StringContains(t, jobs[0].args[1].(string), "email_change_confirm.tmpl")
+				assert.StringContains(t, jobs[1].args[1].(string), `"recipient":"alice@example.com"`)
+				assert.StringContains(t, jobs[1].args[1].(string), "email_change_notice.tmpl")
+
+				// The address only changes once it has been confirmed.
+				updates := db.executed("UPDATE users")
+				assert.Equal(t, updates[0].args[1].(string), "alice@example.com")
+				assert.Equal(t, updates[0].args[5].(string), "new@example.com")
+			}
+		})
+	}
+}
+
+func TestConfirmUserEmail(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		user       []driver.Value
+		updateErr  error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Malformed token",
+			token:      "abc",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "must be 26 bytes long",
+		},
+		{
+			name:       "Unknown token",
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "invalid or expired email change token",
+		},
+		{
+			name:       "No pending email",
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			user:       testUserRow("alice@example.com", nil),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "invalid or expired email change token",
+		},
+		{
+			name:       "Email taken since",
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			user:       testUserRow("alice@example.com", "new@example.com"),
+			updateErr:  errors.New(`pq: duplicate key value violates unique constraint "users_email_key"`),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "a user with this email address already exists",
+		},
+		{
+			name:       "Valid",
+			token:      "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+			user:       testUserRow("alice@example.com", "new@example.com"),
+			wantStatus: http.StatusOK,
+			wantBody:   `"email":"new@example.com"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &testDB{answer: func(query string, args []driver.Value) ([][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "INNER JOIN tokens"):
+					if tt.user == nil {
+						return nil, nil
+					}
+					return [][]driver.Value{tt.user}, nil
+
+				case strings.Contains(query, "UPDATE users"):
+					if tt.updateErr != nil {
+						return nil, tt.updateErr
+					}
+					return [][]driver.Value{{int64(2)}}, nil
+
+				case strings.Contains(query, "DELETE FROM tokens"):
+					return nil, nil
+				}
+
+				return nil, fmt.Errorf("unexpected query: %s", query)
+			}}
+
+			app := newTestApplication(t)
+			app.models = data.NewModels(db.open(t))
+
+			r := httptest.NewRequest(http.MethodPut, "/v1/users/email/confirmed", strings.NewReader(`{"token": "`+tt.token+`"}`))
+
+			rr := httptest.NewRecorder()
+			app.confirmUserEmailHandler(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantStatus)
+			assert.StringContains(t, rr.Body.String(), tt.wantBody)
+
+			// A confirmed change clears the pending email, and signs out every session
+			// along with deleting the email change tokens.
+			if tt.wantStatus == http.StatusOK {
+				updates := db.executed("UPDATE users")
+				assert.Equal(t, updates[0].args[1].(string), "new@example.com")
+				assert.Equal(t, updates[0].args[5] == nil, true)
+
+				deletes := db.executed("DELETE FROM tokens")
+				assert.Equal(t, len(deletes), 2)
+				assert.Equal(t, deletes[0].args[0].(string), data.ScopeEmailChange)
+				assert.Equal(t, deletes[1].args[0].(string), data.ScopeAuthentication)
+			}
+		})
+	}
+}
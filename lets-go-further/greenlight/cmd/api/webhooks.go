@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/validator"
+	"github.com.go-learning.greenlight/internal/webhook"
+)
+
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hook := &data.Webhook{
+		URL:    input.URL,
+		Secret: input.Secret,
+		Events: input.Events,
+		Active: true,
+	}
+
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+
+	// If the client didn't choose a secret, generate one for them. Either way the secret
+	// is sent back in the response below, which is the only time it can be seen.
+	if hook.Secret == "" {
+		hook.Secret, err = webhook.NewSecret()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(hook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", hook.ID))
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"webhook": hook}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeList(w, r, envelope{"webhooks": webhooks}, "webhooks", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	hook, err := app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	hook, err := app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// As with movies, pointer fields let us tell a missing field apart from a zero
+	// value, so that only the fields in the request body are changed. This is how an
+	// admin pauses (or resumes) deliveries to a webhook without deleting it.
+	var input struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Active *bool    `json:"active"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		hook.URL = *input.URL
+	}
+	if input.Events != nil {
+		hook.Events = input.Events
+	}
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if data.ValidateWebhook(v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Update(hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Check that the webhook exists, so that an unknown ID gets a 404 Not Found rather
+	// than an empty delivery log.
+	_, err = app.models.Webhooks.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	// The delivery log can be filtered by status, so that dead deliveries (which will
+	// not be retried without help) are easy to find.
+	input.Status = app.readString(qs, "status", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "-id", "-created_at"}
+
+	v.Check(input.Status == "" || validator.In(input.Status, data.DeliveryPending, data.DeliverySucceeded, data.DeliveryDead), "status", "invalid status value")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.WebhookDeliveries.GetAllForWebhook(id, input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeList(w, r, envelope{"deliveries": deliveries, "metadata": metadata}, "deliveries", nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) retryWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	deliveryID, err := app.readIntParam(r, "delivery")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Only dead deliveries can be retried. Anything else (including a delivery which
+	// belongs to a different webhook) gets a 404 Not Found response.
+	delivery, err := app.models.WebhookDeliveries.Retry(id, deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"delivery": delivery}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deliverWebhooks() method starts a background worker which polls the delivery
+// queue for due deliveries and sends them. A failed attempt is retried with exponential
+// backoff, and once a delivery has used up all of its attempts it is marked as dead.
+func (app *application) deliverWebhooks() {
+	sender := webhook.New(app.config.webhooks.timeout)
+
+	// A claimed delivery isn't picked up again until its lease runs out, which needs to
+	// be long enough to cover sending every delivery in the batch.
+	lease := time.Duration(app.config.webhooks.batchSize)*app.config.webhooks.timeout + time.Minute
+
+	app.worker(app.config.webhooks.pollInterval, func() {
+		deliveries, err := app.models.WebhookDeliveries.ClaimDue(app.config.webhooks.batchSize, lease)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		// Stop between deliveries if the server is shutting down. Any which haven't been
+		// sent are picked up again once their lease runs out.
+		for _, delivery := range deliveries {
+			if app.shuttingDown() {
+				return
+			}
+			app.deliverWebhook(sender, delivery)
+		}
+	})
+}
+
+// deliverWebhook makes a single attempt at sending a claimed delivery, and records the
+// outcome.
+func (app *application) deliverWebhook(sender webhook.Sender, delivery *data.WebhookDelivery) {
+	status, err := sender.Send(delivery.URL, delivery.Secret, delivery.Event, delivery.ID, delivery.Payload)
+	if err == nil {
+		err = app.models.WebhookDeliveries.MarkSucceeded(delivery.ID, status)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		return
+	}
+
+	// Work out when to try again. A nil retryAt means the delivery has run out of
+	// attempts, and will be moved to the dead state.
+	var retryAt *time.Time
+
+	if delivery.Attempts < app.config.webhooks.maxAttempts {
+		t := time.Now().Add(backoff(app.config.webhooks.backoff, delivery.Attempts))
+		retryAt = &t
+	}
+
+	err = app.models.WebhookDeliveries.MarkFailed(delivery.ID, status, err.Error(), retryAt)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
@@ -0,0 +1,206 @@
+// The greenlightctl command carries out admin tasks, such as activating users and granting
+// permissions, directly against the greenlight database. It uses the same data models as
+// the API, and reads the database settings from the same -db-* flags (with the DSN
+// defaulting to the GREENLIGHT_DB_DSN environment variable).
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/database"
+)
+
+// Define an application struct to hold the dependencies of the subcommands. The database
+// is only connected to once a subcommand's arguments have been parsed, so that mistakes
+// in them are reported straight away.
+type application struct {
+	dbConfig database.Config
+	db       *sql.DB
+	models   data.Models
+	stdin    io.Reader
+	stdout   io.Writer
+	format   string // How results are printed: "table" or "json"
+}
+
+// A command is a single subcommand, such as "users list". Its run function is passed the
+// arguments after the subcommand's name, which it parses with its own flag set.
+type command struct {
+	usage       string
+	description string
+	run         func(app *application, args []string) error
+}
+
+// commands holds every subcommand, keyed by the command and then the subcommand name. It
+// is filled in by init(), as the subcommands look up their own usage in it.
+var commands map[string]map[string]command
+
+func init() {
+	commands = map[string]map[string]command{
+		"users": {
+			"list":       {"[-activated=true|false]", "List users", listUsersCommand},
+			"activate":   {"<email>", "Activate a user", activateUserCommand},
+			"deactivate": {"<email>", "Deactivate a user and sign them out", deactivateUserCommand},
+		},
+		"permissions": {
+			"list":   {"<email>", "List a user's permissions", listPermissionsCommand},
+			"grant":  {"<email> <code>...", "Give a user permissions, such as movies:write", grantPermissionsCommand},
+			"revoke": {"<email> <code>...", "Take permissions away from a user", revokePermissionsCommand},
+		},
+		"tokens": {
+			"list":   {"<email>", "List a user's tokens", listTokensCommand},
+			"revoke": {"[-scope=authentication|...|all] <email>", "Delete a user's tokens", revokeTokensCommand},
+			"purge":  {"", "Delete every expired token", purgeTokensCommand},
+		},
+		"movies": {
+			"import": {"[-mode=partial|atomic] [-user=<email>] <file.ndjson|file.csv|->", "Import movies from NDJSON or CSV", importMoviesCommand},
+			"export": {"[-title=<words>] [-genres=<list>] [-csv] [file]", "Export movies as NDJSON or CSV", exportMoviesCommand},
+		},
+	}
+}
+
+// errUsage is returned by a subcommand when it was given the wrong arguments. Its usage
+// has already been printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	var dbConfig database.Config
+	var format string
+
+	dbConfig.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&format, "format", "table", "Output format (table|json)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if format != "table" && format != "json" {
+		fmt.Fprintf(os.Stderr, "greenlightctl: -format must be table or json\n")
+		os.Exit(2)
+	}
+
+	args := flag.Args()
+	if len(args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "greenlightctl: unknown command %q\n\n", strings.Join(args[:2], " "))
+		usage()
+		os.Exit(2)
+	}
+
+	app := &application{
+		dbConfig: dbConfig,
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+		format:   format,
+	}
+
+	err := cmd.run(app, args[2:])
+
+	if app.db != nil {
+		app.db.Close()
+	}
+
+	if err != nil {
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+
+		fmt.Fprintf(os.Stderr, "greenlightctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// usage prints the global flags and a list of the subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage: greenlightctl [flags] <command> <subcommand> [arguments]\n\nCommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Each command is listed in the same layout as flag.PrintDefaults() uses for flags.
+	for _, name := range names {
+		subnames := make([]string, 0, len(commands[name]))
+		for subname := range commands[name] {
+			subnames = append(subnames, subname)
+		}
+		sort.Strings(subnames)
+
+		for _, subname := range subnames {
+			cmd := commands[name][subname]
+			fmt.Fprintf(out, "  %s\n    \t%s\n", strings.TrimSpace(name+" "+subname+" "+cmd.usage), cmd.description)
+		}
+	}
+
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// newFlagSet returns the flag set for a subcommand, which prints the subcommand's usage
+// if its arguments can't be parsed.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.Usage = func() {
+		parts := strings.SplitN(name, " ", 2)
+		cmd := commands[parts[0]][parts[1]]
+
+		fmt.Fprintf(fs.Output(), "Usage: greenlightctl %s %s\n", name, cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseArgs parses the arguments of a subcommand, and checks that it was given between
+// min and max positional arguments (with max < 0 meaning no limit). If they are fine, it
+// then connects to the database.
+func (app *application) parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, errUsage
+	}
+
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		fs.Usage()
+		return nil, errUsage
+	}
+
+	app.db, err = database.Open(app.dbConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	app.models = data.NewModels(app.db)
+
+	return fs.Args(), nil
+}
+
+// getUser looks up a user by their email address.
+func (app *application) getUser(email string) (*data.User, error) {
+	user, err := app.models.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, fmt.Errorf("no user with the email address %q", email)
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
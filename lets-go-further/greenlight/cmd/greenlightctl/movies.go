@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com.go-learning.greenlight/internal/bulk"
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+// importMoviesCommand imports movies in the same way as POST /v1/movies/import, from an
+// NDJSON or CSV file (or stdin, if the file is "-"). A file ending in .csv is read as
+// CSV, as is stdin with the -csv flag.
+func importMoviesCommand(app *application, args []string) error {
+	fs := newFlagSet("movies import")
+	mode := fs.String("mode", "partial", "What to do when rows are rejected: import the valid ones (partial), or none (atomic)")
+	email := fs.String("user", "", "Email address of the user to record as adding the movies")
+	asCSV := fs.Bool("csv", false, "Read the file as CSV")
+	batchSize := fs.Int("batch-size", 500, "Number of movies inserted per batch")
+
+	args, err := app.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	if !validator.In(*mode, "partial", "atomic") {
+		return errors.New("-mode must be either partial or atomic")
+	}
+
+	if *batchSize < 1 || *batchSize > data.MaxInsertBatch {
+		return fmt.Errorf("-batch-size must be between 1 and %d", data.MaxInsertBatch)
+	}
+
+	var userID int64
+
+	if *email != "" {
+		user, err := app.getUser(*email)
+		if err != nil {
+			return err
+		}
+		userID = user.ID
+	}
+
+	var body io.Reader = app.stdin
+
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		body = f
+	}
+
+	readRows := bulk.ReadNDJSON
+	if *asCSV || strings.EqualFold(filepath.Ext(args[0]), ".csv") {
+		readRows = bulk.ReadCSV
+	}
+
+	report := &bulk.Report{Mode: *mode, Rows: []bulk.Row{}}
+
+	importer := &bulk.Importer{
+		UserID:    userID,
+		Atomic:    *mode == "atomic",
+		BatchSize: *batchSize,
+		Report:    report,
+	}
+
+	if importer.Atomic {
+		err = app.models.Transaction(func(tx data.Models) error {
+			importer.Models = tx
+			return importer.Run(body, readRows)
+		})
+	} else {
+		importer.Models = app.models
+		err = importer.Run(body, readRows)
+	}
+
+	// As with the import endpoint, in partial mode the accepted rows are kept even if
+	// the file was cut short.
+	var bodyErr *bulk.BodyError
+	report.Committed = err == nil || (!importer.Atomic && errors.As(err, &bodyErr))
+
+	// The report is printed whatever happened, so that it is clear which rows need
+	// fixing and which were already imported.
+	if err == nil || errors.Is(err, bulk.ErrRejected) || errors.As(err, &bodyErr) {
+		printErr := app.printImportReport(report)
+		if printErr != nil {
+			return printErr
+		}
+	}
+
+	switch {
+	case errors.Is(err, bulk.ErrRejected):
+		return fmt.Errorf("nothing was imported, as %d rows were rejected", report.Rejected)
+	case err != nil:
+		return err
+	}
+
+	return nil
+}
+
+// printImportReport prints an import report. As a table only the rejected rows are listed,
+// followed by the totals.
+func (app *application) printImportReport(report *bulk.Report) error {
+	if app.format == "json" {
+		return app.print(table{value: report})
+	}
+
+	if report.Rejected > 0 {
+		t := table{header: []string{"LINE", "FIELD", "ERROR"}}
+
+		for _, row := range report.Rows {
+			if row.Status != "rejected" {
+				continue
+			}
+
+			for field, message := range row.Errors {
+				t.rows = append(t.rows, []string{strconv.Itoa(row.Line), field, message})
+			}
+		}
+
+		err := app.print(t)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.message(fmt.Sprintf("%d accepted, %d rejected, committed: %t", report.Accepted, report.Rejected, report.Committed))
+}
+
+// exportMoviesCommand exports movies in the same way as GET /v1/movies/export, as NDJSON
+// or CSV, to a file or stdout. The output can be imported again with movies import.
+func exportMoviesCommand(app *application, args []string) error {
+	fs := newFlagSet("movies export")
+	title := fs.String("title", "", "Only export movies whose title contains these words")
+	genres := fs.String("genres", "", "Only export movies which have all of these comma-separated genres")
+	asCSV := fs.Bool("csv", false, "Write CSV rather than NDJSON")
+	batchSize := fs.Int("batch-size", 500, "Number of movies fetched per batch")
+
+	args, err := app.parseArgs(fs, args, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	if *batchSize < 1 {
+		return errors.New("-batch-size must be greater than zero")
+	}
+
+	var genreList []string
+	if *genres != "" {
+		genreList = strings.Split(*genres, ",")
+	}
+
+	// The file name's extension picks the format, as for movies import.
+	toFile := len(args) == 1 && args[0] != "-"
+	if toFile && strings.EqualFold(filepath.Ext(args[0]), ".csv") {
+		*asCSV = true
+	}
+
+	out := app.stdout
+
+	if toFile {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		out = f
+	}
+
+	bw := bufio.NewWriter(out)
+
+	// Both writers buffer their output, so flush returns any error from writing it out.
+	var (
+		writeMovie func(*data.Movie) error
+		flush      func() error
+	)
+
+	if *asCSV {
+		cw := csv.NewWriter(bw)
+		cw.Write(bulk.CSVHeader)
+
+		writeMovie = func(movie *data.Movie) error {
+			cw.Write(bulk.CSVRecord(movie))
+			return cw.Error()
+		}
+		flush = func() error {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+	} else {
+		enc := json.NewEncoder(bw)
+
+		writeMovie = func(movie *data.Movie) error { return enc.Encode(movie) }
+		flush = bw.Flush
+	}
+
+	exported := 0
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		return tx.Movies.Export(context.Background(), *title, genreList, *batchSize, func(movie *data.Movie) error {
+			exported++
+			return writeMovie(movie)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = flush()
+	if err != nil {
+		return err
+	}
+
+	// The summary goes to stderr, so that it can't end up in the middle of the output.
+	if toFile {
+		if f, ok := out.(*os.File); ok {
+			err = f.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "exported %d movies to %s\n", exported, args[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"text/tabwriter"
+)
+
+// A table holds a command's results for printing. The value is what is printed with
+// -format=json, and the header and rows are what is printed as a table.
+type table struct {
+	value  interface{}
+	header []string
+	rows   [][]string
+}
+
+// print writes a command's results to stdout in the chosen format.
+func (app *application) print(t table) error {
+	if app.format == "json" {
+		enc := json.NewEncoder(app.stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(t.value)
+	}
+
+	tw := tabwriter.NewWriter(app.stdout, 0, 0, 2, ' ', 0)
+
+	tw.Write([]byte(strings.Join(t.header, "\t") + "\n"))
+	for _, row := range t.rows {
+		tw.Write([]byte(strings.Join(row, "\t") + "\n"))
+	}
+
+	return tw.Flush()
+}
+
+// message prints a message about what a command did. With -format=json it is printed as
+// {"message": "..."}, like the API's messages.
+func (app *application) message(text string) error {
+	if app.format == "json" {
+		return app.print(table{value: map[string]string{"message": text}})
+	}
+
+	_, err := app.stdout.Write([]byte(text + "\n"))
+	return err
+}
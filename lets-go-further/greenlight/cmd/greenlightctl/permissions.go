@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+func listPermissionsCommand(app *application, args []string) error {
+	fs := newFlagSet("permissions list")
+
+	args, err := app.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getUser(args[0])
+	if err != nil {
+		return err
+	}
+
+	return app.printPermissions(user)
+}
+
+func grantPermissionsCommand(app *application, args []string) error {
+	return changePermissions(app, "permissions grant", args, app.models.Permissions.AddPermissionsForUser)
+}
+
+func revokePermissionsCommand(app *application, args []string) error {
+	return changePermissions(app, "permissions revoke", args, app.models.Permissions.RemovePermissionsForUser)
+}
+
+// changePermissions does the work for the permissions grant and revoke commands, and
+// then prints the user's permissions. The codes are checked first, as the database would
+// otherwise ignore a misspelt one.
+func changePermissions(app *application, name string, args []string, change func(userID int64, codes ...string) error) error {
+	fs := newFlagSet(name)
+
+	args, err := app.parseArgs(fs, args, 2, -1)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getUser(args[0])
+	if err != nil {
+		return err
+	}
+
+	known, err := app.models.Permissions.GetAllCodes()
+	if err != nil {
+		return err
+	}
+
+	codes := args[1:]
+
+	for _, code := range codes {
+		if !validator.In(code, known...) {
+			return fmt.Errorf("unknown permission %q (must be one of: %s)", code, strings.Join(known, ", "))
+		}
+	}
+
+	err = change(user.ID, codes...)
+	if err != nil {
+		return err
+	}
+
+	return app.printPermissions(user)
+}
+
+// printPermissions prints a user's permissions.
+func (app *application) printPermissions(user *data.User) error {
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	t := table{
+		value:  map[string]interface{}{"email": user.Email, "permissions": permissions},
+		header: []string{"EMAIL", "PERMISSION"},
+	}
+
+	for _, code := range permissions {
+		t.rows = append(t.rows, []string{user.Email, code})
+	}
+
+	return app.print(t)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+// tokenInfo is what is printed about a token. Only the token's hash is stored, so the
+// start of the hash is shown to tell tokens apart.
+type tokenInfo struct {
+	Scope   string    `json:"scope"`
+	Expiry  time.Time `json:"expiry"`
+	Expired bool      `json:"expired"`
+	Hash    string    `json:"hash"`
+}
+
+func listTokensCommand(app *application, args []string) error {
+	fs := newFlagSet("tokens list")
+
+	args, err := app.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getUser(args[0])
+	if err != nil {
+		return err
+	}
+
+	tokens, err := app.models.Tokens.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	infos := make([]tokenInfo, len(tokens))
+
+	t := table{
+		value:  infos,
+		header: []string{"SCOPE", "EXPIRY", "EXPIRED", "HASH"},
+	}
+
+	for i, token := range tokens {
+		infos[i] = tokenInfo{
+			Scope:   token.Scope,
+			Expiry:  token.Expiry.UTC(),
+			Expired: token.Expiry.Before(time.Now()),
+			Hash:    hex.EncodeToString(token.Hash)[:12],
+		}
+
+		t.rows = append(t.rows, []string{
+			infos[i].Scope,
+			infos[i].Expiry.Format(time.RFC3339),
+			strconv.FormatBool(infos[i].Expired),
+			infos[i].Hash,
+		})
+	}
+
+	return app.print(t)
+}
+
+func revokeTokensCommand(app *application, args []string) error {
+	fs := newFlagSet("tokens revoke")
+	scope := fs.String("scope", data.ScopeAuthentication, "The scope of the tokens to delete, or all")
+
+	args, err := app.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	scopes := []string{*scope}
+
+	switch {
+	case *scope == "all":
+		scopes = data.Scopes
+	case !validator.In(*scope, data.Scopes...):
+		return fmt.Errorf("-scope must be all or one of: %v", data.Scopes)
+	}
+
+	user, err := app.getUser(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		for _, scope := range scopes {
+			err := tx.Tokens.DeleteAllForUser(scope, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.message(fmt.Sprintf("deleted the %s tokens of %s", *scope, user.Email))
+}
+
+func purgeTokensCommand(app *application, args []string) error {
+	fs := newFlagSet("tokens purge")
+
+	_, err := app.parseArgs(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := app.models.Tokens.DeleteExpired()
+	if err != nil {
+		return err
+	}
+
+	return app.message(fmt.Sprintf("deleted %d expired tokens", deleted))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+func listUsersCommand(app *application, args []string) error {
+	fs := newFlagSet("users list")
+	activated := fs.String("activated", "", "Only list activated (true) or unactivated (false) users")
+
+	_, err := app.parseArgs(fs, args, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	// An empty -activated flag lists every user.
+	var filter *bool
+
+	if *activated != "" {
+		value, err := strconv.ParseBool(*activated)
+		if err != nil {
+			return fmt.Errorf("-activated must be true or false")
+		}
+		filter = &value
+	}
+
+	users, err := app.models.Users.GetAll(filter)
+	if err != nil {
+		return err
+	}
+
+	t := table{
+		value:  users,
+		header: []string{"ID", "EMAIL", "NAME", "ACTIVATED", "CREATED"},
+	}
+
+	for _, user := range users {
+		t.rows = append(t.rows, []string{
+			strconv.FormatInt(user.ID, 10),
+			user.Email,
+			user.Name,
+			strconv.FormatBool(user.Activated),
+			user.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	return app.print(t)
+}
+
+func activateUserCommand(app *application, args []string) error {
+	return setUserActivated(app, "users activate", args, true)
+}
+
+func deactivateUserCommand(app *application, args []string) error {
+	return setUserActivated(app, "users deactivate", args, false)
+}
+
+// setUserActivated does the work for the users activate and deactivate commands. Like
+// the activation endpoint, activating a user deletes their activation tokens, and
+// deactivating a user signs them out by deleting their authentication tokens.
+func setUserActivated(app *application, name string, args []string, activated bool) error {
+	fs := newFlagSet(name)
+
+	args, err := app.parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getUser(args[0])
+	if err != nil {
+		return err
+	}
+
+	if user.Activated == activated {
+		return app.message(fmt.Sprintf("%s is already %s", user.Email, activatedText(activated)))
+	}
+
+	user.Activated = activated
+
+	err = app.models.Transaction(func(tx data.Models) error {
+		err := tx.Users.Update(user)
+		if err != nil {
+			return err
+		}
+
+		if activated {
+			return tx.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		}
+		return tx.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.message(fmt.Sprintf("%s is now %s", user.Email, activatedText(activated)))
+}
+
+func activatedText(activated bool) string {
+	if activated {
+		return "activated"
+	}
+	return "deactivated"
+}
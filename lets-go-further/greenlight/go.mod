@@ -10,7 +10,9 @@ require golang.org/x/time v0.3.0
 
 require golang.org/x/crypto v0.9.0
 
-require github.com/felixge/httpsnoop v1.0.1 // indirect
+require github.com/felixge/httpsnoop v1.0.1
+
+require github.com/andybalholm/brotli v1.1.0
 
 require (
 	github.com/go-mail/mail/v2 v2.3.0
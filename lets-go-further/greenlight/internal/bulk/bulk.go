@@ -0,0 +1,398 @@
+// Package bulk reads the NDJSON and CSV files that movies are imported from, and writes
+// the CSV files that they are exported to. It is shared by the API's import and export
+// endpoints and the greenlightctl command.
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com.go-learning.greenlight/internal/data"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+// ErrRejected is returned by Importer.Run() in all-or-nothing mode when at least one row
+// was rejected, so that the transaction around the import is rolled back.
+var ErrRejected = errors.New("import contains rejected rows")
+
+// Row holds the outcome for a single line of an import body. The ID is only set
+// for accepted rows, and the Errors map only for rejected ones.
+type Row struct {
+	Line   int               `json:"line"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// Report is the per-line report of an import, which is filled in as the rows are read.
+// If the import has more rows than the Importer's MaxRows, the rows after that are
+// still counted but aren't listed, and Truncated is set.
+type Report struct {
+	Mode      string `json:"mode"`
+	Committed bool   `json:"committed"`
+	Accepted  int    `json:"accepted"`
+	Rejected  int    `json:"rejected"`
+	Truncated bool   `json:"truncated"`
+	Rows      []Row  `json:"rows"`
+}
+
+// Importer validates the rows of an import one at a time and inserts the valid
+// ones in batches, so that memory use stays flat however large the body is. In Atomic
+// (all-or-nothing) mode the Models should belong to a transaction, which is rolled back
+// if Run() returns an error.
+type Importer struct {
+	Models    data.Models
+	UserID    int64 // The user recorded as adding the movies
+	Atomic    bool
+	BatchSize int
+	MaxRows   int // The most rows listed in the report, or 0 for no limit
+	Report    *Report
+
+	// The pending batch of valid movies, along with the index of each movie's row in
+	// the report so that we can fill in its ID once it has been inserted. The index is
+	// -1 for a movie whose row isn't listed.
+	batch []*data.Movie
+	index []int
+}
+
+// add records the outcome for a single row. A non-nil rowErr means the row couldn't be
+// decoded at all, in which case it is rejected without being validated.
+func (mi *Importer) add(line int, movie *data.Movie, rowErr error) error {
+	v := validator.New()
+
+	if rowErr != nil {
+		v.AddError("row", rowErr.Error())
+	} else {
+		data.ValidateMovie(v, movie)
+	}
+
+	if !v.Valid() {
+		mi.Report.Rejected++
+		mi.list(Row{Line: line, Status: "rejected", Errors: v.Errors})
+		return nil
+	}
+
+	mi.Report.Accepted++
+	index := mi.list(Row{Line: line, Status: "accepted"})
+
+	// In all-or-nothing mode there is no point in inserting anything else once a row has
+	// been rejected, as the transaction is going to be rolled back anyway. We carry on
+	// validating so that the client gets a complete report.
+	if mi.Atomic && mi.Report.Rejected > 0 {
+		return nil
+	}
+
+	mi.batch = append(mi.batch, movie)
+	mi.index = append(mi.index, index)
+
+	if len(mi.batch) >= mi.BatchSize {
+		return mi.flush()
+	}
+
+	return nil
+}
+
+// list adds a row to the report and returns its index, unless the report already lists
+// MaxRows rows, in which case it is marked as truncated and -1 is returned. This keeps the
+// memory used by the report bounded, however large the import is.
+func (mi *Importer) list(row Row) int {
+	if mi.MaxRows > 0 && len(mi.Report.Rows) >= mi.MaxRows {
+		mi.Report.Truncated = true
+		return -1
+	}
+
+	mi.Report.Rows = append(mi.Report.Rows, row)
+	return len(mi.Report.Rows) - 1
+}
+
+// flush inserts the pending batch of movies and copies the generated IDs into the report.
+func (mi *Importer) flush() error {
+	if len(mi.batch) == 0 {
+		return nil
+	}
+
+	err := mi.Models.Movies.InsertBatch(mi.batch, mi.UserID)
+	if err != nil {
+		return err
+	}
+
+	for i, movie := range mi.batch {
+		if mi.index[i] >= 0 {
+			mi.Report.Rows[mi.index[i]].ID = movie.ID
+		}
+	}
+
+	mi.batch = mi.batch[:0]
+	mi.index = mi.index[:0]
+
+	return nil
+}
+
+// RowFunc is called by the row readers for every row in an import body. A non-nil
+// rowErr means that the row couldn't be decoded into a movie.
+type RowFunc func(line int, movie *data.Movie, rowErr error) error
+
+// Run reads every row from the body with readRows (such as ReadNDJSON), then inserts
+// whatever is left in the final batch. In partial mode the valid rows read so far are
+// still inserted if the body can't be read to the end, and the *BodyError is returned.
+func (mi *Importer) Run(body io.Reader, readRows func(io.Reader, RowFunc) error) error {
+	err := readRows(body, mi.add)
+
+	var importErr *BodyError
+	if err != nil && (mi.Atomic || !errors.As(err, &importErr)) {
+		return err
+	}
+
+	if mi.Atomic && mi.Report.Rejected > 0 {
+		return ErrRejected
+	}
+
+	flushErr := mi.flush()
+	if flushErr != nil {
+		return flushErr
+	}
+
+	return err
+}
+
+// CSVHeader holds the column names used when movies are exported as CSV. They are the
+// same as the ones ReadCSV() reads.
+var CSVHeader = []string{"id", "title", "year", "runtime", "genres", "version"}
+
+// CSVRecord returns the CSV record for a movie, with the columns in CSVHeader.
+func CSVRecord(movie *data.Movie) []string {
+	return []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		strconv.FormatInt(int64(movie.Runtime), 10),
+		strings.Join(movie.Genres, ","),
+		strconv.FormatInt(int64(movie.Version), 10),
+	}
+}
+
+// BodyError wraps an error which stopped an import body being read part way through,
+// such as the body exceeding the size limit or a malformed CSV record.
+type BodyError struct {
+	line int
+	err  error
+}
+
+func (e *BodyError) Error() string {
+	var maxBytesError *http.MaxBytesError
+
+	if errors.As(e.err, &maxBytesError) {
+		return fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit)
+	}
+
+	if e.line == 0 {
+		return fmt.Sprintf("unable to read body: %s", e.err)
+	}
+
+	return fmt.Sprintf("unable to read body at line %d: %s", e.line, e.err)
+}
+
+func (e *BodyError) Unwrap() error {
+	return e.err
+}
+
+// ReadNDJSON reads newline-delimited JSON movies from body, calling fn for each
+// non-blank line. Each line has the same shape as the POST /v1/movies request body.
+func ReadNDJSON(body io.Reader, fn RowFunc) error {
+	return ReadNDJSONConverted(body, fn, nil)
+}
+
+// ReadNDJSONConverted works in the same way as ReadNDJSON(), but if convert isn't nil it
+// is used to convert each line to the POST /v1/movies shape before it is decoded, such
+// as from the /v2 shape.
+func ReadNDJSONConverted(body io.Reader, fn RowFunc, convert func([]byte) ([]byte, error)) error {
+	scanner := bufio.NewScanner(body)
+
+	// Individual lines are held to the same 1MB limit as a single JSON request body.
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		if convert != nil {
+			converted, err := convert(raw)
+			if err != nil {
+				err = fn(line, nil, fmt.Errorf("line contains %w", err))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			raw = converted
+		}
+
+		// The id and version fields are accepted but ignored, so that the output of
+		// GET /v1/movies/export can be imported as-is.
+		var input struct {
+			ID      int64        `json:"id"`
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+			Version int32        `json:"version"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+
+		var rowErr error
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		err := dec.Decode(&input)
+
+		switch {
+		case err == nil && dec.More():
+			rowErr = errors.New("line must only contain a single JSON value")
+		case err == nil:
+		case errors.As(err, &syntaxError), errors.Is(err, io.ErrUnexpectedEOF):
+			rowErr = errors.New("line contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			rowErr = fmt.Errorf("line contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		default:
+			rowErr = errors.New(strings.TrimPrefix(err.Error(), "json: "))
+		}
+
+		if rowErr != nil {
+			err = fn(line, nil, rowErr)
+		} else {
+			err = fn(line, &data.Movie{
+				Title:   input.Title,
+				Year:    input.Year,
+				Runtime: input.Runtime,
+				Genres:  input.Genres,
+			}, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return &BodyError{line: line + 1, err: err}
+	}
+
+	return nil
+}
+
+// ReadCSV reads movies from a CSV body, calling fn for each record. The first
+// record must be a header naming the title, year, runtime and genres columns (in any
+// order). The id and version columns written by an export are ignored. Runtime may be
+// given either as a number of minutes or as "<n> mins", and genres as a comma-separated
+// list.
+func ReadCSV(body io.Reader, fn RowFunc) error {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return &BodyError{line: 1, err: errors.New("missing header record")}
+		}
+		return &BodyError{line: 1, err: err}
+	}
+
+	columns := make(map[string]int)
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if !validator.In(name, "id", "title", "year", "runtime", "genres", "version") {
+			return &BodyError{line: 1, err: fmt.Errorf("unknown column %q", name)}
+		}
+		columns[name] = i
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			var parseError *csv.ParseError
+			if !errors.As(err, &parseError) {
+				return &BodyError{err: err}
+			}
+
+			// A record with the wrong number of fields only affects that row, so we
+			// reject it and carry on. Any other parse error means we can't trust the rest
+			// of the body.
+			if errors.Is(err, csv.ErrFieldCount) {
+				err = fn(parseError.StartLine, nil, errors.New("record has the wrong number of fields"))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			return &BodyError{line: parseError.Line, err: parseError.Err}
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		movie, rowErr := csvRecordToMovie(record, columns)
+
+		err = fn(line, movie, rowErr)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// csvRecordToMovie converts a single CSV record to a Movie. Missing columns are left at
+// their zero value, so that ValidateMovie() reports them as not provided.
+func csvRecordToMovie(record []string, columns map[string]int) (*data.Movie, error) {
+	movie := &data.Movie{}
+
+	if i, ok := columns["title"]; ok {
+		movie.Title = strings.TrimSpace(record[i])
+	}
+
+	if i, ok := columns["year"]; ok && strings.TrimSpace(record[i]) != "" {
+		year, err := strconv.ParseInt(strings.TrimSpace(record[i]), 10, 32)
+		if err != nil {
+			return nil, errors.New("year must be an integer value")
+		}
+		movie.Year = int32(year)
+	}
+
+	if i, ok := columns["runtime"]; ok && strings.TrimSpace(record[i]) != "" {
+		runtime := strings.TrimSuffix(strings.TrimSpace(record[i]), " mins")
+
+		minutes, err := strconv.ParseInt(runtime, 10, 32)
+		if err != nil {
+			return nil, data.ErrInvalidRuntimeFormat
+		}
+		movie.Runtime = data.Runtime(minutes)
+	}
+
+	if i, ok := columns["genres"]; ok && strings.TrimSpace(record[i]) != "" {
+		for _, genre := range strings.Split(record[i], ",") {
+			movie.Genres = append(movie.Genres, strings.TrimSpace(genre))
+		}
+	}
+
+	return movie, nil
+}
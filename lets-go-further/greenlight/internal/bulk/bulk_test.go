@@ -0,0 +1,301 @@
+package bulk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/data"
+)
+
+// readAll reads an import body with readRows, and returns a line for each row: either
+// the movie it was decoded into, or the reason it couldn't be.
+func readAll(t *testing.T, readRows func(string, RowFunc) error, body string) ([]string, error) {
+	t.Helper()
+
+	var rows []string
+
+	err := readRows(body, func(line int, movie *data.Movie, rowErr error) error {
+		if rowErr != nil {
+			rows = append(rows, fmt.Sprintf("%d: %s", line, rowErr))
+			return nil
+		}
+
+		rows = append(rows, fmt.Sprintf("%d: %s %d %d %q", line, movie.Title, movie.Year, movie.Runtime, movie.Genres))
+		return nil
+	})
+
+	return rows, err
+}
+
+func TestReadNDJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantRows []string
+		wantErr  string
+	}{
+		{
+			name:     "Valid",
+			body:     `{"title": "Casablanca", "year": 1942, "runtime": "102 mins", "genres": ["drama", "romance"]}`,
+			wantRows: []string{`1: Casablanca 1942 102 ["drama" "romance"]`},
+		},
+		{
+			name:     "Export output",
+			body:     `{"id": 7, "title": "Casablanca", "year": 1942, "runtime": "102 mins", "genres": ["drama"], "version": 3}`,
+			wantRows: []string{`1: Casablanca 1942 102 ["drama"]`},
+		},
+		{
+			name: "Blank lines",
+			body: "\n{\"title\": \"Casablanca\"}\n   \n{\"title\": \"Vertigo\"}\n",
+			wantRows: []string{
+				`2: Casablanca 0 0 []`,
+				`4: Vertigo 0 0 []`,
+			},
+		},
+		{
+			name:     "Badly-formed JSON",
+			body:     `{"title": "Casablanca"`,
+			wantRows: []string{`1: line contains badly-formed JSON`},
+		},
+		{
+			name:     "Wrong type",
+			body:     `{"title": "Casablanca", "year": "1942"}`,
+			wantRows: []string{`1: line contains incorrect JSON type for field "year"`},
+		},
+		{
+			name:     "Unknown field",
+			body:     `{"title": "Casablanca", "rating": 5}`,
+			wantRows: []string{`1: unknown field "rating"`},
+		},
+		{
+			name:     "Invalid runtime",
+			body:     `{"title": "Casablanca", "runtime": 102}`,
+			wantRows: []string{`1: invalid runtime format`},
+		},
+		{
+			name:     "Two values",
+			body:     `{"title": "Casablanca"} {"title": "Vertigo"}`,
+			wantRows: []string{`1: line must only contain a single JSON value`},
+		},
+		{
+			name: "Bad rows don't stop the import",
+			body: "{\"title\": \"Casablanca\"}\nnot json\n{\"title\": \"Vertigo\"}",
+			wantRows: []string{
+				`1: Casablanca 0 0 []`,
+				`2: line contains badly-formed JSON`,
+				`3: Vertigo 0 0 []`,
+			},
+		},
+		{
+			name:     "Line too long",
+			body:     "{\"title\": \"Casablanca\"}\n" + strings.Repeat("x", 1_048_577),
+			wantRows: []string{`1: Casablanca 0 0 []`},
+			wantErr:  "unable to read body at line 2: bufio.Scanner: token too long",
+		},
+	}
+
+	readNDJSON := func(body string, fn RowFunc) error {
+		return ReadNDJSON(strings.NewReader(body), fn)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := readAll(t, readNDJSON, tt.body)
+
+			assert.Equal(t, strings.Join(rows, "\n"), strings.Join(tt.wantRows, "\n"))
+
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+			} else {
+				var bodyErr *BodyError
+				assert.Equal(t, errors.As(err, &bodyErr), true)
+				assert.Equal(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadNDJSONConverted(t *testing.T) {
+	convert := func(raw []byte) ([]byte, error) {
+		if strings.Contains(string(raw), "bad") {
+			return nil, errors.New("an invalid value")
+		}
+		return []byte(strings.ReplaceAll(string(raw), `"minutes"`, `"runtime"`)), nil
+	}
+
+	readConverted := func(body string, fn RowFunc) error {
+		return ReadNDJSONConverted(strings.NewReader(body), fn, convert)
+	}
+
+	rows, err := readAll(t, readConverted, "{\"title\": \"Casablanca\", \"minutes\": \"102 mins\"}\n{\"title\": \"bad\"}")
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Join(rows, "\n"), "1: Casablanca 0 102 []\n2: line contains an invalid value")
+}
+
+func TestReadCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantRows []string
+		wantErr  string
+	}{
+		{
+			name:     "Valid",
+			body:     "title,year,runtime,genres\nCasablanca,1942,102,\"drama,romance\"\n",
+			wantRows: []string{`2: Casablanca 1942 102 ["drama" "romance"]`},
+		},
+		{
+			name:     "Any column order",
+			body:     "Genres, Runtime, Year, Title\n\"drama, war\", 102 mins, 1942, Casablanca\n",
+			wantRows: []string{`2: Casablanca 1942 102 ["drama" "war"]`},
+		},
+		{
+			name:     "Export output",
+			body:     "id,title,year,runtime,genres,version\n7,Casablanca,1942,102,drama,3\n",
+			wantRows: []string{`2: Casablanca 1942 102 ["drama"]`},
+		},
+		{
+			name:     "Missing columns",
+			body:     "title\nCasablanca\n",
+			wantRows: []string{`2: Casablanca 0 0 []`},
+		},
+		{
+			name:     "Empty values",
+			body:     "title,year,runtime,genres\nCasablanca,,,\n",
+			wantRows: []string{`2: Casablanca 0 0 []`},
+		},
+		{
+			name:     "Invalid year",
+			body:     "title,year\nCasablanca,nineteen\n",
+			wantRows: []string{`2: year must be an integer value`},
+		},
+		{
+			name:     "Invalid runtime",
+			body:     "title,runtime\nCasablanca,102 minutes\n",
+			wantRows: []string{`2: invalid runtime format`},
+		},
+		{
+			name: "Wrong number of fields",
+			body: "title,year\nCasablanca\nVertigo,1958\n",
+			wantRows: []string{
+				`2: record has the wrong number of fields`,
+				`3: Vertigo 1958 0 []`,
+			},
+		},
+		{
+			name: "Multi-line record",
+			body: "title,year\n\"Casa\nblanca\",1942\nVertigo,1958\n",
+			wantRows: []string{
+				"2: Casa\nblanca 1942 0 []",
+				`4: Vertigo 1958 0 []`,
+			},
+		},
+		{
+			name:    "Empty body",
+			body:    "",
+			wantErr: "unable to read body at line 1: missing header record",
+		},
+		{
+			name:    "Unknown column",
+			body:    "title,rating\n",
+			wantErr: `unable to read body at line 1: unknown column "rating"`,
+		},
+		{
+			name:     "Malformed quotes",
+			body:     "title,year\nCasablanca,1942\n\"Vert\"igo,1958\n",
+			wantRows: []string{`2: Casablanca 1942 0 []`},
+			wantErr:  `unable to read body at line 3: extraneous or missing " in quoted-field`,
+		},
+	}
+
+	readCSV := func(body string, fn RowFunc) error {
+		return ReadCSV(strings.NewReader(body), fn)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := readAll(t, readCSV, tt.body)
+
+			assert.Equal(t, strings.Join(rows, "\n"), strings.Join(tt.wantRows, "\n"))
+
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+			} else {
+				var bodyErr *BodyError
+				assert.Equal(t, errors.As(err, &bodyErr), true)
+				assert.Equal(t, err.Error(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCSVRecord(t *testing.T) {
+	movie := &data.Movie{ID: 7, Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama", "romance"}, Version: 3}
+
+	record := CSVRecord(movie)
+	assert.Equal(t, len(record), len(CSVHeader))
+	assert.Equal(t, strings.Join(record, "|"), "7|Casablanca|1942|102|drama,romance|3")
+
+	// An exported record can be imported again.
+	var body strings.Builder
+	body.WriteString(strings.Join(CSVHeader, ",") + "\n")
+	body.WriteString(`7,Casablanca,1942,102,"drama,romance",3` + "\n")
+
+	rows, err := readAll(t, func(body string, fn RowFunc) error {
+		return ReadCSV(strings.NewReader(body), fn)
+	}, body.String())
+	assert.NilError(t, err)
+	assert.Equal(t, strings.Join(rows, "\n"), `2: Casablanca 1942 102 ["drama" "romance"]`)
+}
+
+func TestImporterReport(t *testing.T) {
+	body := "title,year,runtime,genres\n" +
+		"Casablanca,1942,102,\"drama,romance\"\n" +
+		"Rear Window,1954,112,\"mystery,,thriller\"\n" +
+		"Vertigo,1958,128,mystery\n"
+
+	tests := []struct {
+		name          string
+		maxRows       int
+		wantRows      string
+		wantTruncated bool
+	}{
+		{
+			name:     "No limit",
+			maxRows:  0,
+			wantRows: "[2 accepted 3 rejected map[genres[1]:must be provided] 4 accepted]",
+		},
+		{
+			name:          "Limited",
+			maxRows:       2,
+			wantRows:      "[2 accepted 3 rejected map[genres[1]:must be provided]]",
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The batch is never filled, so nothing is inserted.
+			mi := &Importer{BatchSize: 10, MaxRows: tt.maxRows, Report: &Report{}}
+
+			err := ReadCSV(strings.NewReader(body), mi.add)
+			assert.NilError(t, err)
+
+			var rows []string
+			for _, row := range mi.Report.Rows {
+				rows = append(rows, fmt.Sprint(row.Line, " ", row.Status))
+				if row.Errors != nil {
+					rows = append(rows, fmt.Sprint(row.Errors))
+				}
+			}
+
+			assert.Equal(t, fmt.Sprint(rows), tt.wantRows)
+			assert.Equal(t, mi.Report.Accepted, 2)
+			assert.Equal(t, mi.Report.Rejected, 1)
+			assert.Equal(t, mi.Report.Truncated, tt.wantTruncated)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AuthFailure holds the count of recent failed sign in attempts for an account or IP
+// address, and whether further attempts are blocked. A Locked block is a lockout, rather
+// than one of the short delays applied after the first few failures.
+type AuthFailure struct {
+	Key          string
+	Failures     int
+	BlockedUntil *time.Time
+	Locked       bool
+}
+
+// Blocked reports whether attempts are currently blocked, and for how much longer.
+func (f *AuthFailure) Blocked() (bool, time.Duration) {
+	if f.BlockedUntil == nil {
+		return false, 0
+	}
+
+	remaining := time.Until(*f.BlockedUntil)
+	return remaining > 0, remaining
+}
+
+// Define the AuthFailureModel type.
+type AuthFailureModel struct {
+	DB DBTX
+}
+
+// Get returns the failures recorded for key. A key without any failures gets a zero
+// AuthFailure, rather than an error.
+func (m AuthFailureModel) Get(key string) (*AuthFailure, error) {
+	query := `
+		SELECT failures, blocked_until, locked
+		FROM auth_failures
+		WHERE key = $1`
+
+	failure := AuthFailure{Key: key}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key).Scan(&failure.Failures, &failure.BlockedUntil, &failure.Locked)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+// RecordFailure counts a failed attempt for key, and returns the updated record. If
+// the last failure was longer ago than window, the count starts again from one.
+func (m AuthFailureModel) RecordFailure(key string, window time.Duration) (*AuthFailure, error) {
+	query := `
+		INSERT INTO auth_failures (key, failures)
+		VALUES ($1, 1)
+		ON CONFLICT (key) DO UPDATE
+		SET failures = CASE
+				WHEN auth_failures.last_failure_at < NOW() - $2 * interval '1 second' THEN 1
+				ELSE auth_failures.failures + 1
+			END,
+			last_failure_at = NOW()
+		RETURNING failures, blocked_until, locked`
+
+	failure := AuthFailure{Key: key}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key, window.Seconds()).Scan(&failure.Failures, &failure.BlockedUntil, &failure.Locked)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failure, nil
+}
+
+// Block stops any further attempts for key until the given time. A locked block is a
+// lockout.
+func (m AuthFailureModel) Block(key string, until time.Time, locked bool) error {
+	query := `
+		UPDATE auth_failures
+		SET blocked_until = $2, locked = $3
+		WHERE key = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, key, until, locked)
+	return err
+}
+
+// Reset forgets the failures recorded for the given keys.
+func (m AuthFailureModel) Reset(keys ...string) error {
+	query := `
+		DELETE FROM auth_failures
+		WHERE key = ANY($1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(keys))
+	return err
+}
+
+// DeleteExpired removes records which are no longer blocked, and whose last failure was
+// longer ago than window (so their count would start again from one anyway).
+func (m AuthFailureModel) DeleteExpired(window time.Duration) (int64, error) {
+	query := `
+		DELETE FROM auth_failures
+		WHERE last_failure_at < NOW() - $1 * interval '1 second'
+		AND (blocked_until IS NULL OR blocked_until < NOW())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
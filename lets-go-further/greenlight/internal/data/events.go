@@ -0,0 +1,103 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The movie_events channel is notified by a trigger on the movies table whenever a movie
+// is created, updated, deleted or restored.
+const movieEventsChannel = "movie_events"
+
+// ErrListenerClosed is returned by MovieEventListener.Next() once Close() has been called.
+var ErrListenerClosed = errors.New("listener closed")
+
+// A MovieEvent is a single change to a movie, as broadcast by Postgres. The ID comes
+// from a database sequence, so it is the same on every API instance.
+type MovieEvent struct {
+	ID    int64  `json:"id"`
+	Event string `json:"event"`
+	Movie *Movie `json:"movie"`
+}
+
+// MovieEventListener receives movie events over a dedicated Postgres connection, using
+// LISTEN/NOTIFY. Unlike the models it needs the DSN rather than the connection pool, as
+// the listening connection has to stay open (and be reopened if it drops).
+type MovieEventListener struct {
+	listener *pq.Listener
+}
+
+// NewMovieEventListener starts listening for movie events. Problems with the listening
+// connection are reported to logError, and the listener keeps trying to reconnect.
+func NewMovieEventListener(dsn string, logError func(error)) (*MovieEventListener, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logError(err)
+		}
+	})
+
+	err := listener.Listen(movieEventsChannel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &MovieEventListener{listener: listener}, nil
+}
+
+// Next blocks until the next movie event arrives. It returns a nil event (and nil
+// error) after the connection has been re-established, as any events sent while it was
+// down have been lost.
+func (l *MovieEventListener) Next() (*MovieEvent, error) {
+	for {
+		select {
+		case n, ok := <-l.listener.Notify:
+			if !ok {
+				return nil, ErrListenerClosed
+			}
+
+			if n == nil {
+				return nil, nil
+			}
+
+			var event MovieEvent
+
+			err := json.Unmarshal([]byte(n.Extra), &event)
+			if err != nil {
+				return nil, err
+			}
+
+			// The movie's created_at field is left out of its JSON encoding, so it
+			// has to be decoded separately.
+			var created struct {
+				Movie struct {
+					CreatedAt time.Time `json:"created_at"`
+				} `json:"movie"`
+			}
+
+			err = json.Unmarshal([]byte(n.Extra), &created)
+			if err != nil {
+				return nil, err
+			}
+
+			if event.Movie != nil {
+				event.Movie.CreatedAt = created.Movie.CreatedAt
+			}
+
+			return &event, nil
+
+		// If we haven't heard anything for a while, ping the server to check that the
+		// connection is still alive. A dead connection is noticed and reopened by pq.
+		case <-time.After(90 * time.Second):
+			go l.listener.Ping()
+		}
+	}
+}
+
+// Close stops listening and closes the connection.
+func (l *MovieEventListener) Close() error {
+	return l.listener.Close()
+}
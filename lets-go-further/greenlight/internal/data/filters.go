@@ -15,7 +15,9 @@ type Filters struct {
 	SortSafelist []string
 }
 
-// Define a new Metadata struct for holding the pagination metadata.
+// Define a new Metadata struct for holding the pagination metadata. The v1 API doesn't
+// send it as it is, as v1 clients depend on the page size always being 1 and the first
+// page being left out; see metadataV1() in cmd/api.
 type Metadata struct {
 	CurrentPage  int `json:"current_page,omitempty"`
 	PageSize     int `json:"page_size,omitempty"`
@@ -69,17 +71,19 @@ func (f Filters) offset() int {
 // The calculateMetadata() function calculates the appropriate pagination metadata
 // values given the total number of records, current page, and page size values. Note
 // that the last page value is calculated using the math.Ceil() function, which rounds
-// up a float to the nearest integer.
+// up a float to the nearest integer. When there are no records there is still a single
+// (empty) page, so the last page is 1.
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
-	if totalRecords == 0 {
-		// Note that we return an empty Metadata struct if thereare no records.
-		return Metadata{}
+	lastPage := 1
+	if totalRecords > 0 {
+		lastPage = int(math.Ceil(float64(totalRecords) / float64(pageSize)))
 	}
 
 	return Metadata{
 		CurrentPage:  page,
-		PageSize:     1,
-		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     lastPage,
 		TotalRecords: totalRecords,
 	}
 }
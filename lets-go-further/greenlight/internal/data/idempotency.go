@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrIdempotencyKeyInUse is returned by Reserve() when a key has already been used, and
+// hasn't expired.
+var ErrIdempotencyKeyInUse = errors.New("idempotency key in use")
+
+// An IdempotencyKey records a request sent with an Idempotency-Key header, and the
+// response to it. The request hash identifies the request, so that the key being reused
+// for a different request can be spotted. The Status is 0 while the first request with
+// the key is still running.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	CreatedAt   time.Time
+	RequestHash []byte
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+}
+
+// Define the IdempotencyKeyModel type.
+type IdempotencyKeyModel struct {
+	DB DBTX
+}
+
+// Reserve records a key for a new request. If the user has already used the key within
+// the last ttl, the existing record is left alone and ErrIdempotencyKeyInUse is returned,
+// so that the caller can look at it with Get(). An expired key is replaced.
+func (m IdempotencyKeyModel) Reserve(key *IdempotencyKey, ttl time.Duration) error {
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, request_hash)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET created_at = NOW(), request_hash = EXCLUDED.request_hash, status = NULL, header = NULL, body = NULL
+		WHERE idempotency_keys.created_at < NOW() - $4 * interval '1 second'
+		RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, key.UserID, key.Key, key.RequestHash, ttl.Seconds()).Scan(&key.CreatedAt)
+	if err != nil {
+		switch {
+		// The conflicting row wasn't updated because it hasn't expired, so nothing
+		// was returned.
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrIdempotencyKeyInUse
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns the record for a key which the user has used within the last ttl.
+func (m IdempotencyKeyModel) Get(userID int64, key string, ttl time.Duration) (*IdempotencyKey, error) {
+	query := `
+		SELECT created_at, request_hash, status, header, body
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2
+		AND created_at >= NOW() - $3 * interval '1 second'`
+
+	record := IdempotencyKey{UserID: userID, Key: key}
+
+	var status sql.NullInt32
+	var header []byte
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, key, ttl.Seconds()).Scan(
+		&record.CreatedAt,
+		&record.RequestHash,
+		&status,
+		&header,
+		&record.Body,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	record.Status = int(status.Int32)
+
+	if header != nil {
+		err = json.Unmarshal(header, &record.Header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &record, nil
+}
+
+// Complete stores the response to the request which reserved a key, so that it can be
+// sent again to any retries.
+func (m IdempotencyKeyModel) Complete(key *IdempotencyKey) error {
+	header, err := json.Marshal(key.Header)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status = $1, header = $2, body = $3
+		WHERE user_id = $4 AND key = $5`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, key.Status, header, key.Body, key.UserID, key.Key)
+	return err
+}
+
+// Release removes a key whose request failed without a response worth keeping, so that
+// the client can retry the request with the same key.
+func (m IdempotencyKeyModel) Release(userID int64, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+// DeleteExpired removes keys which were first used longer ago than ttl.
+func (m IdempotencyKeyModel) DeleteExpired(ttl time.Duration) (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE created_at < NOW() - $1 * interval '1 second'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, ttl.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Define constants for the status of a job. A pending job is waiting to be run (or
+// retried), while a dead job has failed too many times and won't be run again.
+const (
+	JobPending   = "pending"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// Define constants for the kinds of job that the workers know how to run.
+const (
+	JobSendEmail = "send_email"
+)
+
+// A Job is a unit of background work, such as sending an email. Jobs are stored in the
+// database so that they survive a restart, and can be enqueued in the same transaction
+// as the change which caused them. The payload can hold secrets, such as the plaintext
+// tokens in activation and email change emails, so it is cleared once the job has
+// succeeded (the tokens table itself only ever stores hashes). A dead job keeps its
+// payload, so that it can be inspected and set back to pending to run again. The tokens
+// in it expire anyway.
+type Job struct {
+	ID        int64
+	CreatedAt time.Time
+	Kind      string
+	Payload   json.RawMessage
+	Attempts  int
+}
+
+// EmailJob is the payload of a JobSendEmail job. The Locale picks which translation of
+// the template is used.
+type EmailJob struct {
+	Recipient string                 `json:"recipient"`
+	Locale    string                 `json:"locale,omitempty"`
+	Template  string                 `json:"template"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// JobStats holds the number of jobs in each state, for the metrics.
+type JobStats struct {
+	Pending int `json:"pending"`
+	Due     int `json:"due"`
+	Dead    int `json:"dead"`
+}
+
+// Define the JobModel type.
+type JobModel struct {
+	DB DBTX
+}
+
+// Enqueue adds a job to the queue, to be run as soon as a worker is free. The payload is
+// encoded as JSON.
+func (m JobModel) Enqueue(kind string, payload interface{}) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO jobs (kind, payload)
+		VALUES ($1, $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The payload is passed as a string, because pq would send a []byte as bytea.
+	_, err = m.DB.ExecContext(ctx, query, kind, string(js))
+	return err
+}
+
+// Claim claims up to limit pending jobs which are due to run. Claiming a job counts as
+// an attempt and pushes its run_at time back by the lease duration, so that a job which
+// was claimed by a worker that died is picked up again once the lease runs out. FOR
+// UPDATE SKIP LOCKED means several workers (and API instances) can claim jobs at once
+// without ever running the same one twice.
+func (m JobModel) Claim(limit int, lease time.Duration) ([]*Job, error) {
+	query := `
+		UPDATE jobs
+		SET attempts = attempts + 1, run_at = NOW() + $2 * interval '1 second'
+		WHERE id IN (
+			SELECT id
+			FROM jobs
+			WHERE status = 'pending' AND run_at <= NOW()
+			ORDER BY run_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, created_at, kind, payload, attempts`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+
+	for rows.Next() {
+		var job Job
+		var payload []byte
+
+		err := rows.Scan(&job.ID, &job.CreatedAt, &job.Kind, &payload, &job.Attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		job.Payload = payload
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// MarkSucceeded records that a job has run successfully, and clears its payload.
+func (m JobModel) MarkSucceeded(id int64) error {
+	query := `
+		UPDATE jobs
+		SET status = 'succeeded', finished_at = NOW(), last_error = NULL, payload = '{}'
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// MarkFailed records a failed attempt at a job. If retryAt is nil the job has run out
+// of attempts and is moved to the dead state, otherwise it stays pending until retryAt.
+func (m JobModel) MarkFailed(id int64, lastError string, retryAt *time.Time) error {
+	query := `
+		UPDATE jobs
+		SET status = $2, run_at = COALESCE($3, run_at), last_error = $4,
+			finished_at = CASE WHEN $2 = 'dead' THEN NOW() END
+		WHERE id = $1`
+
+	status := JobPending
+	if retryAt == nil {
+		status = JobDead
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, status, retryAt, lastError)
+	return err
+}
+
+// Stats returns the number of pending jobs (and how many of those are due to run now),
+// along with the number of dead jobs.
+func (m JobModel) Stats() (JobStats, error) {
+	query := `
+		SELECT
+			count(*) FILTER (WHERE status = 'pending'),
+			count(*) FILTER (WHERE status = 'pending' AND run_at <= NOW()),
+			count(*) FILTER (WHERE status = 'dead')
+		FROM jobs
+		WHERE status <> 'succeeded'`
+
+	var stats JobStats
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&stats.Pending, &stats.Due, &stats.Dead)
+	return stats, err
+}
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 )
@@ -12,21 +13,96 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// DBTX is the set of methods shared by *sql.DB and *sql.Tx. Our models hold a DBTX
+// rather than a *sql.DB, so that the same model code can run either directly against
+// the connection pool or inside a transaction started with Models.Transaction().
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // Create a Models struct which wraps the MovieModel
 type Models struct {
-	Movies      MovieModel
-	Users       UserModel
-	Tokens      TokenModel
-	Permissions PermissionModel
+	Movies            MovieModel
+	MovieRevisions    MovieRevisionModel
+	Users             UserModel
+	Tokens            TokenModel
+	Permissions       PermissionModel
+	Webhooks          WebhookModel
+	WebhookDeliveries WebhookDeliveryModel
+	Jobs              JobModel
+	AuthFailures      AuthFailureModel
+	TOTP              TOTPModel
+	IdempotencyKeys   IdempotencyKeyModel
+
+	// The connection pool is kept so that Transaction() can begin a new transaction.
+	// It is nil for a Models value which is already scoped to a transaction.
+	db *sql.DB
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing
 // the initialized MovieModel.
 func NewModels(db *sql.DB) Models {
+	m := newModels(db)
+	m.db = db
+	return m
+}
+
+func newModels(db DBTX) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Users:       UserModel{DB: db},  // Initialize a new UserModel instance
-		Tokens:      TokenModel{DB: db}, // Initialize a new TokenModel instance.
-		Permissions: PermissionModel{DB: db},
+		Movies:            MovieModel{DB: db},
+		MovieRevisions:    MovieRevisionModel{DB: db},
+		Users:             UserModel{DB: db},  // Initialize a new UserModel instance
+		Tokens:            TokenModel{DB: db}, // Initialize a new TokenModel instance.
+		Permissions:       PermissionModel{DB: db},
+		Webhooks:          WebhookModel{DB: db},
+		WebhookDeliveries: WebhookDeliveryModel{DB: db},
+		Jobs:              JobModel{DB: db},
+		AuthFailures:      AuthFailureModel{DB: db},
+		TOTP:              TOTPModel{DB: db},
+		IdempotencyKeys:   IdempotencyKeyModel{DB: db},
+	}
+}
+
+// Transaction runs fn with a copy of the models which all share a single database
+// transaction. If fn returns an error (or panics) the transaction is rolled back,
+// otherwise it is committed. Calling Transaction() on models which are already scoped
+// to a transaction simply runs fn as part of that outer transaction.
+func (m Models) Transaction(fn func(tx Models) error) error {
+	if m.db == nil {
+		return fn(m)
+	}
+
+	return inTx(m.db, func(tx DBTX) error {
+		return fn(newModels(tx))
+	})
+}
+
+// inTx runs fn inside a transaction. If db is the connection pool then a new transaction
+// is started, and committed or rolled back once fn returns. If db is already a
+// transaction, fn simply becomes part of it.
+func inTx(db DBTX, fn func(tx DBTX) error) error {
+	pool, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+
+	// We use the background context for the transaction itself, rather than one with
+	// a timeout, as each query inside the transaction sets its own deadline.
+	tx, err := pool.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
 	}
+
+	// Make sure the transaction is rolled back if fn panics. Rollback() is a no-op
+	// once the transaction has been committed.
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
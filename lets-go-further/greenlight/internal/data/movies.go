@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com.go-learning.greenlight/internal/validator"
@@ -18,42 +19,38 @@ import (
 type Movie struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"-"` // Omit this from the JSON output
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"` // Omit if the field is empty (false, 0, "",empty array/map, nil)
+	Title     string    `json:"title" validate:"required,max=500"`
+	Year      int32     `json:"year,omitempty" validate:"required,min=1888,msg=must be greater than 1888"` // Omit if the field is empty (false, 0, "",empty array/map, nil)
 	// Use the Runtime type instead of int32. Note that the omitempty directive will
 	// still work on this: if the Runtime field has the underlying value 0, then it will
 	// be considered empty and omitted -- and the MarshalJSON() method we just made
 	// won't be called at all
-	Runtime Runtime  `json:"runtime,omitempty,string"` // The string directive will force the field to be converted to string in the JSON output
-	Genres  []string `json:"genres,omitempty"`
+	Runtime Runtime  `json:"runtime,omitempty,string" validate:"required,min=1,msg=must be a positive integer"` // The string directive will force the field to be converted to string in the JSON output
+	Genres  []string `json:"genres,omitempty" validate:"required,min=1,msg=must contain at least 1 genre,max=5,msg=must not contain more than 5 genres,unique,dive,required,max=50"`
 	Version int32    `json:"version"`
+	// DeletedAt is set when a movie has been moved to the trash. It is a pointer so that
+	// it can be NULL in the database, and omitted from the JSON output of live movies.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
+// Every change to a movie is broadcast with pg_notify(), whose payload is limited to
+// 8000 bytes, so the limits on the title and genres also keep the whole movie inside it.
 func ValidateMovie(v *validator.Validator, movie *Movie) {
-	v.Check(movie.Title != "", "title", "must be provided")
-	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
-
-	v.Check(movie.Year != 0, "year", "must be provided")
-	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
+	// The fixed rules are declared in the validate tags on the Movie struct, so only
+	// the year, which depends on the current date, is checked by hand.
+	v.CheckStruct(movie)
 	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
-
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
-
-	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
-	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
 // Define MovieModel struct type which wraps a sql.DB connection pool.
 type MovieModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // The Insert() method accepts a pointer to a movie struct, which should contain the
-// data for the new record
-func (m MovieModel) Insert(movie *Movie) error {
+// data for the new record, and the ID of the user creating it (recorded in the movie's
+// revision history)
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
 	// Define the SQL query for inserting a new record in the movies table and returning
 	// the system-generated data
 	query := `
@@ -73,12 +70,96 @@ func (m MovieModel) Insert(movie *Movie) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Use the QueryRow() method to execute the SQL query on our connection pool,
-	// passing in the args slice as a variadic parameter and scanning the system
-	// generated id, created_at and version valeus into the movie struct
-	// Calling Scan here using the movie pointer, we're mutating the movie struct that
-	// we got as an argument
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	// The movie, its first revision and any webhook deliveries are written in the same
+	// transaction, so that neither the revision history nor the webhooks can miss a
+	// change (or hear about one which was rolled back).
+	return inTx(m.DB, func(tx DBTX) error {
+		// Use the QueryRow() method to execute the SQL query on our connection pool,
+		// passing in the args slice as a variadic parameter and scanning the system
+		// generated id, created_at and version valeus into the movie struct
+		// Calling Scan here using the movie pointer, we're mutating the movie struct that
+		// we got as an argument
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+
+		err = insertRevisions(ctx, tx, RevisionInsert, userID, movie)
+		if err != nil {
+			return err
+		}
+
+		return enqueueWebhookDeliveries(ctx, tx, EventMovieCreated, movie)
+	})
+}
+
+// MaxInsertBatch is the largest batch of movies that InsertBatch() should be given. Each
+// movie takes 4 placeholder parameters in the movies INSERT (and 3 in the revisions
+// INSERT), and Postgres allows at most 65535 parameters in a single statement, so batches
+// of more than about 16,000 movies would always fail.
+const MaxInsertBatch = 10000
+
+// InsertBatch inserts several movies with a single multi-row INSERT statement, and
+// updates each movie struct with its system-generated id, created_at and version.
+func (m MovieModel) InsertBatch(movies []*Movie, userID int64) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	// Build one "($1, $2, $3, $4)" group of placeholders per movie. Postgres returns the
+	// RETURNING rows of a multi-row VALUES insert in the same order as the VALUES list,
+	// so we can scan them back into the movies slice by position.
+	values := make([]string, 0, len(movies))
+	args := make([]interface{}, 0, len(movies)*4)
+
+	for i, movie := range movies {
+		n := i * 4
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4))
+		args = append(args, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+	}
+
+	query := `
+		INSERT INTO movies (title, year, runtime, genres)
+		VALUES ` + strings.Join(values, ", ") + `
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return inTx(m.DB, func(tx DBTX) error {
+		err := scanInsertedMovies(ctx, tx, query, args, movies)
+		if err != nil {
+			return err
+		}
+
+		err = insertRevisions(ctx, tx, RevisionInsert, userID, movies...)
+		if err != nil {
+			return err
+		}
+
+		return enqueueWebhookDeliveries(ctx, tx, EventMovieCreated, movies...)
+	})
+}
+
+// scanInsertedMovies runs a multi-row INSERT and scans the returned id, created_at and
+// version values back into the movies, in order.
+func scanInsertedMovies(ctx context.Context, db DBTX, query string, args []interface{}, movies []*Movie) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	i := 0
+	for rows.Next() {
+		err := rows.Scan(&movies[i].ID, &movies[i].CreatedAt, &movies[i].Version)
+		if err != nil {
+			return err
+		}
+		i++
+	}
+
+	return rows.Err()
 }
 
 func (m MovieModel) Get(id int64) (*Movie, error) {
@@ -94,7 +175,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	query := `
 		SELECT id, created_at, title, year, runtime, genres, version
 		FROM movies
-		WHERE id=$1`
+		WHERE id=$1 AND deleted_at IS NULL`
 
 	// Declare a Movie struct to hold the data returned by the query.
 	var movie Movie
@@ -144,13 +225,15 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
+// Update saves the changes to a movie, so long as its version hasn't changed since it was
+// read, and records the new state in the movie's revision history.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
 	// Declare the SQL query for updating the record and returning the new version
 	// number
 	query := `
 		UPDATE movies
 		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
 		RETURNING version`
 
 	// Create an args slice containing the valeus for the placeholder parameters.
@@ -171,55 +254,165 @@ func (m MovieModel) Update(movie *Movie) error {
 	// variadic parameter and scanning the new version value into the movie struct.
 	// return m.DB.QueryRow(query, args...).Scan(&movie.Version)
 
-	// Execute the SQL query. If no matching row could be found, we know the movie
-	// version has changed (or the record has been deleted) and we return our custom
-	// ErrEditConflict error.
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	return inTx(m.DB, func(tx DBTX) error {
+		// Execute the SQL query. If no matching row could be found, we know the movie
+		// version has changed (or the record has been deleted) and we return our custom
+		// ErrEditConflict error.
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		err = insertRevisions(ctx, tx, RevisionUpdate, userID, movie)
+		if err != nil {
+			return err
+		}
+
+		return enqueueWebhookDeliveries(ctx, tx, EventMovieUpdated, movie)
+	})
+}
+
+// Delete moves a movie to the trash by setting its deleted_at timestamp. Trashed movies
+// are hidden from Get(), GetAll() and Export(), and can be brought back with Restore()
+// until they are permanently removed by Purge() or PurgeTrashed().
+func (m MovieModel) Delete(id int64, userID int64) error {
+	// Return an ErrRecordNotFound error if the movie ID is less than 1.
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	// Construct the SQL query to soft delete the record. Bumping the version means that
+	// any update based on a copy read before the delete will fail with an edit conflict.
+	// The deleted movie is returned so that it can be recorded as a revision.
+	query := `
+		UPDATE movies
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, created_at, title, year, runtime, genres, version, deleted_at`
+
+	// Create a context with a 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return inTx(m.DB, func(tx DBTX) error {
+		movie, err := scanMovieWithDeletedAt(tx.QueryRowContext(ctx, query, id))
+
+		// If no rows were returned, we know that the movies table didn't contain a live
+		// record with the provided ID at the moment we tried to delete it. In that case,
+		// we return an ErrRecordNotFound error
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		err = insertRevisions(ctx, tx, RevisionDelete, userID, movie)
+		if err != nil {
+			return err
+		}
+
+		return enqueueWebhookDeliveries(ctx, tx, EventMovieDeleted, movie)
+	})
+}
+
+// Restore takes a movie back out of the trash, returning the restored record.
+func (m MovieModel) Restore(id int64, userID int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE movies
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, created_at, title, year, runtime, genres, version, deleted_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var movie *Movie
+
+	err := inTx(m.DB, func(tx DBTX) error {
+		var err error
+
+		movie, err = scanMovieWithDeletedAt(tx.QueryRowContext(ctx, query, id))
+		if err != nil {
+			return err
+		}
+
+		err = insertRevisions(ctx, tx, RevisionRestore, userID, movie)
+		if err != nil {
+			return err
+		}
+
+		return enqueueWebhookDeliveries(ctx, tx, EventMovieRestored, movie)
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return movie, nil
 }
 
-func (m MovieModel) Delete(id int64) error {
-	// Return an ErrRecordNotFound error if the movie ID is less than 1.
+// scanMovieWithDeletedAt scans a single movie row, including its deleted_at column.
+func scanMovieWithDeletedAt(row *sql.Row) (*Movie, error) {
+	var movie Movie
+
+	err := row.Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.DeletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &movie, nil
+}
+
+// Purge permanently deletes a movie which is in the trash. Live movies must be moved to
+// the trash with Delete() first, so that a single mistaken call can't destroy them. The
+// movie's revision history is kept, and a purge revision is added to the end of it.
+func (m MovieModel) Purge(id int64, userID int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
-	query := `
-		DELETE FROM movies
-		WHERE id = $1`
+	query := purgeQuery("id = $1")
 
-	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Execute the SQL query using the Exec() method, passing in the id variable as
-	// the value for the placeholder parameter. The Exec() method retunrs a sql.Result
-	// object which contains information about the number of rows the query affected
-	result, err := m.DB.ExecContext(ctx, query, id)
+	actor := sql.NullInt64{Int64: userID, Valid: userID > 0}
+
+	result, err := m.DB.ExecContext(ctx, query, id, actor)
 	if err != nil {
 		return err
 	}
 
-	// Call the RowsAffected() method on the sql.Result object to get the number of rows
-	// affected by the query
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
-	// If no rows were affected, we know that the movies table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case, we
-	// return an ErrRecordNotFound error
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
@@ -227,6 +420,176 @@ func (m MovieModel) Delete(id int64) error {
 	return nil
 }
 
+// PurgeTrashed permanently deletes every movie which was moved to the trash before the
+// cutoff time, and returns the number of movies deleted. As with Purge(), each movie gets
+// a purge revision, which isn't attributed to a user.
+func (m MovieModel) PurgeTrashed(cutoff time.Time) (int64, error) {
+	query := purgeQuery("deleted_at < $1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, cutoff, sql.NullInt64{})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// purgeQuery returns the statement which deletes the trashed movies matching where, and
+// records a purge revision for each of them in the same statement. The acting user's ID
+// is the $2 parameter. The snapshot is built in the same way as by the migration which
+// created the movie_revisions table, and the number of rows affected is the number of
+// movies purged.
+func purgeQuery(where string) string {
+	return `
+		WITH purged AS (
+			DELETE FROM movies
+			WHERE ` + where + ` AND deleted_at IS NOT NULL
+			RETURNING id, title, year, runtime, genres, version
+		)
+		INSERT INTO movie_revisions (movie_id, version, operation, snapshot, user_id)
+		SELECT id, version + 1, '` + RevisionPurge + `', json_build_object(
+			'title', title,
+			'year', year,
+			'runtime', runtime || ' mins',
+			'genres', genres,
+			'deleted', true
+		), $2
+		FROM purged`
+}
+
+// GetAllTrashed returns a page of the movies which are currently in the trash.
+func (m MovieModel) GetAllTrashed(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, deleted_at
+		FROM movies
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.DeletedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// Export calls fn for every movie matching the title and genres filters, in ID order.
+// Rather than loading all of the rows into memory at once, it declares a server-side
+// cursor and fetches batchSize rows at a time. Postgres cursors only live as long as the
+// transaction they were declared in, so Export must be called on models returned by
+// Models.Transaction(). The ctx parameter is used for every query, so that the export
+// stops as soon as ctx is canceled (for example, when the client disconnects).
+func (m MovieModel) Export(ctx context.Context, title string, genres []string, batchSize int, fn func(*Movie) error) error {
+	query := `
+		DECLARE movies_export NO SCROLL CURSOR FOR
+		SELECT id, created_at, title, year, runtime, genres, version
+		FROM movies
+		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		AND (genres @> $2 OR $2 = '{}')
+		AND deleted_at IS NULL
+		ORDER BY id ASC`
+
+	_, err := m.DB.ExecContext(ctx, query, title, pq.Array(genres))
+	if err != nil {
+		return err
+	}
+
+	// FETCH doesn't accept a placeholder for the row count, so we interpolate it. This
+	// is safe because batchSize is an int rather than user-provided text.
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM movies_export", batchSize)
+
+	for {
+		fetched, err := m.fetchExportBatch(ctx, fetch, fn)
+		if err != nil {
+			return err
+		}
+
+		// A short batch means that the cursor has reached the end of the result set.
+		if fetched < batchSize {
+			break
+		}
+	}
+
+	_, err = m.DB.ExecContext(ctx, "CLOSE movies_export")
+	return err
+}
+
+// fetchExportBatch runs a single FETCH against the export cursor, calling fn for each
+// movie, and returns the number of rows fetched.
+func (m MovieModel) fetchExportBatch(ctx context.Context, fetch string, fn func(*Movie) error) (int, error) {
+	rows, err := m.DB.QueryContext(ctx, fetch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	fetched := 0
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return fetched, err
+		}
+
+		fetched++
+
+		err = fn(&movie)
+		if err != nil {
+			return fetched, err
+		}
+	}
+
+	return fetched, rows.Err()
+}
+
 // Create a new GetAll() method which returns a slice of movies.
 func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
 	// Construct the SQL query to retrieve all movie records
@@ -238,6 +601,7 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 		FROM movies
 		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
 		AND (genres @> $2 OR $2 = '{}')
+		AND deleted_at IS NULL
 		ORDER BY %s %s, id ASC
 		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
 
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+// The /v1 endpoints promise the same error messages as before the rules moved into
+// struct tags, so these are pinned word for word.
+func TestValidateMovie(t *testing.T) {
+	validMovie := func() *Movie {
+		return &Movie{Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama", "romance"}}
+	}
+
+	tests := []struct {
+		name   string
+		change func(m *Movie)
+		field  string
+		want   string
+	}{
+		{"Valid", func(m *Movie) {}, "", ""},
+		{"No title", func(m *Movie) { m.Title = "" }, "title", "must be provided"},
+		{"Long title", func(m *Movie) { m.Title = string(make([]byte, 501)) }, "title", "must not be more than 500 bytes long"},
+		{"No year", func(m *Movie) { m.Year = 0 }, "year", "must be provided"},
+		{"Early year", func(m *Movie) { m.Year = 1887 }, "year", "must be greater than 1888"},
+		{"Future year", func(m *Movie) { m.Year = 3000 }, "year", "must not be in the future"},
+		{"No runtime", func(m *Movie) { m.Runtime = 0 }, "runtime", "must be provided"},
+		{"Negative runtime", func(m *Movie) { m.Runtime = -1 }, "runtime", "must be a positive integer"},
+		{"No genres", func(m *Movie) { m.Genres = nil }, "genres", "must be provided"},
+		{"Empty genres", func(m *Movie) { m.Genres = []string{} }, "genres", "must contain at least 1 genre"},
+		{"Too many genres", func(m *Movie) { m.Genres = []string{"a", "b", "c", "d", "e", "f"} }, "genres", "must not contain more than 5 genres"},
+		{"Empty genre", func(m *Movie) { m.Genres = []string{"drama", ""} }, "genres[1]", "must be provided"},
+		{"Long genre", func(m *Movie) { m.Genres = []string{"drama", strings.Repeat("x", 51)} }, "genres[1]", "must not be more than 50 bytes long"},
+		{"Duplicate genres", func(m *Movie) { m.Genres = []string{"drama", "drama"} }, "genres", "must not contain duplicate values"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validMovie()
+			tt.change(m)
+
+			v := validator.New()
+			ValidateMovie(v, m)
+
+			if tt.field == "" {
+				assert.Equal(t, v.Valid(), true)
+				return
+			}
+
+			assert.Equal(t, len(v.Errors), 1)
+			assert.Equal(t, v.Errors[tt.field], tt.want)
+		})
+	}
+}
+
+// The trigger on the movies table sends every changed movie with pg_notify(), which
+// fails if the payload is over 8000 bytes. Check that the largest valid movie fits, with
+// every byte of the title and genres needing a \u escape in the JSON.
+func TestValidateMovieNotifyPayload(t *testing.T) {
+	genres := make([]string, 5)
+	for i := range genres {
+		genres[i] = strings.Repeat(string(rune(i+1)), 50)
+	}
+
+	movie := &Movie{Title: strings.Repeat("\x00", 500), Year: 2000, Runtime: 1 << 30, Genres: genres}
+
+	v := validator.New()
+	ValidateMovie(v, movie)
+	assert.Equal(t, v.Valid(), true)
+
+	now := time.Now()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":    int64(1) << 62,
+		"event": "movie.updated",
+		"movie": map[string]interface{}{
+			"id":         int64(1) << 62,
+			"created_at": now,
+			"title":      movie.Title,
+			"year":       movie.Year,
+			"runtime":    movie.Runtime,
+			"genres":     movie.Genres,
+			"version":    int32(1) << 30,
+			"deleted_at": now,
+		},
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(payload) < 8000, true)
+}
@@ -2,7 +2,6 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"time"
 
 	"github.com/lib/pq"
@@ -27,7 +26,7 @@ func (p Permissions) Include(code string) bool {
 
 // Define the PermissionModel type
 type PermissionModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // The GetAllForUser() method returns all permission codes for a specific user in a
@@ -70,11 +69,12 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
 // Add the provided permission codes for a sepcific user. Notice that we're using a
 // variadic parameter for the codes so that we can assign multiple permissions in a
-// single call
+// single call. Codes which the user already has are ignored
 func (m PermissionModel) AddPermissionsForUser(userID int64, codes ...string) error {
 	query := `
-		INSERT INTO user_permissions
-		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+		INSERT INTO users_permissions
+		SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+		ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -82,3 +82,55 @@ func (m PermissionModel) AddPermissionsForUser(userID int64, codes ...string) er
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// Remove the provided permission codes from a specific user. Codes which the user
+// doesn't have are ignored.
+func (m PermissionModel) RemovePermissionsForUser(userID int64, codes ...string) error {
+	query := `
+		DELETE FROM users_permissions
+		USING permissions
+		WHERE users_permissions.permission_id = permissions.id
+		AND users_permissions.user_id = $1
+		AND permissions.code = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// The GetAllCodes() method returns every permission code which can be given to a user.
+func (m PermissionModel) GetAllCodes() ([]string, error) {
+	query := `
+		SELECT code
+		FROM permissions
+		ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []string
+
+	for rows.Next() {
+		var code string
+
+		err := rows.Scan(&code)
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
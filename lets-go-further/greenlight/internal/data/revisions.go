@@ -0,0 +1,234 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Define constants for the operation which produced a movie revision.
+const (
+	RevisionInsert  = "insert"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore" // A movie being taken back out of the trash
+	RevisionPurge   = "purge"   // A movie being permanently deleted from the trash
+)
+
+// MovieSnapshot holds the editable fields of a movie as they were at a given version. It
+// is stored as JSON in the snapshot column of the movie_revisions table.
+type MovieSnapshot struct {
+	Title   string   `json:"title"`
+	Year    int32    `json:"year"`
+	Runtime Runtime  `json:"runtime"`
+	Genres  []string `json:"genres"`
+	Deleted bool     `json:"deleted"`
+}
+
+// ApplyTo copies the snapshot's field values onto movie, leaving its ID and version
+// alone so that the result can be saved with MovieModel.Update().
+func (s MovieSnapshot) ApplyTo(movie *Movie) {
+	movie.Title = s.Title
+	movie.Year = s.Year
+	movie.Runtime = s.Runtime
+	movie.Genres = s.Genres
+}
+
+func snapshotOf(movie *Movie) MovieSnapshot {
+	return MovieSnapshot{
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+		Deleted: movie.DeletedAt != nil,
+	}
+}
+
+// FieldChange describes how a single field differs between two revisions.
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// MovieRevision is a single entry in a movie's history. The Version matches the movie's
+// version number straight after the change, and UserID is nil if the change wasn't made
+// by a user (or the user has since been deleted).
+type MovieRevision struct {
+	MovieID   int64                  `json:"movie_id"`
+	Version   int32                  `json:"version"`
+	Operation string                 `json:"operation"`
+	UserID    *int64                 `json:"user_id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Snapshot  MovieSnapshot          `json:"snapshot"`
+	Changes   map[string]FieldChange `json:"changes"`
+}
+
+// diff returns the fields which changed between prev and s. A nil prev is treated as
+// an empty movie, so every field shows up as changed for an insert.
+func (s MovieSnapshot) diff(prev *MovieSnapshot) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+
+	if prev == nil {
+		changes["title"] = FieldChange{To: s.Title}
+		changes["year"] = FieldChange{To: s.Year}
+		changes["runtime"] = FieldChange{To: s.Runtime}
+		changes["genres"] = FieldChange{To: s.Genres}
+		return changes
+	}
+
+	if prev.Title != s.Title {
+		changes["title"] = FieldChange{From: prev.Title, To: s.Title}
+	}
+	if prev.Year != s.Year {
+		changes["year"] = FieldChange{From: prev.Year, To: s.Year}
+	}
+	if prev.Runtime != s.Runtime {
+		changes["runtime"] = FieldChange{From: prev.Runtime, To: s.Runtime}
+	}
+	if strings.Join(prev.Genres, "\x00") != strings.Join(s.Genres, "\x00") {
+		changes["genres"] = FieldChange{From: prev.Genres, To: s.Genres}
+	}
+	if prev.Deleted != s.Deleted {
+		changes["deleted"] = FieldChange{From: prev.Deleted, To: s.Deleted}
+	}
+
+	return changes
+}
+
+// insertRevisions records a revision for each of the movies, all with the same operation
+// and acting user. It is called by the MovieModel methods with the same DBTX (and so the
+// same transaction) as the change itself. A userID of 0 is stored as NULL.
+func insertRevisions(ctx context.Context, db DBTX, operation string, userID int64, movies ...*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	actor := sql.NullInt64{Int64: userID, Valid: userID > 0}
+
+	values := make([]string, 0, len(movies))
+	args := make([]interface{}, 0, len(movies)*3+2)
+	args = append(args, operation, actor)
+
+	for i, movie := range movies {
+		snapshot, err := json.Marshal(snapshotOf(movie))
+		if err != nil {
+			return err
+		}
+
+		// The snapshot is passed as a string, because pq would send a []byte as bytea.
+		n := 2 + i*3
+		values = append(values, fmt.Sprintf("($%d, $%d, $1, $%d, $2)", n+1, n+2, n+3))
+		args = append(args, movie.ID, movie.Version, string(snapshot))
+	}
+
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, operation, snapshot, user_id)
+		VALUES ` + strings.Join(values, ", ")
+
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Define the MovieRevisionModel type.
+type MovieRevisionModel struct {
+	DB DBTX
+}
+
+// GetAllForMovie returns every revision of a movie, oldest first, with the Changes field
+// of each revision describing how it differs from the one before.
+func (m MovieRevisionModel) GetAllForMovie(movieID int64) ([]*MovieRevision, error) {
+	query := `
+		SELECT movie_id, version, operation, user_id, created_at, snapshot
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY version ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*MovieRevision{}
+
+	var prev *MovieSnapshot
+
+	for rows.Next() {
+		revision, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		revision.Changes = revision.Snapshot.diff(prev)
+		prev = &revision.Snapshot
+
+		revisions = append(revisions, revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Get returns a single revision of a movie. Its Changes field is left empty.
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	query := `
+		SELECT movie_id, version, operation, user_id, created_at, snapshot
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	revision, err := scanRevision(m.DB.QueryRowContext(ctx, query, movieID, version))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return revision, nil
+}
+
+// scanRevision scans a movie_revisions row from either *sql.Row or *sql.Rows, decoding
+// the JSON snapshot along the way.
+func scanRevision(row interface{ Scan(...interface{}) error }) (*MovieRevision, error) {
+	var revision MovieRevision
+	var userID sql.NullInt64
+	var snapshot []byte
+
+	err := row.Scan(
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Operation,
+		&userID,
+		&revision.CreatedAt,
+		&snapshot,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID.Valid {
+		revision.UserID = &userID.Int64
+	}
+
+	err = json.Unmarshal(snapshot, &revision.Snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
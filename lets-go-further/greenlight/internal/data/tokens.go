@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/base32"
 	"time"
 
@@ -16,8 +15,15 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication" // Include a new authentication scope
+	ScopeEmailChange    = "email-change"   // Confirms a user's new email address
+	ScopeTOTPChallenge  = "totp-challenge" // Exchanged with a TOTP code for an authentication token
 )
 
+// Scopes lists every token scope. A new scope must be added here as well, so that
+// tools which work on all of a user's tokens (such as greenlightctl tokens revoke) know
+// about it.
+var Scopes = []string{ScopeActivation, ScopeAuthentication, ScopeEmailChange, ScopeTOTPChallenge}
+
 // Define a Token struct to hold the data for an individual token. This includes the
 // plaintext and hashed versions of the token, associated user ID, expirty time and
 // scope
@@ -78,7 +84,7 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 
 // Define the TokenModel type
 type TokenModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // The New() method is a shortcut which creates a new Token struct and then inserts the
@@ -119,3 +125,59 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// GetAllForUser() returns the tokens which a specific user holds, across every scope,
+// with the ones which expire soonest first. Only the hashes of the tokens are stored, so
+// the Plaintext field is always empty.
+func (m TokenModel) GetAllForUser(userID int64) ([]*Token, error) {
+	query := `
+		SELECT hash, user_id, expiry, scope
+		FROM tokens
+		WHERE user_id = $1
+		ORDER BY expiry, scope`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		var token Token
+
+		err := rows.Scan(&token.Hash, &token.UserID, &token.Expiry, &token.Scope)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// DeleteExpired() deletes every token which has expired, and returns how many were
+// deleted.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM tokens
+		WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
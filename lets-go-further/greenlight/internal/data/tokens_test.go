@@ -0,0 +1,47 @@
+package data
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+// Check that every Scope constant in tokens.go is listed in Scopes, so that a new scope
+// can't be left out by mistake.
+func TestScopes(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "tokens.go", nil, 0)
+	assert.NilError(t, err)
+
+	found := 0
+
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+
+		for _, spec := range gen.Specs {
+			for i, name := range spec.(*ast.ValueSpec).Names {
+				if !strings.HasPrefix(name.Name, "Scope") {
+					continue
+				}
+
+				value, err := strconv.Unquote(spec.(*ast.ValueSpec).Values[i].(*ast.BasicLit).Value)
+				assert.NilError(t, err)
+
+				t.Run(name.Name, func(t *testing.T) {
+					assert.Equal(t, validator.In(value, Scopes...), true)
+				})
+				found++
+			}
+		}
+	}
+
+	assert.Equal(t, found, len(Scopes))
+}
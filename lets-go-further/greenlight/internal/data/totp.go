@@ -0,0 +1,239 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TOTP holds a user's two-factor authentication secret. EnabledAt is nil while the user
+// is still enrolling, so two-factor authentication is only on once it has been set.
+type TOTP struct {
+	UserID       int64
+	CreatedAt    time.Time
+	Secret       string
+	EnabledAt    *time.Time
+	LastUsedStep *int64
+}
+
+// Enabled reports whether the user has confirmed their secret.
+func (t *TOTP) Enabled() bool {
+	return t.EnabledAt != nil
+}
+
+// Define the TOTPModel type.
+type TOTPModel struct {
+	DB DBTX
+}
+
+// Get returns the TOTP record for a user, or ErrRecordNotFound if they have never
+// started enrolling.
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+		SELECT user_id, created_at, secret, enabled_at, last_used_step
+		FROM users_totp
+		WHERE user_id = $1`
+
+	var t TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&t.UserID, &t.CreatedAt, &t.Secret, &t.EnabledAt, &t.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+// Enrol stores a new, unconfirmed secret for a user, replacing any earlier unconfirmed
+// one. It returns ErrEditConflict if the user already has two-factor authentication
+// turned on.
+func (m TOTPModel) Enrol(userID int64, secret string) error {
+	query := `
+		INSERT INTO users_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = NULL
+		WHERE users_totp.enabled_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Enable turns two-factor authentication on for a user, recording the step of the code
+// they confirmed it with so that the same code can't be used to sign in.
+func (m TOTPModel) Enable(userID int64, step int64) error {
+	query := `
+		UPDATE users_totp
+		SET enabled_at = NOW(), last_used_step = $2
+		WHERE user_id = $1 AND enabled_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// UseStep records that a code from the given time step has been used. It returns false
+// if a code from this step (or a later one) has already been used, which stops a code
+// that has been seen by someone else from being replayed.
+func (m TOTPModel) UseStep(userID int64, step int64) (bool, error) {
+	query := `
+		UPDATE users_totp
+		SET last_used_step = $2
+		WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// Delete turns two-factor authentication off for a user, along with their recovery codes.
+func (m TOTPModel) Delete(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return inTx(m.DB, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM users_totp WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// NewRecoveryCodes replaces a user's recovery codes with n new ones, and returns their
+// plaintext. Only a SHA-256 hash of each code is stored, in the same way as tokens.
+func (m TOTPModel) NewRecoveryCodes(userID int64, n int) ([]string, error) {
+	codes := make([]string, n)
+	values := make([]string, n)
+	args := []interface{}{userID}
+
+	for i := range codes {
+		b := make([]byte, 10)
+
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		// Format the code as two groups of 8 characters, to make it easier to copy down.
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+
+		hash := recoveryCodeHash(codes[i])
+		values[i] = fmt.Sprintf("($1, $%d)", i+2)
+		args = append(args, hash)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := inTx(m.DB, func(tx DBTX) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO totp_recovery_codes (user_id, hash)
+			VALUES ` + strings.Join(values, ", ")
+
+		_, err = tx.ExecContext(ctx, query, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks one of a user's recovery codes as used. It returns false if the
+// code doesn't exist or has already been used.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+		UPDATE totp_recovery_codes
+		SET used_at = NOW()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, recoveryCodeHash(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// recoveryCodeHash hashes a recovery code, ignoring case and any dashes or spaces the
+// user typed in.
+func recoveryCodeHash(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
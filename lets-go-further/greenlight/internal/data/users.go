@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"reflect"
+	"regexp"
 	"time"
 
 	"github.com.go-learning.greenlight/internal/validator"
@@ -16,9 +18,26 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// DefaultLocale is the locale given to users who don't choose one when they register.
+const DefaultLocale = "en"
+
+// LocaleRX matches a language tag made up of a lowercase language code and an optional
+// uppercase region, such as "en", "fr" or "pt-BR".
+var LocaleRX = regexp.MustCompile("^[a-z]{2,3}(-[A-Z]{2})?$")
+
+// Register a locale rule for validate struct tags, which checks a value against LocaleRX.
+func init() {
+	validator.RegisterRule("locale", func(value reflect.Value, param string) string {
+		if !validator.Matches(value.String(), LocaleRX) {
+			return "must be a language tag such as en or pt-BR"
+		}
+		return ""
+	})
+}
+
 // Create a UserModel struct which wraps the connection pool.
 type UserModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
 // Declare a new AnonymousUser variable
@@ -31,11 +50,15 @@ var AnonymousUser = &User{}
 type User struct {
 	ID        int64     `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
+	Name      string    `json:"name" validate:"required,max=500"`
+	Email     string    `json:"email" validate:"required,email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	Locale    string    `json:"locale" validate:"locale"`
+	// PendingEmail holds a new email address which the user has asked to change to,
+	// but hasn't confirmed yet.
+	PendingEmail *string `json:"pending_email,omitempty"`
+	Version      int     `json:"-"`
 }
 
 // Create a custom password type which is a struct containing the plaintext and hashed
@@ -95,11 +118,9 @@ func ValidatePasswordPlaintext(v *validator.Validator, password string) {
 }
 
 func ValidateUser(v *validator.Validator, user *User) {
-	v.Check(user.Name != "", "name", "must be provided")
-	v.Check(len(user.Name) <= 500, "name", "must not be more than 500 bytes long")
-
-	// Call the standalone ValidateEmail() helper.
-	ValidateEmail(v, user.Email)
+	// The name, email and locale are checked by the rules in the validate tags on the
+	// User struct. The email rules give the same errors as ValidateEmail().
+	v.CheckStruct(user)
 
 	// If the plaintext password is not nil, call the standalone
 	// ValdiatePasswordPlaintext() helper
@@ -123,11 +144,11 @@ func ValidateUser(v *validator.Validator, user *User) {
 // that we did when creating a movie
 func (m UserModel) Insert(user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, activated)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO users (name, email, password_hash, activated, locale)
+		VALUES ($1, $2, $3, $4, $5)
 		RETURNING id, created_at, version`
 
-	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated}
+	args := []interface{}{user.Name, user.Email, user.Password.hash, user.Activated, user.Locale}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -154,7 +175,7 @@ func (m UserModel) Insert(user *User) error {
 // return one record (or none at all, in which case we return an ErrRecordNotFound error)
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, locale, pending_email, version
 		FROM users
 		WHERE email = $1`
 
@@ -170,6 +191,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Locale,
+		&user.PendingEmail,
 		&user.Version,
 	)
 
@@ -185,6 +208,53 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetAll returns every user in ID order. If activated isn't nil, only the users whose
+// activated status matches it are returned.
+func (m UserModel) GetAll(activated *bool) ([]*User, error) {
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, locale, pending_email, version
+		FROM users
+		WHERE ($1::boolean IS NULL OR activated = $1)
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, activated)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Password.hash,
+			&user.Activated,
+			&user.Locale,
+			&user.PendingEmail,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
 // Update the details for a specific user. Notice that we check against the version
 // field to help prevent any race conditions during the request cycle, just like we did
 // when updating a movie. And we also check for a violation of the "users_email_key"
@@ -193,8 +263,9 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 func (m UserModel) Update(user *User) error {
 	query := `
 		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET name = $1, email = $2, password_hash = $3, activated = $4, locale = $5, pending_email = $6,
+			version = version + 1
+		WHERE id = $7 AND version = $8
 		RETURNING version
 	`
 
@@ -203,6 +274,8 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Locale,
+		user.PendingEmail,
 		user.ID,
 		user.Version,
 	}
@@ -232,7 +305,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	// Set up the SQL query.
 	query := `
-		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.locale, users.pending_email, users.version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -260,6 +333,8 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Locale,
+		&user.PendingEmail,
 		&user.Version,
 	)
 
@@ -275,3 +350,32 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// Return the matching user
 	return &user, nil
 }
+
+// Delete removes a user, so long as their version hasn't changed since the record was
+// read. Their tokens and permissions are removed along with them by the ON DELETE
+// CASCADE foreign keys, while the movie revisions they made are kept but anonymised by
+// ON DELETE SET NULL.
+func (m UserModel) Delete(user *User) error {
+	query := `
+		DELETE FROM users
+		WHERE id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, user.ID, user.Version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
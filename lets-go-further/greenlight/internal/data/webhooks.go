@@ -0,0 +1,507 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/validator"
+	"github.com/lib/pq"
+)
+
+// Define constants for the catalogue events that webhooks can subscribe to.
+const (
+	EventMovieCreated  = "movie.created"
+	EventMovieUpdated  = "movie.updated"
+	EventMovieDeleted  = "movie.deleted"
+	EventMovieRestored = "movie.restored" // A movie being taken back out of the trash
+)
+
+// WebhookEvents lists every event type which a webhook can subscribe to.
+var WebhookEvents = []string{EventMovieCreated, EventMovieUpdated, EventMovieDeleted, EventMovieRestored}
+
+// Define constants for the status of a webhook delivery. A pending delivery is waiting
+// for its next attempt, while a dead delivery has used up all of its attempts.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryDead      = "dead"
+)
+
+// A Webhook is an endpoint registered by an admin to be notified about catalogue changes.
+// The secret is used to sign each payload, and is only ever included in the response
+// when the webhook is first created.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateWebhook(v *validator.Validator, webhook *Webhook) {
+	u, err := url.Parse(webhook.URL)
+
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an absolute http or https URL")
+	v.Check(len(webhook.URL) <= 2000, "url", "must not be more than 2000 bytes long")
+
+	// The secret isn't read back from the database, so it is only checked when it has
+	// been set (which it always is before an Insert()).
+	if webhook.Secret != "" {
+		v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+		v.Check(len(webhook.Secret) <= 200, "secret", "must not be more than 200 bytes long")
+	}
+
+	v.Check(len(webhook.Events) >= 1, "events", "must contain at least 1 event")
+	v.Check(validator.Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	for _, event := range webhook.Events {
+		v.Check(validator.In(event, WebhookEvents...), "events", "must only contain supported events ("+strings.Join(WebhookEvents, ", ")+")")
+	}
+}
+
+// A WebhookDelivery is a single event payload queued for a webhook, along with the
+// outcome of the most recent attempt to deliver it.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	WebhookID      int64           `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at,omitempty"`
+	LastStatusCode *int            `json:"last_status_code,omitempty"`
+	LastError      *string         `json:"last_error,omitempty"`
+
+	// The URL and secret of the webhook are filled in by ClaimDue(), so that the
+	// delivery worker has everything it needs to send the payload.
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+}
+
+// enqueueWebhookDeliveries queues a delivery of event for every movie, to every active
+// webhook which subscribes to that event. It is called by the MovieModel methods with
+// the same DBTX (and so the same transaction) as the change itself, so a delivery is
+// only ever queued for a change which was committed.
+func enqueueWebhookDeliveries(ctx context.Context, db DBTX, event string, movies ...*Movie) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	payloads := make([]string, 0, len(movies))
+
+	for _, movie := range movies {
+		payload, err := json.Marshal(map[string]interface{}{
+			"event":       event,
+			"occurred_at": time.Now().UTC().Format(time.RFC3339),
+			"movie":       movie,
+		})
+		if err != nil {
+			return err
+		}
+
+		payloads = append(payloads, string(payload))
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT webhooks.id, $1, payload::jsonb
+		FROM webhooks, unnest($2::text[]) AS payload
+		WHERE webhooks.active AND $1 = ANY(webhooks.events)`
+
+	_, err := db.ExecContext(ctx, query, event, pq.Array(payloads))
+	return err
+}
+
+// Define the WebhookModel type.
+type WebhookModel struct {
+	DB DBTX
+}
+
+func (m WebhookModel) Insert(webhook *Webhook) error {
+	query := `
+		INSERT INTO webhooks (url, secret, events, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{webhook.URL, webhook.Secret, pq.Array(webhook.Events), webhook.Active}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+// Get returns a webhook, without its secret.
+func (m WebhookModel) Get(id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, url, events, active, version
+		FROM webhooks
+		WHERE id = $1`
+
+	var webhook Webhook
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.URL,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+// GetAll returns every registered webhook, without their secrets.
+func (m WebhookModel) GetAll() ([]*Webhook, error) {
+	query := `
+		SELECT id, created_at, url, events, active, version
+		FROM webhooks
+		ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(
+			&webhook.ID,
+			&webhook.CreatedAt,
+			&webhook.URL,
+			pq.Array(&webhook.Events),
+			&webhook.Active,
+			&webhook.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Update saves changes to a webhook's URL, events and active flag, using the same
+// optimistic locking on the version field as MovieModel.Update(). The secret can't be
+// changed; a new webhook should be registered instead.
+func (m WebhookModel) Update(webhook *Webhook) error {
+	query := `
+		UPDATE webhooks
+		SET url = $1, events = $2, active = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{webhook.URL, pq.Array(webhook.Events), webhook.Active, webhook.ID, webhook.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a webhook, along with its delivery log.
+func (m WebhookModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM webhooks
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Define the WebhookDeliveryModel type.
+type WebhookDeliveryModel struct {
+	DB DBTX
+}
+
+// ClaimDue claims up to limit pending deliveries whose next attempt is due, and returns
+// them along with their webhook's URL and secret. Claiming a delivery counts as an
+// attempt and pushes its next attempt back by the lease duration, so that if the worker
+// dies part way through, the delivery is picked up again once the lease runs out.
+// FOR UPDATE SKIP LOCKED means several API instances can claim deliveries at once
+// without ever sending the same one twice.
+func (m WebhookDeliveryModel) ClaimDue(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET attempts = attempts + 1, last_attempt_at = NOW(), next_attempt_at = NOW() + $2 * interval '1 second'
+		FROM webhooks
+		WHERE webhooks.id = webhook_deliveries.webhook_id
+		AND webhook_deliveries.id IN (
+			SELECT id
+			FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING webhook_deliveries.id, webhook_deliveries.created_at, webhook_deliveries.webhook_id,
+			webhook_deliveries.event, webhook_deliveries.payload, webhook_deliveries.attempts,
+			webhooks.url, webhooks.secret`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		var payload []byte
+
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.CreatedAt,
+			&delivery.WebhookID,
+			&delivery.Event,
+			&payload,
+			&delivery.Attempts,
+			&delivery.URL,
+			&delivery.Secret,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		delivery.Payload = payload
+		delivery.Status = DeliveryPending
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// MarkSucceeded records a successful attempt at a delivery.
+func (m WebhookDeliveryModel) MarkSucceeded(id int64, statusCode int) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'succeeded', last_status_code = $2, last_error = NULL
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, statusCode)
+	return err
+}
+
+// MarkFailed records a failed attempt at a delivery. If retryAt is nil the delivery has
+// run out of attempts and is moved to the dead state, otherwise it stays pending until
+// retryAt. A statusCode of 0 means that no response was received.
+func (m WebhookDeliveryModel) MarkFailed(id int64, statusCode int, lastError string, retryAt *time.Time) error {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = $2, next_attempt_at = COALESCE($3, next_attempt_at), last_status_code = $4, last_error = $5
+		WHERE id = $1`
+
+	status := DeliveryPending
+	if retryAt == nil {
+		status = DeliveryDead
+	}
+
+	code := sql.NullInt64{Int64: int64(statusCode), Valid: statusCode != 0}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, status, retryAt, code, lastError)
+	return err
+}
+
+// Retry moves a dead delivery back to the pending state with a fresh set of attempts,
+// so that it is picked up again by the delivery worker straight away.
+func (m WebhookDeliveryModel) Retry(webhookID, id int64) (*WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+		WHERE id = $1 AND webhook_id = $2 AND status = 'dead'
+		RETURNING id, created_at, webhook_id, event, payload, status, attempts, next_attempt_at,
+			last_attempt_at, last_status_code, last_error`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	delivery, err := scanDelivery(m.DB.QueryRowContext(ctx, query, id, webhookID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return delivery, nil
+}
+
+// GetAllForWebhook returns a page of the delivery log for a webhook, newest first. An
+// empty status returns deliveries in every state.
+func (m WebhookDeliveryModel) GetAllForWebhook(webhookID int64, status string, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, webhook_id, event, payload, status, attempts,
+			next_attempt_at, last_attempt_at, last_status_code, last_error
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		AND (status = $2 OR $2 = '')
+		ORDER BY %s %s, id DESC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, webhookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		var total int
+
+		delivery, err := scanDelivery(rows, &total)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		totalRecords = total
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return deliveries, metadata, nil
+}
+
+// scanDelivery scans a webhook_deliveries row from either *sql.Row or *sql.Rows. Any
+// extra destinations (such as a window count) are scanned before the delivery columns.
+func scanDelivery(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var payload []byte
+	var nextAttemptAt, lastAttemptAt sql.NullTime
+	var lastStatusCode sql.NullInt64
+	var lastError sql.NullString
+
+	dest := append(extra,
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&nextAttemptAt,
+		&lastAttemptAt,
+		&lastStatusCode,
+		&lastError,
+	)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = payload
+
+	// The next attempt time is only meaningful while the delivery is still pending.
+	if nextAttemptAt.Valid && delivery.Status == DeliveryPending {
+		delivery.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if lastAttemptAt.Valid {
+		delivery.LastAttemptAt = &lastAttemptAt.Time
+	}
+	if lastStatusCode.Valid {
+		code := int(lastStatusCode.Int64)
+		delivery.LastStatusCode = &code
+	}
+	if lastError.Valid {
+		delivery.LastError = &lastError.String
+	}
+
+	return &delivery, nil
+}
@@ -0,0 +1,83 @@
+// Package database opens the PostgreSQL connection pool, with the settings shared by
+// the API server and the greenlightctl command.
+package database
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"os"
+	"time"
+
+	// Import the pq driver so that it can register itself with the database/sql
+	// package.
+	_ "github.com/lib/pq"
+)
+
+// Config holds the database settings. The MaxIdleTime is a duration string such as "15m".
+type Config struct {
+	DSN          string
+	MaxOpenConns int
+	MaxIdleConns int
+	MaxIdleTime  string
+}
+
+// RegisterFlags defines the -db-dsn, -db-max-open-conns, -db-max-idle-conns and
+// -db-max-idle-time command-line flags in fs, which are read into cfg when fs is parsed.
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	// Use the value of the GREENLIGHT_DB_DSN environment variable as the default value
+	// for the DSN.
+	fs.StringVar(&cfg.DSN, "db-dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PosgreSQL DSN")
+
+	// Read the connection pool settings from command-line flags into the config struct.
+	fs.IntVar(&cfg.MaxOpenConns, "db-max-open-conns", 25, "PosgreSQL max open connections")
+	fs.IntVar(&cfg.MaxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.MaxIdleTime, "db-max-idle-time", "15m", "PostgreSQL mex connection idle time")
+}
+
+// Open returns a sql.DB connection pool, once it has checked that the database can be
+// reached.
+func Open(cfg Config) (*sql.DB, error) {
+	// Use the sql.Open() to create an empty connection pool, using the DSN from the config
+	// struct
+	db, err := sql.Open("postgres", cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the maximum number of open (in-use + idle) connections in the pool. Note that
+	// passing a value less than or equal to 0 will mean there is no limit.
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+
+	// Set the maximum number of idle conections in the pool. Again, passing a value
+	// less than or equal to 0 will mean there is no limit.
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+
+	// Use the time.ParseDuration() function to convert the idle timeout duration string
+	// to a time.Duration type.
+	duration, err := time.ParseDuration(cfg.MaxIdleTime)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Set the maximum idle timeout.
+	db.SetConnMaxIdleTime(duration)
+
+	// Create a context with a 5-second timeout deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Use PingContext() to establish a new connection to the database, passing it the
+	// context we created above as a parameter. If the connection couldn't be
+	// established successfully within the 5 second deadline, then this will return an
+	// error
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// Return the sql.DB connection pool
+	return db, nil
+}
@@ -0,0 +1,527 @@
+// Package jsonpatch applies JSON Patch (RFC 6902) and JSON Merge Patch (RFC 7396)
+// documents to JSON documents.
+//
+// Documents are decoded to plain interface{} values (with numbers as json.Number, so
+// that they are written back exactly as they were), patched, and encoded again.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidPatch is returned when a patch document is malformed, such as when it
+	// isn't valid JSON or an operation is missing a member.
+	ErrInvalidPatch = errors.New("invalid patch")
+
+	// ErrTestFailed is returned when a test operation finds a different value to the
+	// one it expects.
+	ErrTestFailed = errors.New("test failed")
+
+	// ErrNotApplicable is returned when a well-formed operation can't be applied to the
+	// document, such as when its path doesn't exist.
+	ErrNotApplicable = errors.New("patch can't be applied")
+)
+
+// Operation is a single JSON Patch operation. Value is only used by the add, replace and
+// test operations, and From by move and copy.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply applies a JSON Patch document, which is an array of operations, to doc. The
+// operations are applied in order, and if any of them fails the error is returned and
+// nothing is changed.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var ops []map[string]json.RawMessage
+
+	err := decode(patch, &ops)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	var value interface{}
+
+	err = decode(doc, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, members := range ops {
+		op, err := parseOperation(members)
+		if err != nil {
+			return nil, fmt.Errorf("%w: operation %d %s", ErrInvalidPatch, i, err)
+		}
+
+		value, err = op.apply(value)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return json.Marshal(value)
+}
+
+// MergePatch applies a JSON Merge Patch document to doc. Each member of the patch
+// replaces the member of the same name in the document, except that objects are merged
+// recursively and null removes the member.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var p interface{}
+
+	err := decode(patch, &p)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	var value interface{}
+
+	err = decode(doc, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(mergePatch(value, p))
+}
+
+// mergePatch is the MergePatch algorithm from section 2 of RFC 7396.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergePatch(t[name], value)
+		}
+	}
+
+	return t
+}
+
+// decode decodes a single JSON value, keeping numbers as json.Number.
+func decode(data []byte, dst interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("document must not be empty")
+		}
+		return err
+	}
+
+	err = dec.Decode(&struct{}{})
+	if err != io.EOF {
+		return errors.New("document must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// parseOperation checks that an operation has the members that its op needs, as a
+// missing value has to be told apart from a null one.
+func parseOperation(members map[string]json.RawMessage) (*Operation, error) {
+	op := &Operation{}
+
+	for _, name := range []string{"op", "path"} {
+		raw, ok := members[name]
+		if !ok {
+			return nil, fmt.Errorf("is missing %q", name)
+		}
+
+		dst := &op.Op
+		if name == "path" {
+			dst = &op.Path
+		}
+
+		err := json.Unmarshal(raw, dst)
+		if err != nil {
+			return nil, fmt.Errorf("has a %q which isn't a string", name)
+		}
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		value, ok := members["value"]
+		if !ok {
+			return nil, fmt.Errorf("is missing %q", "value")
+		}
+		op.Value = value
+
+	case "move", "copy":
+		raw, ok := members["from"]
+		if !ok {
+			return nil, fmt.Errorf("is missing %q", "from")
+		}
+
+		err := json.Unmarshal(raw, &op.From)
+		if err != nil {
+			return nil, fmt.Errorf("has a %q which isn't a string", "from")
+		}
+
+	case "remove":
+
+	default:
+		return nil, fmt.Errorf("has an unknown op %q", op.Op)
+	}
+
+	return op, nil
+}
+
+// apply applies the operation to doc, returning the new document.
+func (op *Operation) apply(doc interface{}) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		var value interface{}
+
+		err := decode(op.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+		}
+
+		switch op.Op {
+		case "add":
+			return add(doc, path, value)
+		case "replace":
+			return replace(doc, path, value)
+		default:
+			current, err := get(doc, path)
+			if err != nil {
+				return nil, err
+			}
+
+			if !equal(current, value) {
+				return nil, ErrTestFailed
+			}
+
+			return doc, nil
+		}
+
+	case "remove":
+		return remove(doc, path)
+
+	default:
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+
+		if op.Op == "move" {
+			// A value can't be moved into itself, as it would no longer exist.
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: a value can't be moved into one of its children", ErrNotApplicable)
+			}
+
+			doc, err = remove(doc, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// The copy mustn't share any maps or slices with the original, or
+			// changing one later in the patch would change both.
+			value = clone(value)
+		}
+
+		return add(doc, path, value)
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its reference tokens, with the ~1
+// and ~0 escapes replaced. The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: the path %q doesn't start with /", ErrInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// arrayIndex parses a reference token as an index into an array of length n. If end is
+// true the index may be n (or "-"), which refers to the position after the last element.
+func arrayIndex(token string, n int, end bool) (int, error) {
+	if token == "-" && end {
+		return n, nil
+	}
+
+	// Leading zeros aren't allowed, so that each index has only one form.
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: %q isn't an array index", ErrNotApplicable, token)
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("%w: %q isn't an array index", ErrNotApplicable, token)
+	}
+
+	if i > n || (i == n && !end) {
+		return 0, fmt.Errorf("%w: the index %d is out of range", ErrNotApplicable, i)
+	}
+
+	return i, nil
+}
+
+// get returns the value that path refers to.
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: the member %q doesn't exist", ErrNotApplicable, token)
+			}
+			doc = value
+
+		case []interface{}:
+			i, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+
+		default:
+			return nil, fmt.Errorf("%w: %q can't be found in a value which isn't an object or array", ErrNotApplicable, token)
+		}
+	}
+
+	return doc, nil
+}
+
+// update finds the container which holds the last token of path, and calls fn with it
+// and that token. fn returns the changed container, which replaces the original (as
+// adding to or removing from an array makes a new slice). The new document is returned.
+func update(doc interface{}, path []string, fn func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := get(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	child, err = update(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch container := doc.(type) {
+	case map[string]interface{}:
+		container[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(container), false)
+		container[i] = child
+	}
+
+	return doc, nil
+}
+
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch container := container.(type) {
+		case map[string]interface{}:
+			container[token] = value
+			return container, nil
+
+		case []interface{}:
+			i, err := arrayIndex(token, len(container), true)
+			if err != nil {
+				return nil, err
+			}
+
+			container = append(container, nil)
+			copy(container[i+1:], container[i:])
+			container[i] = value
+			return container, nil
+
+		default:
+			return nil, fmt.Errorf("%w: %q can't be added to a value which isn't an object or array", ErrNotApplicable, token)
+		}
+	})
+}
+
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: the whole document can't be removed", ErrNotApplicable)
+	}
+
+	return update(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch container := container.(type) {
+		case map[string]interface{}:
+			if _, ok := container[token]; !ok {
+				return nil, fmt.Errorf("%w: the member %q doesn't exist", ErrNotApplicable, token)
+			}
+
+			delete(container, token)
+			return container, nil
+
+		case []interface{}:
+			i, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+
+			return append(container[:i:i], container[i+1:]...), nil
+
+		default:
+			return nil, fmt.Errorf("%w: %q can't be removed from a value which isn't an object or array", ErrNotApplicable, token)
+		}
+	})
+}
+
+// replace is the same as a remove followed by an add, but the target must already
+// exist.
+func replace(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	return update(doc, path, func(container interface{}, token string) (interface{}, error) {
+		switch container := container.(type) {
+		case map[string]interface{}:
+			if _, ok := container[token]; !ok {
+				return nil, fmt.Errorf("%w: the member %q doesn't exist", ErrNotApplicable, token)
+			}
+
+			container[token] = value
+			return container, nil
+
+		case []interface{}:
+			i, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+
+			container[i] = value
+			return container, nil
+
+		default:
+			return nil, fmt.Errorf("%w: %q can't be replaced in a value which isn't an object or array", ErrNotApplicable, token)
+		}
+	})
+}
+
+// clone returns a deep copy of a decoded JSON value.
+func clone(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(value))
+		for name, v := range value {
+			c[name] = clone(v)
+		}
+		return c
+
+	case []interface{}:
+		c := make([]interface{}, len(value))
+		for i, v := range value {
+			c[i] = clone(v)
+		}
+		return c
+	}
+
+	return value
+}
+
+// equal reports whether two decoded JSON values are equal, as described for the test
+// operation in section 4.6 of RFC 6902. Numbers are equal if their values are, so 1 and
+// 1.0 are the same.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+
+		for name, value := range a {
+			other, ok := b[name]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+
+		x, _, errA := big.ParseFloat(string(a), 10, 256, big.ToNearestEven)
+		y, _, errB := big.ParseFloat(string(b), 10, 256, big.ToNearestEven)
+		if errA != nil || errB != nil {
+			return a == b
+		}
+		return x.Cmp(y) == 0
+	}
+
+	return a == b
+}
@@ -3,10 +3,12 @@ package mailer
 import (
 	"bytes"
 	"embed"
+	"fmt"
 	"html/template"
+	"io/fs"
+	"path"
+	"strings"
 	"time"
-
-	"github.com/go-mail/mail/v2"
 )
 
 // Below we declare a new variable with the type embed.FS (embedded file system) to hold
@@ -17,34 +19,97 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-// Define a Mailer struct which contains a mail.Dialer instance (used to connect to a
-// SMTP server) and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com").
+// Define a Mailer struct which contains the Transport used to deliver emails, the
+// sender information for your emails (the name and address you want the email to be
+// from, such as "Alice Smith <alice@example.com"), how many times to try sending each
+// email before giving up, and the parsed templates.
 type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+	transport  Transport
+	sender     string
+	attempts   int
+	retryDelay time.Duration
+	templates  map[string]*template.Template
 }
 
-func New(host string, port int, username string, password string, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
+// New returns a Mailer which delivers emails with the given transport, trying up to
+// attempts times (with retryDelay between each attempt) before returning an error. The
+// templates are parsed once here, rather than on every call to Send().
+func New(transport Transport, sender string, attempts int, retryDelay time.Duration) (Mailer, error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	templates, err := parseTemplates()
+	if err != nil {
+		return Mailer{}, err
+	}
 
-	// Return a Mailer instance containing the dialer and sender information.
 	return Mailer{
-		dialer: dialer,
-		sender: sender,
+		transport:  transport,
+		sender:     sender,
+		attempts:   attempts,
+		retryDelay: retryDelay,
+		templates:  templates,
+	}, nil
+}
+
+// parseTemplates parses every file in the embedded templates directory, and returns them
+// in a map keyed by file name. Each file defines its own "subject", "plainBody" and
+// "htmlBody" templates, so each is parsed into a separate template set.
+func parseTemplates() (map[string]*template.Template, error) {
+	files, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		return nil, err
 	}
+
+	templates := make(map[string]*template.Template, len(files))
+
+	for _, file := range files {
+		tmpl, err := template.New("email").ParseFS(templateFS, file)
+		if err != nil {
+			return nil, err
+		}
+
+		templates[path.Base(file)] = tmpl
+	}
+
+	return templates, nil
+}
+
+// lookup returns the template for templateFile in the given locale. Translations are
+// named after the locale, so for the locale "fr-CA" we try "user_welcome.fr-CA.tmpl",
+// then "user_welcome.fr.tmpl", and finally fall back to "user_welcome.tmpl".
+func (m Mailer) lookup(locale, templateFile string) (*template.Template, error) {
+	ext := path.Ext(templateFile)
+	base := strings.TrimSuffix(templateFile, ext)
+
+	candidates := []string{}
+
+	if locale != "" {
+		candidates = append(candidates, base+"."+locale+ext)
+
+		if i := strings.Index(locale, "-"); i > 0 {
+			candidates = append(candidates, base+"."+locale[:i]+ext)
+		}
+	}
+
+	candidates = append(candidates, templateFile)
+
+	for _, name := range candidates {
+		if tmpl, ok := m.templates[name]; ok {
+			return tmpl, nil
+		}
+	}
+
+	return nil, fmt.Errorf("mailer: no template named %q", templateFile)
 }
 
 // Define a Send() method on the Mailer typee. This takes the recipient email address
-// as the first parameter, the name of the file containing the templates and any
-// dynamic data for the templates as an interface{} parameter.
-func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
-	// Use the ParseFS() method to parse the required template file form the embedded
-	// file system
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// as the first parameter, the recipient's locale, the name of the file containing the
+// templates and any dynamic data for the templates as an interface{} parameter.
+func (m Mailer) Send(recipient, locale, templateFile string, data interface{}) error {
+	// Look up the template in the recipient's language, falling back to the default.
+	tmpl, err := m.lookup(locale, templateFile)
 	if err != nil {
 		return err
 	}
@@ -72,33 +137,27 @@ func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
 		return err
 	}
 
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	// Then we use the SetHeader() method to set the mail recipient, sender and subject
-	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
-	// method to set the HTML body. It's important to note that the AddAlternative() should
-	// always be called *after* SetBody()
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	// Call the DialAndSend method on the dialer, passing in the message to send. This
-	// opens a connection to the SMTP server, sends the message, then closes the
-	// connection. If there is a timeout, it will return a "dial tcp: i/o timeout"
-	// error
-	// Try sending the email up to three times before aborting and returning ther final
-	// error. We sleep for 500 milliseconds between each attempt.
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
+	msg := Message{
+		To:        recipient,
+		From:      m.sender,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}
+
+	// Try sending the email up to the configured number of times before aborting and
+	// returning the final error. We sleep for the retry delay between each attempt.
+	for i := 1; i <= m.attempts; i++ {
+		err = m.transport.Send(msg)
 		// If everything worked, return nil
 		if nil == err {
 			return nil
 		}
 
 		// If it didn't work, sleep for a short time and retry
-		time.Sleep(500 * time.Millisecond)
+		if i < m.attempts {
+			time.Sleep(m.retryDelay)
+		}
 	}
 
 	return err
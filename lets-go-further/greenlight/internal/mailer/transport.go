@@ -0,0 +1,162 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-mail/mail/v2"
+)
+
+// A Message is a fully rendered email, ready to be handed to a Transport.
+type Message struct {
+	To        string
+	From      string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// toMail converts the message to a go-mail message, with the plain-text body first and
+// the HTML body as an alternative.
+func (msg Message) toMail() *mail.Message {
+	m := mail.NewMessage()
+	m.SetHeader("To", msg.To)
+	m.SetHeader("From", msg.From)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.PlainBody)
+	m.AddAlternative("text/html", msg.HTMLBody)
+	return m
+}
+
+// A Transport delivers rendered messages. The Mailer takes care of rendering templates
+// and retrying, so a Transport only needs to make a single attempt.
+type Transport interface {
+	Send(msg Message) error
+}
+
+// SMTPTransport sends messages through an SMTP server.
+type SMTPTransport struct {
+	dialer *mail.Dialer
+}
+
+func NewSMTPTransport(host string, port int, username, password string) *SMTPTransport {
+	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
+	// also configure this to use a 5-second timeout whenever we send an email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPTransport{dialer: dialer}
+}
+
+// Send opens a connection to the SMTP server, sends the message, then closes the
+// connection. If there is a timeout, it will return a "dial tcp: i/o timeout" error.
+func (t *SMTPTransport) Send(msg Message) error {
+	return t.dialer.DialAndSend(msg.toMail())
+}
+
+// FileTransport writes each message to its own .eml file in a directory, which can be
+// opened with most mail clients. It is meant for local development.
+type FileTransport struct {
+	dir string
+	seq uint64
+}
+
+// NewFileTransport returns a FileTransport which writes to dir, creating the directory
+// if it doesn't exist.
+func NewFileTransport(dir string) (*FileTransport, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileTransport{dir: dir}, nil
+}
+
+func (t *FileTransport) Send(msg Message) error {
+	// Name the files so that they sort in the order they were sent. The sequence number
+	// stops two messages sent in the same nanosecond from overwriting each other.
+	name := fmt.Sprintf("%s-%d.eml", time.Now().UTC().Format("20060102T150405.000000000"), atomic.AddUint64(&t.seq, 1))
+
+	f, err := os.Create(filepath.Join(t.dir, name))
+	if err != nil {
+		return err
+	}
+
+	_, err = msg.toMail().WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// LogTransport writes a readable copy of each message to an io.Writer (such as
+// os.Stdout), instead of sending it. It is meant for local development.
+type LogTransport struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewLogTransport(out io.Writer) *LogTransport {
+	return &LogTransport{out: out}
+}
+
+func (t *LogTransport) Send(msg Message) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "----- email -----\n")
+	fmt.Fprintf(&b, "To: %s\nFrom: %s\nSubject: %s\n\n", msg.To, msg.From, msg.Subject)
+	fmt.Fprintf(&b, "%s\n", strings.TrimSpace(msg.PlainBody))
+	fmt.Fprintf(&b, "-----------------\n")
+
+	// Lock the writer, so that messages sent at the same time don't get interleaved.
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, err := io.WriteString(t.out, b.String())
+	return err
+}
+
+// MemoryTransport keeps every message in memory, so that tests can check what was sent
+// without a mail server.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(msg Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the messages sent so far, oldest first.
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	messages := make([]Message, len(t.messages))
+	copy(messages, t.messages)
+	return messages
+}
+
+// Reset forgets every message sent so far.
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
@@ -0,0 +1,196 @@
+// Package msgpack encodes values in the MessagePack format (https://msgpack.org).
+//
+// Values are first encoded to JSON with encoding/json, and the result is then re-encoded
+// as MessagePack. This means that struct tags and MarshalJSON() methods are honoured, so
+// a value's MessagePack encoding has exactly the same fields and formatting as its JSON
+// encoding. It is slower than encoding directly, but keeps the two formats in step.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Marshal returns the MessagePack encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode numbers as json.Number, so that integers are encoded as MessagePack
+	// integers rather than all becoming floats.
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+
+	var value interface{}
+
+	err = dec.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	err = encode(&buf, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encode writes a value decoded from JSON to buf. Only the types which encoding/json
+// decodes to (with UseNumber) need handling.
+func encode(buf *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+
+	case bool:
+		if value {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+
+	case json.Number:
+		if i, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			encodeInt(buf, i)
+			return nil
+		}
+
+		if u, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			writeUint(buf, u, 8)
+			return nil
+		}
+
+		f, err := value.Float64()
+		if err != nil {
+			return err
+		}
+
+		buf.WriteByte(0xcb)
+		writeUint(buf, math.Float64bits(f), 8)
+
+	case string:
+		n := len(value)
+
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n <= math.MaxUint8:
+			buf.WriteByte(0xd9)
+			writeUint(buf, uint64(n), 1)
+		case n <= math.MaxUint16:
+			buf.WriteByte(0xda)
+			writeUint(buf, uint64(n), 2)
+		default:
+			buf.WriteByte(0xdb)
+			writeUint(buf, uint64(n), 4)
+		}
+
+		buf.WriteString(value)
+
+	case []interface{}:
+		writeHeader(buf, len(value), 0x90, 0xdc)
+
+		for _, item := range value {
+			err := encode(buf, item)
+			if err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		writeHeader(buf, len(value), 0x80, 0xde)
+
+		// Sort the keys so that the same value always has the same encoding, in the
+		// same way as encoding/json.
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			err := encode(buf, key)
+			if err != nil {
+				return err
+			}
+
+			err = encode(buf, value[key])
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", value)
+	}
+
+	return nil
+}
+
+// encodeInt writes an integer in the smallest MessagePack format that holds it.
+func encodeInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buf.WriteByte(byte(i))
+	case i >= -32 && i < 0:
+		buf.WriteByte(byte(int8(i)))
+	case i >= 0 && i <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		writeUint(buf, uint64(i), 1)
+	case i >= 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		writeUint(buf, uint64(i), 2)
+	case i >= 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		writeUint(buf, uint64(i), 4)
+	case i >= 0:
+		buf.WriteByte(0xcf)
+		writeUint(buf, uint64(i), 8)
+	case i >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		writeUint(buf, uint64(i), 1)
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		writeUint(buf, uint64(i), 2)
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		writeUint(buf, uint64(i), 4)
+	default:
+		buf.WriteByte(0xd3)
+		writeUint(buf, uint64(i), 8)
+	}
+}
+
+// writeHeader writes the header for an array or map with n elements, using the fix
+// format (whose type byte is fix) when n is less than 16, and otherwise the 16 or 32-bit
+// format (whose type bytes are long and long+1).
+func writeHeader(buf *bytes.Buffer, n int, fix, long byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(long)
+		writeUint(buf, uint64(n), 2)
+	default:
+		buf.WriteByte(long + 1)
+		writeUint(buf, uint64(n), 4)
+	}
+}
+
+// writeUint writes the low size bytes of u to buf, in big-endian order.
+func writeUint(buf *bytes.Buffer, u uint64, size int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], u)
+	buf.Write(b[8-size:])
+}
@@ -0,0 +1,71 @@
+package msgpack
+
+import (
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+type testRuntime int32
+
+func (r testRuntime) MarshalJSON() ([]byte, error) {
+	return []byte(`"102 mins"`), nil
+}
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"Nil", nil, "c0"},
+		{"False", false, "c2"},
+		{"True", true, "c3"},
+		{"Positive fixint", 127, "7f"},
+		{"Negative fixint", -32, "e0"},
+		{"Uint8", 128, "cc80"},
+		{"Uint16", 256, "cd0100"},
+		{"Uint32", 65536, "ce00010000"},
+		{"Uint64", int64(math.MaxUint32) + 1, "cf0000000100000000"},
+		{"Largest uint64", uint64(math.MaxUint64), "cfffffffffffffffff"},
+		{"Int8", -33, "d0df"},
+		{"Int16", -129, "d1ff7f"},
+		{"Int32", -32769, "d2ffff7fff"},
+		{"Int64", int64(math.MinInt32) - 1, "d3ffffffff7fffffff"},
+		{"Float", 1.5, "cb3ff8000000000000"},
+		{"Fixstr", "abc", "a3616263"},
+		{"Empty string", "", "a0"},
+		{"Str8", strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+		{"Str16", strings.Repeat("a", 256), "da0100" + strings.Repeat("61", 256)},
+		{"Fixarray", []int{1, 2}, "920102"},
+		{"Empty array", []int{}, "90"},
+		{"Array16", make([]bool, 16), "dc0010" + strings.Repeat("c2", 16)},
+		{"Fixmap with sorted keys", map[string]int{"b": 2, "a": 1}, "82a16101a16202"},
+		{"Nested", map[string]interface{}{"genres": []string{"drama"}}, "81a667656e72657391a56472616d61"},
+		{
+			name: "Struct tags and MarshalJSON",
+			value: struct {
+				ID      int64       `json:"id"`
+				Runtime testRuntime `json:"runtime"`
+				Hidden  string      `json:"-"`
+			}{ID: 1, Runtime: 102, Hidden: "secret"},
+			want: "82a2696401a772756e74696d65a8313032206d696e73",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := Marshal(tt.value)
+			assert.NilError(t, err)
+			assert.Equal(t, hex.EncodeToString(b), tt.want)
+		})
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	_, err := Marshal(make(chan int))
+	assert.Equal(t, err != nil, true)
+}
@@ -0,0 +1,628 @@
+// Package openapi holds the OpenAPI 3 document which describes the API, along with the
+// documentation page which renders it. It can also check a response against the
+// document, so that the document can't quietly drift away from what the handlers
+// actually send.
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The document and the documentation page are embedded, so that the binary serves the
+// description of exactly the API that it was built with.
+//
+//go:embed "openapi.json"
+var document []byte
+
+//go:embed "docs.html"
+var docsPage []byte
+
+// JSON returns the OpenAPI document.
+func JSON() []byte {
+	return document
+}
+
+// DocsHTML returns the documentation page. It is self-contained (the styles and script
+// are inline, and nothing is fetched from a CDN) and loads the document from
+// openapi.json relative to its own URL.
+func DocsHTML() []byte {
+	return docsPage
+}
+
+// Document is the parsed OpenAPI document. Only the parts needed to check responses
+// are decoded; the rest of the document is left for the documentation page.
+type Document struct {
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*Schema   `json:"schemas"`
+		Responses map[string]*Response `json:"responses"`
+	} `json:"components"`
+}
+
+// Operation is a single method on a path.
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Response describes a response for one status code, with a schema for the body in each
+// content type that it can be sent in.
+type Response struct {
+	Ref     string                `json:"$ref"`
+	Content map[string]*MediaType `json:"content"`
+}
+
+// MediaType holds the schema of a response body in one content type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of an OpenAPI schema object that the validator understands: $ref,
+// type, nullable, properties, required, additionalProperties, items, enum, pattern,
+// the date-time format and oneOf. Other keywords (such as minimum and maxLength, which
+// only constrain request bodies) are ignored.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 string             `json:"type"`
+	Nullable             bool               `json:"nullable"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *Schema            `json:"-"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	Format               string             `json:"format"`
+	OneOf                []*Schema          `json:"oneOf"`
+
+	// closed is true when additionalProperties is false, so that properties which aren't
+	// listed are reported rather than ignored.
+	closed  bool
+	pattern *regexp.Regexp
+}
+
+// UnmarshalJSON decodes a schema, handling additionalProperties being either a boolean
+// or a schema.
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	// The alias type has the same fields but none of the methods, so decoding into it
+	// doesn't call this method again.
+	type alias Schema
+
+	var fields struct {
+		*alias
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+	}
+	fields.alias = (*alias)(s)
+
+	err := json.Unmarshal(b, &fields)
+	if err != nil {
+		return err
+	}
+
+	switch raw := bytes.TrimSpace(fields.AdditionalProperties); {
+	case len(raw) == 0, string(raw) == "true":
+	case string(raw) == "false":
+		s.closed = true
+	default:
+		s.AdditionalProperties = &Schema{}
+		return json.Unmarshal(raw, s.AdditionalProperties)
+	}
+
+	return nil
+}
+
+// Route is a method and path template which the document has an operation for.
+type Route struct {
+	Method string
+	Path   string
+}
+
+// Load parses the embedded OpenAPI document.
+func Load() (*Document, error) {
+	return Parse(document)
+}
+
+// Parse parses an OpenAPI document. It checks that every $ref points to something in the
+// components and that every pattern compiles, so that a mistake in the document is found
+// when it is loaded rather than when a response happens to reach it.
+func Parse(b []byte) (*Document, error) {
+	var doc Document
+
+	err := json.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+
+	for name, schema := range doc.Components.Schemas {
+		err := doc.prepare(schema)
+		if err != nil {
+			return nil, fmt.Errorf("openapi: schema %s: %w", name, err)
+		}
+	}
+
+	for name, response := range doc.Components.Responses {
+		err := doc.prepareResponse(response)
+		if err != nil {
+			return nil, fmt.Errorf("openapi: response %s: %w", name, err)
+		}
+	}
+
+	for path, operations := range doc.Paths {
+		for method, operation := range operations {
+			for status, response := range operation.Responses {
+				err := doc.prepareResponse(response)
+				if err != nil {
+					return nil, fmt.Errorf("openapi: %s %s %s response: %w", strings.ToUpper(method), path, status, err)
+				}
+			}
+		}
+	}
+
+	return &doc, nil
+}
+
+func (doc *Document) prepareResponse(response *Response) error {
+	if response.Ref != "" {
+		_, err := doc.response(response)
+		return err
+	}
+
+	for _, mediaType := range response.Content {
+		if mediaType.Schema != nil {
+			err := doc.prepare(mediaType.Schema)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// prepare walks a schema, compiling its patterns and checking its references.
+func (doc *Document) prepare(s *Schema) error {
+	if s.Ref != "" {
+		_, err := doc.schema(s.Ref)
+		return err
+	}
+
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = re
+	}
+
+	children := append([]*Schema{s.Items, s.AdditionalProperties}, s.OneOf...)
+	for _, property := range s.Properties {
+		children = append(children, property)
+	}
+
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+
+		err := doc.prepare(child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// schema resolves a reference to one of the component schemas.
+func (doc *Document) schema(ref string) (*Schema, error) {
+	name := strings.TrimPrefix(ref, "#/components/schemas/")
+
+	s, ok := doc.Components.Schemas[name]
+	if !ok || name == ref {
+		return nil, fmt.Errorf("unresolved reference %q", ref)
+	}
+
+	return s, nil
+}
+
+// response resolves a response, following its reference to one of the component
+// responses if it has one.
+func (doc *Document) response(response *Response) (*Response, error) {
+	if response.Ref == "" {
+		return response, nil
+	}
+
+	name := strings.TrimPrefix(response.Ref, "#/components/responses/")
+
+	resolved, ok := doc.Components.Responses[name]
+	if !ok || name == response.Ref {
+		return nil, fmt.Errorf("unresolved reference %q", response.Ref)
+	}
+
+	return resolved, nil
+}
+
+// Routes returns the method and path template of every operation in the document,
+// sorted by path and then method.
+func (doc *Document) Routes() []Route {
+	var routes []Route
+
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			routes = append(routes, Route{Method: strings.ToUpper(method), Path: path})
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	return routes
+}
+
+// Operation finds the operation for a request's method and path, along with the path
+// template it matched. Where more than one template matches, the one with the most
+// fixed segments wins, so /v1/movies/export is preferred to /v1/movies/{id}. It returns
+// nil if no template matches the path, or none of those which do has the method.
+func (doc *Document) Operation(method, path string) (*Operation, string) {
+	segments := strings.Split(path, "/")
+
+	var (
+		best     *Operation
+		bestPath string
+		bestRank = -1
+	)
+
+	for template, operations := range doc.Paths {
+		rank, ok := matchTemplate(strings.Split(template, "/"), segments)
+		if !ok || rank <= bestRank {
+			continue
+		}
+
+		operation, ok := operations[strings.ToLower(method)]
+		if !ok {
+			continue
+		}
+
+		best, bestPath, bestRank = operation, template, rank
+	}
+
+	return best, bestPath
+}
+
+// matchTemplate reports whether the segments of a path match those of a template, and
+// if so how many of the template's segments are fixed rather than {parameters}.
+func matchTemplate(template, segments []string) (int, bool) {
+	if len(template) != len(segments) {
+		return 0, false
+	}
+
+	fixed := 0
+
+	for i, segment := range template {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if segments[i] == "" {
+				return 0, false
+			}
+			continue
+		}
+
+		if segment != segments[i] {
+			return 0, false
+		}
+		fixed++
+	}
+
+	return fixed, true
+}
+
+// ResponseError is returned by ValidateResponse() when a response doesn't match the
+// document. Problems holds everything that was wrong with it, each prefixed with where
+// in the body the problem was found (such as "$.movie.runtime").
+type ResponseError struct {
+	Method   string
+	Path     string
+	Status   int
+	Problems []string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("openapi: %s %s %d response doesn't match the document: %s", e.Method, e.Path, e.Status, strings.Join(e.Problems, "; "))
+}
+
+// ValidateResponse checks a response against the operation for its request's method and
+// path. The status code must be listed for the operation, except that error statuses
+// (400 and above) may fall back to the "default" response; success statuses never do,
+// so a handler which starts sending a new one is caught. The content type must be
+// listed for the status, and JSON bodies (application/json and any +json type) are
+// checked against its schema. Other bodies, such as CSV and MessagePack, are only
+// checked for their content type, as are empty bodies.
+//
+// Requests which don't match any operation get the router's 404 Not Found or 405 Method
+// Not Allowed response, so for those an error status is checked against the Error
+// component response instead.
+func (doc *Document) ValidateResponse(method, path string, status int, contentType string, body []byte) error {
+	e := &ResponseError{Method: method, Path: path, Status: status}
+
+	var response *Response
+
+	operation, _ := doc.Operation(method, path)
+
+	switch {
+	case operation != nil:
+		response = operation.Responses[strconv.Itoa(status)]
+		if response == nil && status >= http.StatusBadRequest {
+			response = operation.Responses["default"]
+		}
+		if response == nil {
+			e.Problems = append(e.Problems, fmt.Sprintf("status %d isn't documented", status))
+			return e
+		}
+
+	case status >= http.StatusBadRequest:
+		response = doc.Components.Responses["Error"]
+		if response == nil {
+			e.Problems = append(e.Problems, "there is no Error component response")
+			return e
+		}
+
+	default:
+		e.Problems = append(e.Problems, "there is no operation for the route")
+		return e
+	}
+
+	response, err := doc.response(response)
+	if err != nil {
+		e.Problems = append(e.Problems, err.Error())
+		return e
+	}
+
+	// A response with no body and no content type has nothing more to check.
+	if len(body) == 0 && contentType == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		e.Problems = append(e.Problems, fmt.Sprintf("invalid content type %q", contentType))
+		return e
+	}
+
+	content, ok := response.Content[mediaType]
+	if !ok {
+		e.Problems = append(e.Problems, fmt.Sprintf("content type %s isn't documented", mediaType))
+		return e
+	}
+
+	// The body of a HEAD response (or one too long for the caller to keep) is empty, so
+	// only its content type can be checked.
+	if len(body) == 0 || content.Schema == nil || !(mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")) {
+		return nil
+	}
+
+	// The numbers are kept as json.Number, so that integers can be told apart from
+	// other numbers.
+	var value interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	err = dec.Decode(&value)
+	if err != nil {
+		e.Problems = append(e.Problems, fmt.Sprintf("body isn't valid JSON: %v", err))
+		return e
+	}
+
+	doc.validate(content.Schema, value, "$", &e.Problems)
+
+	if len(e.Problems) > 0 {
+		return e
+	}
+
+	return nil
+}
+
+// ValidateSchema checks a JSON value against one of the component schemas, returning a
+// ResponseError (with no method or path) if it doesn't match.
+func (doc *Document) ValidateSchema(name string, body []byte) error {
+	e := &ResponseError{}
+
+	s, ok := doc.Components.Schemas[name]
+	if !ok {
+		return fmt.Errorf("openapi: there is no schema %q", name)
+	}
+
+	var value interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	err := dec.Decode(&value)
+	if err != nil {
+		return fmt.Errorf("openapi: body isn't valid JSON: %w", err)
+	}
+
+	doc.validate(s, value, "$", &e.Problems)
+
+	if len(e.Problems) > 0 {
+		return e
+	}
+
+	return nil
+}
+
+// validate checks a decoded JSON value against a schema, adding anything wrong with it
+// to problems.
+func (doc *Document) validate(s *Schema, value interface{}, at string, problems *[]string) {
+	if s.Ref != "" {
+		// The references were checked when the document was parsed.
+		resolved, _ := doc.schema(s.Ref)
+		doc.validate(resolved, value, at, problems)
+		return
+	}
+
+	report := func(format string, args ...interface{}) {
+		*problems = append(*problems, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if value == nil {
+		if !s.Nullable && s.Type != "" {
+			report("is null")
+		}
+		return
+	}
+
+	if len(s.OneOf) > 0 {
+		matches := 0
+
+		for _, option := range s.OneOf {
+			var optionProblems []string
+			doc.validate(option, value, at, &optionProblems)
+
+			if len(optionProblems) == 0 {
+				matches++
+			}
+		}
+
+		if matches != 1 {
+			report("matches %d of the oneOf schemas rather than exactly one", matches)
+		}
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			report("is %s, not an object", typeName(value))
+			return
+		}
+
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				report("is missing the required property %q", name)
+			}
+		}
+
+		// Sort the names, so that the problems are reported in the same order every
+		// time.
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			property, ok := s.Properties[name]
+
+			switch {
+			case ok:
+				doc.validate(property, object[name], at+"."+name, problems)
+			case s.AdditionalProperties != nil:
+				doc.validate(s.AdditionalProperties, object[name], at+"."+name, problems)
+			case s.closed:
+				report("has the undocumented property %q", name)
+			}
+		}
+
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			report("is %s, not an array", typeName(value))
+			return
+		}
+
+		if s.Items != nil {
+			for i, item := range array {
+				doc.validate(s.Items, item, fmt.Sprintf("%s[%d]", at, i), problems)
+			}
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			report("is %s, not a string", typeName(value))
+			return
+		}
+
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			report("%q doesn't match the pattern %s", str, s.Pattern)
+		}
+
+		if s.Format == "date-time" {
+			_, err := time.Parse(time.RFC3339Nano, str)
+			if err != nil {
+				report("%q isn't a date-time", str)
+			}
+		}
+
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			report("is %s, not an integer", typeName(value))
+			return
+		}
+
+		_, err := number.Int64()
+		if err != nil {
+			report("%s isn't an integer", number)
+		}
+
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			report("is %s, not a number", typeName(value))
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			report("is %s, not a boolean", typeName(value))
+		}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		report("%v isn't one of the allowed values", value)
+	}
+}
+
+// inEnum reports whether a decoded JSON value is one of the values in an enum. Numbers
+// in the response are json.Number while those in the document are float64, so they're
+// compared as strings.
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// typeName returns the JSON type of a decoded value, for error messages.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case json.Number:
+		return "a number"
+	case bool:
+		return "a boolean"
+	default:
+		return "null"
+	}
+}
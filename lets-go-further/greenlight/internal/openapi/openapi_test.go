@@ -0,0 +1,223 @@
+package openapi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	assert.NilError(t, err)
+
+	if len(doc.Routes()) == 0 {
+		t.Error("the document has no routes")
+	}
+}
+
+func TestOperation(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantPath string
+	}{
+		{"Fixed path", http.MethodGet, "/v1/healthcheck", "/v1/healthcheck"},
+		{"Parameter", http.MethodGet, "/v1/movies/1", "/v1/movies/{id}"},
+		{"Fixed segment wins", http.MethodGet, "/v1/movies/export", "/v1/movies/export"},
+		{"Nested parameters", http.MethodPost, "/v1/movies/1/revisions/2/restore", "/v1/movies/{id}/revisions/{rev}/restore"},
+		{"Wrong method", http.MethodPut, "/v1/healthcheck", ""},
+		{"Unknown path", http.MethodGet, "/v1/missing", ""},
+		{"Empty parameter", http.MethodGet, "/v1/movies/", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, path := doc.Operation(tt.method, tt.path)
+			assert.Equal(t, path, tt.wantPath)
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		status      int
+		contentType string
+		body        string
+		wantValid   bool
+	}{
+		{
+			name:        "Valid movie",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"movie": {"id": 1, "title": "Casablanca", "year": 1942, "runtime": "102 mins", "genres": ["drama"], "version": 1}}`,
+			wantValid:   true,
+		},
+		{
+			name:        "Runtime as a number",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"movie": {"id": 1, "title": "Casablanca", "runtime": 102, "version": 1}}`,
+			wantValid:   false,
+		},
+		{
+			name:        "Runtime in the wrong format",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"movie": {"id": 1, "title": "Casablanca", "runtime": "102 minutes", "version": 1}}`,
+			wantValid:   false,
+		},
+		{
+			name:        "Missing required property",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"movie": {"id": 1, "title": "Casablanca"}}`,
+			wantValid:   false,
+		},
+		{
+			name:        "Undocumented property",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"movie": {"id": 1, "title": "Casablanca", "version": 1, "rating": 5}}`,
+			wantValid:   false,
+		},
+		{
+			name:        "Integer with a fraction",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"movie": {"id": 1.5, "title": "Casablanca", "version": 1}}`,
+			wantValid:   false,
+		},
+		{
+			name:        "Undocumented success status",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusCreated,
+			contentType: "application/json",
+			body:        `{"movie": {"id": 1, "title": "Casablanca", "version": 1}}`,
+			wantValid:   false,
+		},
+		{
+			name:        "Undocumented content type",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusOK,
+			contentType: "text/csv",
+			body:        "id,title",
+			wantValid:   false,
+		},
+		{
+			name:        "CSV list",
+			method:      http.MethodGet,
+			path:        "/v1/movies",
+			status:      http.StatusOK,
+			contentType: "text/csv",
+			body:        "id,title,year,runtime,genres,version",
+			wantValid:   true,
+		},
+		{
+			name:        "Default error response",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusNotFound,
+			contentType: "application/problem+json",
+			body:        `{"type": "urn:greenlight:problem:not_found", "title": "Not Found", "status": 404, "code": "not_found", "instance": "/v1/movies/1", "detail": "the requested resource could not be found"}`,
+			wantValid:   true,
+		},
+		{
+			name:        "Unknown error code",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1",
+			status:      http.StatusNotFound,
+			contentType: "application/problem+json",
+			body:        `{"type": "urn:greenlight:problem:gone", "title": "Not Found", "status": 404, "code": "gone", "instance": "/v1/movies/1"}`,
+			wantValid:   false,
+		},
+		{
+			name:        "Router not found",
+			method:      http.MethodGet,
+			path:        "/v1/missing",
+			status:      http.StatusNotFound,
+			contentType: "application/json",
+			body:        `{"error": "the requested resource could not be found"}`,
+			wantValid:   true,
+		},
+		{
+			name:        "Success for an unknown route",
+			method:      http.MethodGet,
+			path:        "/v1/missing",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{}`,
+			wantValid:   false,
+		},
+		{
+			name:        "Nullable property",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1/revisions",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"revisions": [{"movie_id": 1, "version": 1, "operation": "insert", "user_id": null, "created_at": "2024-01-02T03:04:05Z", "snapshot": {"title": "Casablanca", "year": 1942, "runtime": "102 mins", "genres": null, "deleted": false}, "changes": {}}]}`,
+			wantValid:   true,
+		},
+		{
+			name:        "Invalid date-time",
+			method:      http.MethodGet,
+			path:        "/v1/movies/1/revisions",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"revisions": [{"movie_id": 1, "version": 1, "operation": "insert", "user_id": null, "created_at": "yesterday", "snapshot": {"title": "Casablanca", "year": 1942, "runtime": "102 mins", "genres": [], "deleted": false}, "changes": {}}]}`,
+			wantValid:   false,
+		},
+		{
+			name:        "HEAD response",
+			method:      http.MethodGet,
+			path:        "/v1/healthcheck",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        "",
+			wantValid:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := doc.ValidateResponse(tt.method, tt.path, tt.status, tt.contentType, []byte(tt.body))
+			assert.Equal(t, err == nil, tt.wantValid)
+		})
+	}
+}
+
+func TestParseUnresolvedReference(t *testing.T) {
+	_, err := Parse([]byte(`{"components": {"schemas": {"Movie": {"type": "object", "properties": {"runtime": {"$ref": "#/components/schemas/Runtime"}}}}}}`))
+
+	if err == nil {
+		t.Error("expected an error for the unresolved reference")
+	}
+}
@@ -0,0 +1,116 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The default blocklist holds the SHA-1 hashes of a few hundred of the most common
+// passwords seen in data breaches, and is embedded so that the application works out of
+// the box. A much larger list can be loaded from a file with LoadBlocklistFile().
+//
+//go:embed "blocklist.txt.gz"
+var defaultBlocklist []byte
+
+// Blocklist is a set of SHA-1 password hashes, sorted so that they can be binary
+// searched. Each hash takes 20 bytes, so even a list of millions of hashes fits easily in
+// memory.
+type Blocklist struct {
+	hashes [][sha1.Size]byte
+}
+
+// DefaultBlocklist returns the blocklist embedded in the application.
+func DefaultBlocklist() (*Blocklist, error) {
+	return LoadBlocklist(bytes.NewReader(defaultBlocklist))
+}
+
+// LoadBlocklistFile reads a gzip-compressed blocklist from a file.
+func LoadBlocklistFile(path string) (*Blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadBlocklist(f)
+}
+
+// LoadBlocklist reads a gzip-compressed blocklist, which holds one hex encoded SHA-1
+// hash per line. Anything after a colon on a line is ignored, so the downloadable Pwned
+// Passwords lists (in the format "HASH:COUNT") can be used as they are, and blank lines
+// and lines starting with # are skipped.
+func LoadBlocklist(r io.Reader) (*Blocklist, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var b Blocklist
+
+	scanner := bufio.NewScanner(gz)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if i := strings.IndexByte(text, ':'); i >= 0 {
+			text = text[:i]
+		}
+
+		var hash [sha1.Size]byte
+
+		if len(text) != hex.EncodedLen(sha1.Size) {
+			return nil, fmt.Errorf("blocklist line %d: invalid SHA-1 hash", line)
+		}
+
+		_, err := hex.Decode(hash[:], []byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("blocklist line %d: invalid SHA-1 hash", line)
+		}
+
+		b.hashes = append(b.hashes, hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The lists are usually sorted already, but sort them anyway rather than relying on
+	// it, as a single hash out of place would make the search miss others.
+	sort.Slice(b.hashes, func(i, j int) bool {
+		return bytes.Compare(b.hashes[i][:], b.hashes[j][:]) < 0
+	})
+
+	return &b, nil
+}
+
+// Len returns the number of hashes in the blocklist.
+func (b *Blocklist) Len() int {
+	return len(b.hashes)
+}
+
+// Contains reports whether the SHA-1 hash of plaintext is in the blocklist.
+func (b *Blocklist) Contains(plaintext string) bool {
+	hash := sha1.Sum([]byte(plaintext))
+
+	i := sort.Search(len(b.hashes), func(i int) bool {
+		return bytes.Compare(b.hashes[i][:], hash[:]) >= 0
+	})
+
+	return i < len(b.hashes) && b.hashes[i] == hash
+}
@@ -0,0 +1,70 @@
+package password
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+// gzipped returns s gzip-compressed, as blocklist files are.
+func gzipped(t *testing.T, s string) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+
+	_, err := gz.Write([]byte(s))
+	assert.NilError(t, err)
+	assert.NilError(t, gz.Close())
+
+	return &buf
+}
+
+func TestLoadBlocklist(t *testing.T) {
+	// The SHA-1 hashes of "password" (in the Pwned Passwords HASH:COUNT format) and
+	// "letmein", out of order and with a comment and a blank line.
+	list := "# breached passwords\n" +
+		"B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3\n" +
+		"\n" +
+		"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n"
+
+	b, err := LoadBlocklist(gzipped(t, list))
+	assert.NilError(t, err)
+
+	assert.Equal(t, b.Len(), 2)
+	assert.Equal(t, b.Contains("password"), true)
+	assert.Equal(t, b.Contains("letmein"), true)
+	assert.Equal(t, b.Contains("Password"), false)
+	assert.Equal(t, b.Contains("correct horse battery staple"), false)
+}
+
+func TestLoadBlocklistInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		wantErr string
+	}{
+		{"Short hash", "5BAA61E4\n", "blocklist line 1: invalid SHA-1 hash"},
+		{"Not hex", "# comment\nZZAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n", "blocklist line 2: invalid SHA-1 hash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBlocklist(gzipped(t, tt.list))
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			assert.Equal(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestDefaultBlocklist(t *testing.T) {
+	b, err := DefaultBlocklist()
+	assert.NilError(t, err)
+
+	assert.Equal(t, b.Len() > 0, true)
+	assert.Equal(t, b.Contains("password"), true)
+	assert.Equal(t, b.Contains("12345678"), true)
+}
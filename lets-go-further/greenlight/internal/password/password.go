@@ -0,0 +1,142 @@
+// Package password decides whether a new password is good enough to use. It checks the
+// length, estimates how hard the password would be to guess, rejects passwords which
+// contain the user's name or email address, and looks the password up in a blocklist of
+// passwords known to have appeared in data breaches.
+package password
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+// Policy holds the rules a new password has to meet. MinScore is a strength score from 0
+// to 4, as returned by Score(). A nil Blocklist skips the breached password check.
+//
+// The score can require more than MinLength: a score of 2 needs about 20 bits, which
+// takes 10 characters, or 8 if the password mixes upper case letters with non-letters.
+type Policy struct {
+	MinLength int
+	MinScore  int
+	Blocklist *Blocklist
+}
+
+// Validate checks a new password against the policy, and adds any problem to the
+// validator under the "password" key. The personal arguments are things the user is
+// known by (such as their name and email address) which the password mustn't contain.
+func (p *Policy) Validate(v *validator.Validator, plaintext string, personal ...string) {
+	v.Check(len(plaintext) >= p.MinLength, "password", fmt.Sprintf("must be at least %d bytes long", p.MinLength))
+	v.Check(!containsPersonal(plaintext, personal), "password", "must not contain your name or email address")
+
+	if p.Blocklist != nil {
+		v.Check(!p.Blocklist.Contains(plaintext), "password", "has appeared in a data breach, so please choose a different one")
+	}
+
+	v.Check(Score(plaintext) >= p.MinScore, "password", "is too easy to guess, so try a longer password or a few unrelated words")
+}
+
+// Score estimates how hard a password is to guess, on the same 0 to 4 scale as zxcvbn:
+// 0 means it would take fewer than 10^3 guesses, 1 fewer than 10^6, 2 fewer than 10^8,
+// 3 fewer than 10^10, and 4 more than that.
+func Score(plaintext string) int {
+	// Convert the bits of entropy to the order of magnitude of the number of guesses.
+	magnitude := Entropy(plaintext) * math.Log10(2)
+
+	switch {
+	case magnitude < 3:
+		return 0
+	case magnitude < 6:
+		return 1
+	case magnitude < 8:
+		return 2
+	case magnitude < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Entropy estimates the number of bits of entropy in a password, using the rules of
+// thumb from NIST SP 800-63: 4 bits for the first character, 2 bits for each of the next
+// 7, 1.5 bits for each of the 9th to 20th and 1 bit for each after that, plus 6 bits if
+// it mixes upper case and non-letter characters. Characters which repeat the one before
+// them, or continue a run such as "abc" or "321", add nothing, so padding a password out
+// with "1111" or "5678" doesn't make it any stronger.
+func Entropy(plaintext string) float64 {
+	var (
+		bits             float64
+		counted          int
+		prev, lastStep   rune
+		upper, nonLetter bool
+	)
+
+	for i, r := range plaintext {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case !unicode.IsLetter(r):
+			nonLetter = true
+		}
+
+		r = unicode.ToLower(r)
+		step := r - prev
+		prev = r
+
+		if i > 0 && (step == 0 || ((step == 1 || step == -1) && step == lastStep)) {
+			lastStep = step
+			continue
+		}
+		lastStep = step
+
+		counted++
+
+		switch {
+		case counted == 1:
+			bits += 4
+		case counted <= 8:
+			bits += 2
+		case counted <= 20:
+			bits += 1.5
+		default:
+			bits++
+		}
+	}
+
+	if upper && nonLetter {
+		bits += 6
+	}
+
+	return bits
+}
+
+// containsPersonal reports whether the password contains any of the personal strings,
+// ignoring case. Names are split into words, and only the part of an email address
+// before the @ is checked, as that is the part which is likely to be reused. Anything
+// shorter than 3 characters is ignored, as it would match too many passwords.
+func containsPersonal(plaintext string, personal []string) bool {
+	plaintext = strings.ToLower(plaintext)
+
+	for _, s := range personal {
+		s = strings.ToLower(s)
+
+		if at := strings.LastIndexByte(s, '@'); at >= 0 {
+			s = s[:at]
+		}
+
+		words := strings.FieldsFunc(s, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+
+		for _, word := range append(words, s) {
+			if utf8.RuneCountInString(word) >= 3 && strings.Contains(plaintext, word) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,101 @@
+package password
+
+import (
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+	"github.com.go-learning.greenlight/internal/validator"
+)
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		plaintext string
+		wantBits  float64
+		wantScore int
+	}{
+		{"", 0, 0},
+		{"a", 4, 0},
+		{"aaaaaaaa", 4, 0},
+		{"abcdefgh", 6, 0},
+		{"87654321", 6, 0},
+		{"qwertyui", 18, 1},
+		{"qwertyuio", 19.5, 1},
+		{"qwertyuiop", 21, 2},
+		{"Qwertyu1", 24, 2},
+		{"Tr0ub4dor&3", 28.5, 3},
+		{"correct horse battery staple", 42, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.plaintext, func(t *testing.T) {
+			assert.Equal(t, Entropy(tt.plaintext), tt.wantBits)
+			assert.Equal(t, Score(tt.plaintext), tt.wantScore)
+		})
+	}
+}
+
+func TestContainsPersonal(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext string
+		personal  []string
+		want      bool
+	}{
+		{"First name", "alicepassword", []string{"Alice Smith"}, true},
+		{"Last name", "xxsmithxx", []string{"Alice Smith"}, true},
+		{"Different case", "ALICE2024", []string{"alice smith"}, true},
+		{"Short names are ignored", "al-is-here", []string{"Al Li"}, false},
+		{"Email address", "myjonesword", []string{"bob.jones@example.com"}, true},
+		{"Email domain is ignored", "example-password", []string{"bob.jones@example.com"}, false},
+		{"Nothing personal", "correct horse battery staple", []string{"Alice Smith", "alice@example.com"}, false},
+		{"No personal strings", "alice", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, containsPersonal(tt.plaintext, tt.personal), tt.want)
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	blocklist, err := DefaultBlocklist()
+	assert.NilError(t, err)
+
+	// The default policy, as set by the API's command line flags.
+	policy := &Policy{MinLength: 8, MinScore: 2, Blocklist: blocklist}
+
+	personal := []string{"Alice Smith", "alice@example.com"}
+
+	tests := []struct {
+		name      string
+		plaintext string
+		wantError string
+	}{
+		{"Strong", "correct horse battery staple", ""},
+		{"Eight characters with mixed case and a digit", "Qwertyu1", ""},
+		{"Too short", "Zx9!kq", "must be at least 8 bytes long"},
+		{"Contains the name", "alice-smith-1984", "must not contain your name or email address"},
+		{"Breached", "qwertyuiop", "has appeared in a data breach, so please choose a different one"},
+		{"Nine lower case characters", "qwertyuio", "is too easy to guess, so try a longer password or a few unrelated words"},
+		{"Run of digits", "9876543210", "is too easy to guess, so try a longer password or a few unrelated words"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			policy.Validate(v, tt.plaintext, personal...)
+
+			assert.Equal(t, v.Errors["password"], tt.wantError)
+		})
+	}
+}
+
+func TestPolicyWithoutBlocklist(t *testing.T) {
+	policy := &Policy{MinLength: 8, MinScore: 2}
+
+	v := validator.New()
+	policy.Validate(v, "qwertyuiop")
+
+	assert.Equal(t, v.Valid(), true)
+}
@@ -0,0 +1,132 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Define the parameters of the codes we generate. These are the defaults from RFC 6238,
+// and the only values that every authenticator app supports: HMAC-SHA1, 6 digits and a
+// 30 second time step.
+const (
+	Digits = 6
+	Period = 30 * time.Second
+)
+
+// Skew is the number of time steps either side of the current one for which a code is
+// still accepted, to allow for clock drift and the time taken to type the code in.
+const Skew = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160-bit secret, base32 encoded (which is how
+// authenticator apps expect to be given it).
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI for a secret, which authenticator apps can
+// read (usually from a QR code) to set themselves up.
+func ProvisioningURI(secret, issuer, account string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// Step returns the time step number that t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for a secret at a given time step, as defined by RFC 4226
+// (HOTP) with the step number as the counter.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation: the low 4 bits of the last byte pick where to take 31 bits
+	// from.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod), nil
+}
+
+// Validate checks a code against the secret at time t, allowing for Skew steps either
+// side. It returns the step that the code matched, so that the caller can refuse to
+// accept the same code twice.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+
+	for step := current - Skew; step <= current+Skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// ValidateUnused is like Validate, but also rejects a code from a time step at or before
+// lastUsedStep (if there is one). Once a code has been used, neither it nor any code
+// from an earlier step (which may still be inside the Skew window) can be used again, so
+// a code that has been seen by someone else can't be replayed.
+func ValidateUnused(secret, code string, t time.Time, lastUsedStep *int64) (int64, bool) {
+	step, ok := Validate(secret, code, t)
+	if !ok {
+		return 0, false
+	}
+
+	if lastUsedStep != nil && step <= *lastUsedStep {
+		return 0, false
+	}
+
+	return step, true
+}
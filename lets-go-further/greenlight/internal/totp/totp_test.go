@@ -0,0 +1,153 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+// rfcSecret is the SHA-1 secret from RFC 6238 appendix B, the ASCII string
+// "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The test vectors from RFC 6238 appendix B for HMAC-SHA1. The RFC gives 8 digit
+	// codes, and a 6 digit code is the last 6 digits of the 8 digit one.
+	tests := []struct {
+		unix     int64
+		rfcCode  string
+		wantCode string
+	}{
+		{59, "94287082", "287082"},
+		{1111111109, "07081804", "081804"},
+		{1111111111, "14050471", "050471"},
+		{1234567890, "89005924", "005924"},
+		{2000000000, "69279037", "279037"},
+		{20000000000, "65353130", "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rfcCode, func(t *testing.T) {
+			code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+			assert.NilError(t, err)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	code, err := Code(strings.ToLower(rfcSecret), Step(time.Unix(59, 0)))
+	assert.NilError(t, err)
+	assert.Equal(t, code, "287082")
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	_, err := Code("not base32!", 1)
+	if err == nil {
+		t.Fatal("got no error for an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	codeAt := func(step int64) string {
+		code, err := Code(rfcSecret, step)
+		assert.NilError(t, err)
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"Current step", codeAt(current), current, true},
+		{"Previous step", codeAt(current - 1), current - 1, true},
+		{"Next step", codeAt(current + 1), current + 1, true},
+		{"Two steps ago", codeAt(current - 2), 0, false},
+		{"Two steps ahead", codeAt(current + 2), 0, false},
+		{"Spaces", codeAt(current)[:3] + " " + codeAt(current)[3:], current, true},
+		{"Too short", codeAt(current)[:5], 0, false},
+		{"Too long", codeAt(current) + "0", 0, false},
+		{"Empty", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now)
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, step, tt.wantStep)
+		})
+	}
+}
+
+func TestValidateUnused(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	previousCode, err := Code(rfcSecret, current-1)
+	assert.NilError(t, err)
+
+	currentCode, err := Code(rfcSecret, current)
+	assert.NilError(t, err)
+
+	step := func(s int64) *int64 { return &s }
+
+	tests := []struct {
+		name         string
+		code         string
+		lastUsedStep *int64
+		wantOK       bool
+	}{
+		{"Never used", currentCode, nil, true},
+		{"Earlier step used", currentCode, step(current - 1), true},
+		{"Same code replayed", currentCode, step(current), false},
+		{"Later step used", currentCode, step(current + 1), false},
+		{"Older code inside the window after a newer one was used", previousCode, step(current), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := ValidateUnused(rfcSecret, tt.code, now, tt.lastUsedStep)
+			assert.Equal(t, ok, tt.wantOK)
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	assert.NilError(t, err)
+
+	b, err := GenerateSecret()
+	assert.NilError(t, err)
+
+	// 160 bits is 32 base32 characters, without padding.
+	assert.Equal(t, len(a), 32)
+	assert.Equal(t, a != b, true)
+
+	_, err = Code(a, 1)
+	assert.NilError(t, err)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI(rfcSecret, "Greenlight", "alice@example.com")
+
+	u, err := url.Parse(uri)
+	assert.NilError(t, err)
+
+	assert.Equal(t, u.Scheme, "otpauth")
+	assert.Equal(t, u.Host, "totp")
+	assert.Equal(t, u.Path, "/Greenlight:alice@example.com")
+
+	q := u.Query()
+	assert.Equal(t, q.Get("secret"), rfcSecret)
+	assert.Equal(t, q.Get("issuer"), "Greenlight")
+	assert.Equal(t, q.Get("digits"), "6")
+	assert.Equal(t, q.Get("period"), "30")
+}
@@ -0,0 +1,443 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A RuleFunc checks a value against a rule named in a validate struct tag. The param is
+// the text after the = in the tag (such as "500" for max=500), or "" if there isn't one.
+// It returns the error message for the field if the value breaks the rule, or "" if it
+// doesn't. Pointers have already been followed, so value is never a pointer, except that
+// the required rule is called with the zero Value for a nil pointer.
+type RuleFunc func(value reflect.Value, param string) string
+
+// The rules which can be used in validate struct tags, keyed by name. Custom rules are
+// added with RegisterRule().
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]RuleFunc{
+		"required": requiredRule,
+		"min":      minRule,
+		"max":      maxRule,
+		"oneof":    oneOfRule,
+		"unique":   uniqueRule,
+		"email":    emailRule,
+	}
+)
+
+// RegisterRule adds a rule which can be used in validate struct tags, or replaces the
+// built-in rule with the same name. Rules are looked up when a struct type is first
+// checked, so they should be registered before then, such as in an init() function.
+func RegisterRule(name string, fn RuleFunc) {
+	if name == "" || name == "dive" || name == "msg" || strings.ContainsAny(name, ",= ") {
+		panic(fmt.Sprintf("validator: invalid rule name %q", name))
+	}
+
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+
+	rules[name] = fn
+}
+
+// CheckStruct checks the fields of a struct (or a pointer to one) against the rules in
+// their validate tags, and adds an error to the map for each field which breaks one.
+// For example:
+//
+//	type Movie struct {
+//		Title  string   `json:"title" validate:"required,max=500"`
+//		Genres []string `json:"genres" validate:"required,max=5,unique,dive,max=50"`
+//	}
+//
+// The rules are checked in order, and as with Check() only the first error for each field
+// is kept. Rules after dive are checked against each element of a slice or array. A msg
+// after a rule replaces that rule's error message, such as min=1888,msg=must be greater
+// than 1888. The message ends at the next comma, so it can't contain one.
+//
+// Errors are keyed by the field's JSON name, so that they match the request body. The
+// fields of nested structs are keyed as "parent.child", and the elements of slices as
+// "genres[2]". Fields with a validate:"-" tag and unexported fields are skipped.
+func (v *Validator) CheckStruct(s interface{}) {
+	value := reflect.ValueOf(s)
+	for value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: CheckStruct() needs a struct, not %T", s))
+	}
+
+	v.checkStruct(value, "")
+}
+
+func (v *Validator) checkStruct(value reflect.Value, prefix string) {
+	for _, f := range structFields(value.Type()) {
+		fieldValue := value.Field(f.index)
+
+		// The fields of an embedded struct are checked as if they belonged to the
+		// outer struct, in the same way as encoding/json promotes them.
+		if f.embedded {
+			for fieldValue.Kind() == reflect.Pointer && !fieldValue.IsNil() {
+				fieldValue = fieldValue.Elem()
+			}
+
+			if fieldValue.Kind() == reflect.Struct {
+				v.checkStruct(fieldValue, prefix)
+			}
+			continue
+		}
+
+		v.checkValue(fieldValue, prefix+f.name, f.rules, f.dive)
+	}
+}
+
+// checkValue checks a single value against its rules, and then checks the fields of a
+// struct, or the elements of a slice or array against the dive rules.
+func (v *Validator) checkValue(value reflect.Value, key string, fieldRules, diveRules []rule) {
+	// A nil pointer only breaks the required rule, and the other rules are skipped, so
+	// that a pointer can be used for an optional field. A non-nil pointer counts as
+	// provided even if it points to a zero value.
+	pointer := false
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			for _, r := range fieldRules {
+				if r.name == "required" {
+					v.Check(false, key, r.check(reflect.Value{}))
+				}
+			}
+			return
+		}
+
+		pointer = true
+		value = value.Elem()
+	}
+
+	for _, r := range fieldRules {
+		if pointer && r.name == "required" {
+			continue
+		}
+
+		if message := r.check(value); message != "" {
+			v.AddError(key, message)
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		v.checkStruct(value, key+".")
+
+	case reflect.Slice, reflect.Array:
+		if len(diveRules) == 0 && !hasStructElements(value.Type()) {
+			return
+		}
+
+		for i := 0; i < value.Len(); i++ {
+			v.checkValue(value.Index(i), fmt.Sprintf("%s[%d]", key, i), diveRules, nil)
+		}
+	}
+}
+
+// hasStructElements reports whether a slice or array type holds structs (or pointers to
+// them), whose fields need checking even without any dive rules.
+func hasStructElements(t reflect.Type) bool {
+	return indirect(t.Elem()).Kind() == reflect.Struct
+}
+
+// indirect returns the type that a pointer type points to, following any number of
+// pointers.
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// A rule is a single rule from a validate tag, such as max=500. The message replaces the
+// rule's own error message if it isn't empty.
+type rule struct {
+	name    string
+	param   string
+	message string
+	fn      RuleFunc
+}
+
+// check checks a value against the rule, and returns the error message, or "".
+func (r rule) check(value reflect.Value) string {
+	message := r.fn(value, r.param)
+	if message != "" && r.message != "" {
+		return r.message
+	}
+
+	return message
+}
+
+// A field holds what CheckStruct() needs to know about a struct field.
+type field struct {
+	index    int
+	name     string
+	embedded bool // An anonymous struct field without a JSON name
+	rules    []rule
+	dive     []rule
+}
+
+// fieldCache holds the fields of each struct type which has been checked, so that the
+// tags are only parsed once per type rather than on every request.
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// structFields returns the fields of a struct type which CheckStruct() looks at, parsing
+// their tags the first time the type is seen. It panics if a tag names an unknown rule,
+// as that is a mistake in the code rather than the data.
+func structFields(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+
+	var fields []field
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		// An embedded struct's exported fields are promoted even if its type isn't
+		// exported, so it is only skipped if it has a JSON name.
+		embedded := sf.Anonymous && sf.Tag.Get("json") == "" && indirect(sf.Type).Kind() == reflect.Struct
+
+		tag := sf.Tag.Get("validate")
+		if (!sf.IsExported() && !embedded) || tag == "-" {
+			continue
+		}
+
+		f := field{index: i, name: jsonName(sf), embedded: embedded}
+
+		if tag != "" {
+			var err error
+
+			f.rules, f.dive, err = parseTag(tag)
+			if err != nil {
+				panic(fmt.Sprintf("validator: %s.%s: %s", t, sf.Name, err))
+			}
+		}
+
+		fields = append(fields, f)
+	}
+
+	cached, _ := fieldCache.LoadOrStore(t, fields)
+	return cached.([]field)
+}
+
+// jsonName returns the name of a struct field in JSON, which is used for its error key.
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+
+	return name
+}
+
+// parseTag splits a validate tag into the rules for the field, and the rules after dive
+// for its elements.
+func parseTag(tag string) (fieldRules, diveRules []rule, err error) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+
+	dived := false
+
+	for _, part := range strings.Split(tag, ",") {
+		if part == "dive" {
+			if dived {
+				return nil, nil, fmt.Errorf("dive can only be used once")
+			}
+			dived = true
+			continue
+		}
+
+		name, param, _ := strings.Cut(part, "=")
+
+		// A msg belongs to the rule before it.
+		if name == "msg" {
+			last := fieldRules
+			if dived {
+				last = diveRules
+			}
+
+			if len(last) == 0 || last[len(last)-1].message != "" || param == "" {
+				return nil, nil, fmt.Errorf("msg must follow a rule and give a message")
+			}
+
+			last[len(last)-1].message = param
+			continue
+		}
+
+		fn, ok := rules[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown rule %q", name)
+		}
+
+		r := rule{name: name, param: param, fn: fn}
+
+		if dived {
+			diveRules = append(diveRules, r)
+		} else {
+			fieldRules = append(fieldRules, r)
+		}
+	}
+
+	return fieldRules, diveRules, nil
+}
+
+// mustParseNumber parses the param of a rule such as min or max. A bad param is a mistake
+// in a struct tag, so it panics.
+func mustParseNumber(rule, param string) float64 {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validator: %s needs a number, not %q", rule, param))
+	}
+
+	return n
+}
+
+// compareSize compares the size of a value with n, where the size is the length of a
+// string (in bytes), slice, array or map, or the value of a number. The unit describes
+// the size in an error message.
+func compareSize(value reflect.Value, n float64) (cmp int, unit string) {
+	var size float64
+
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = float64(value.Len()), "bytes long"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size, unit = float64(value.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		size = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = value.Float()
+	default:
+		panic(fmt.Sprintf("validator: min and max can't be used on a %s", value.Type()))
+	}
+
+	switch {
+	case size < n:
+		return -1, unit
+	case size > n:
+		return 1, unit
+	}
+	return 0, unit
+}
+
+// items returns the noun for a number of items in an error message.
+func items(param string) string {
+	if param == "1" {
+		return "item"
+	}
+
+	return "items"
+}
+
+func requiredRule(value reflect.Value, param string) string {
+	if !value.IsValid() || value.IsZero() {
+		return "must be provided"
+	}
+
+	return ""
+}
+
+func minRule(value reflect.Value, param string) string {
+	cmp, unit := compareSize(value, mustParseNumber("min", param))
+	if cmp >= 0 {
+		return ""
+	}
+
+	switch unit {
+	case "items":
+		return fmt.Sprintf("must contain at least %s %s", param, items(param))
+	case "":
+		return fmt.Sprintf("must be at least %s", param)
+	default:
+		return fmt.Sprintf("must be at least %s %s", param, unit)
+	}
+}
+
+func maxRule(value reflect.Value, param string) string {
+	cmp, unit := compareSize(value, mustParseNumber("max", param))
+	if cmp <= 0 {
+		return ""
+	}
+
+	switch unit {
+	case "items":
+		return fmt.Sprintf("must not contain more than %s %s", param, items(param))
+	case "":
+		return fmt.Sprintf("must be a maximum of %s", param)
+	default:
+		return fmt.Sprintf("must not be more than %s %s", param, unit)
+	}
+}
+
+// oneOfRule checks that a value is one of a space separated list, such as
+// oneof=partial atomic. Numbers are compared in their decimal form.
+func oneOfRule(value reflect.Value, param string) string {
+	options := strings.Fields(param)
+
+	if !In(fmt.Sprint(value), options...) {
+		return "must be one of: " + strings.Join(options, ", ")
+	}
+
+	return ""
+}
+
+// uniqueRule checks that a slice or array has no duplicate elements.
+func uniqueRule(value reflect.Value, param string) string {
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		panic(fmt.Sprintf("validator: unique can't be used on a %s", value.Type()))
+	}
+
+	seen := make(map[interface{}]bool, value.Len())
+
+	for i := 0; i < value.Len(); i++ {
+		element := comparableKey(value.Index(i))
+		if seen[element] {
+			return "must not contain duplicate values"
+		}
+		seen[element] = true
+	}
+
+	return ""
+}
+
+// comparableKey returns a map key for a value. The fields of an embedded struct whose
+// type isn't exported can't be turned back into an interface{}, so basic types are read
+// with the reflect methods instead.
+func comparableKey(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint()
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	case reflect.Bool:
+		return value.Bool()
+	}
+
+	return value.Interface()
+}
+
+func emailRule(value reflect.Value, param string) string {
+	if value.Kind() != reflect.String {
+		panic(fmt.Sprintf("validator: email can't be used on a %s", value.Type()))
+	}
+
+	if !Matches(value.String(), EmailRX) {
+		return "must be a valid email address"
+	}
+
+	return ""
+}
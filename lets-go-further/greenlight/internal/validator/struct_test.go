@@ -0,0 +1,268 @@
+package validator
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com.go-learning.greenlight/internal/assert"
+)
+
+func init() {
+	RegisterRule("lowercase", func(value reflect.Value, param string) string {
+		if value.String() != strings.ToLower(value.String()) {
+			return "must be lowercase"
+		}
+		return ""
+	})
+}
+
+type testCast struct {
+	Name string `json:"name" validate:"required,max=10"`
+	Role string `json:"role,omitempty" validate:"oneof=lead supporting"`
+}
+
+type testBase struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type testMovie struct {
+	testBase
+	Title    string     `json:"title" validate:"required,max=10"`
+	Year     int32      `json:"year" validate:"required,min=1888"`
+	Rating   *float64   `json:"rating" validate:"min=0,max=5"`
+	Genres   []string   `json:"genres" validate:"required,min=1,max=3,unique,dive,required,lowercase"`
+	Cast     []testCast `json:"cast"`
+	Director *testCast  `json:"director"`
+	Notes    string     `validate:"max=5"`
+	Ignored  string     `json:"ignored" validate:"-"`
+	internal string
+}
+
+func validMovie() testMovie {
+	return testMovie{
+		testBase: testBase{Email: "alice@example.com"},
+		Title:    "Casablanca",
+		Year:     1942,
+		Genres:   []string{"drama", "romance"},
+		Cast:     []testCast{{Name: "Bogart", Role: "lead"}},
+	}
+}
+
+func TestCheckStruct(t *testing.T) {
+	rating := func(r float64) *float64 { return &r }
+
+	tests := []struct {
+		name   string
+		change func(m *testMovie)
+		want   map[string]string
+	}{
+		{
+			name:   "Valid",
+			change: func(m *testMovie) {},
+			want:   map[string]string{},
+		},
+		{
+			name: "Required",
+			change: func(m *testMovie) {
+				m.Title = ""
+				m.Year = 0
+				m.Genres = nil
+			},
+			want: map[string]string{
+				"title":  "must be provided",
+				"year":   "must be provided",
+				"genres": "must be provided",
+			},
+		},
+		{
+			name: "Sizes",
+			change: func(m *testMovie) {
+				m.Title = "Casablanca!"
+				m.Year = 1800
+				m.Rating = rating(5.5)
+				m.Genres = []string{}
+				m.Notes = "Too long"
+			},
+			want: map[string]string{
+				"title":  "must not be more than 10 bytes long",
+				"year":   "must be at least 1888",
+				"rating": "must be a maximum of 5",
+				"genres": "must contain at least 1 item",
+				"Notes":  "must not be more than 5 bytes long",
+			},
+		},
+		{
+			name: "First error wins",
+			change: func(m *testMovie) {
+				m.Genres = []string{"drama", "drama", "war", "comedy"}
+			},
+			want: map[string]string{
+				"genres": "must not contain more than 3 items",
+			},
+		},
+		{
+			name: "Unique",
+			change: func(m *testMovie) {
+				m.Genres = []string{"drama", "drama"}
+			},
+			want: map[string]string{
+				"genres": "must not contain duplicate values",
+			},
+		},
+		{
+			name: "Elements",
+			change: func(m *testMovie) {
+				m.Genres = []string{"drama", "", "Romance"}
+			},
+			want: map[string]string{
+				"genres[1]": "must be provided",
+				"genres[2]": "must be lowercase",
+			},
+		},
+		{
+			name: "Nested structs",
+			change: func(m *testMovie) {
+				m.Cast = append(m.Cast, testCast{Role: "extra"})
+				m.Director = &testCast{Name: "Michael Curtiz", Role: "lead"}
+			},
+			want: map[string]string{
+				"cast[1].name":  "must be provided",
+				"cast[1].role":  "must be one of: lead, supporting",
+				"director.name": "must not be more than 10 bytes long",
+			},
+		},
+		{
+			name: "Embedded struct",
+			change: func(m *testMovie) {
+				m.Email = "not-an-email"
+			},
+			want: map[string]string{
+				"email": "must be a valid email address",
+			},
+		},
+		{
+			name: "Skipped fields",
+			change: func(m *testMovie) {
+				m.Ignored = strings.Repeat("x", 100)
+				m.internal = strings.Repeat("x", 100)
+			},
+			want: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := validMovie()
+			tt.change(&m)
+
+			v := New()
+			v.CheckStruct(&m)
+
+			assert.Equal(t, len(v.Errors), len(tt.want))
+			for key, message := range tt.want {
+				assert.Equal(t, v.Errors[key], message)
+			}
+		})
+	}
+}
+
+func TestCheckStructPointers(t *testing.T) {
+	var s struct {
+		Title *string `json:"title" validate:"required,max=5"`
+	}
+
+	v := New()
+	v.CheckStruct(s)
+	assert.Equal(t, v.Errors["title"], "must be provided")
+
+	// A non-nil pointer counts as provided, even when it points to a zero value.
+	empty := ""
+	s.Title = &empty
+
+	v = New()
+	v.CheckStruct(s)
+	assert.Equal(t, v.Valid(), true)
+
+	long := "Casablanca"
+	s.Title = &long
+
+	v = New()
+	v.CheckStruct(s)
+	assert.Equal(t, v.Errors["title"], "must not be more than 5 bytes long")
+}
+
+func TestCheckStructKeepsExistingErrors(t *testing.T) {
+	v := New()
+	v.AddError("title", "is already taken")
+
+	m := validMovie()
+	m.Title = ""
+	v.CheckStruct(m)
+
+	assert.Equal(t, v.Errors["title"], "is already taken")
+}
+
+func TestCheckStructUnknownRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for the unknown rule")
+		}
+	}()
+
+	var s struct {
+		Title string `validate:"required,shouting"`
+	}
+
+	New().CheckStruct(s)
+}
+
+func TestCheckStructMessages(t *testing.T) {
+	type testMessages struct {
+		Year    int32    `json:"year" validate:"required,msg=must be set,min=1888,msg=must be greater than 1888"`
+		Runtime *int32   `json:"runtime" validate:"required,msg=must be set"`
+		Genres  []string `json:"genres" validate:"max=2,dive,max=5,msg=must be short"`
+	}
+
+	tests := []struct {
+		name  string
+		value testMessages
+		want  map[string]string
+	}{
+		{"Missing", testMessages{}, map[string]string{"year": "must be set", "runtime": "must be set"}},
+		{"Too small", testMessages{Year: 1800, Runtime: new(int32)}, map[string]string{"year": "must be greater than 1888"}},
+		{"Rule without msg", testMessages{Year: 1942, Runtime: new(int32), Genres: []string{"a", "b", "c"}}, map[string]string{"genres": "must not contain more than 2 items"}},
+		{"Dive", testMessages{Year: 1942, Runtime: new(int32), Genres: []string{"romance"}}, map[string]string{"genres[0]": "must be short"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := New()
+			v.CheckStruct(tt.value)
+
+			assert.Equal(t, len(v.Errors), len(tt.want))
+			for key, message := range tt.want {
+				assert.Equal(t, v.Errors[key], message)
+			}
+		})
+	}
+}
+
+func TestParseTagInvalidMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  string
+	}{
+		{"First", "msg=must be set,required"},
+		{"First after dive", "required,dive,msg=must be set"},
+		{"Empty", "required,msg="},
+		{"Twice", "required,msg=must be set,msg=must be given"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseTag(tt.tag)
+			assert.Equal(t, err != nil, true)
+		})
+	}
+}
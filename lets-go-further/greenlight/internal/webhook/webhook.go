@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Define the names of the headers sent with every delivery. Receivers should check the
+// signature header before trusting the payload, and can use the delivery ID to ignore
+// a payload they have already seen (deliveries are retried, so may arrive twice).
+const (
+	SignatureHeader = "X-Greenlight-Signature"
+	EventHeader     = "X-Greenlight-Event"
+	DeliveryHeader  = "X-Greenlight-Delivery"
+)
+
+// Define a Sender struct which contains the HTTP client used to deliver payloads.
+type Sender struct {
+	client *http.Client
+}
+
+// New returns a Sender which gives up on a delivery attempt after the given timeout.
+func New(timeout time.Duration) Sender {
+	return Sender{
+		client: &http.Client{
+			Timeout: timeout,
+			// Don't follow redirects. A receiver which has moved should be updated by an
+			// admin, rather than us silently sending signed payloads somewhere else.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// NewSecret generates a random signing secret for a new webhook.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the value of the signature header for a payload. It takes the form
+// "t=<unix timestamp>,v1=<hex HMAC-SHA256>", where the HMAC is computed over the
+// timestamp, a "." and the raw request body. Including the timestamp lets receivers
+// reject old payloads which are being replayed.
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return fmt.Sprintf("t=%s,v1=%s", t, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Send POSTs a signed payload to url. It returns the response status code (or 0 if no
+// response was received), and an error unless the receiver replied with a 2xx status.
+func (s Sender) Send(url, secret, event string, deliveryID int64, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Greenlight-Webhooks/1.0")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(deliveryID, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, time.Now(), payload))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// Read (and discard) a limited amount of the body, so that the connection can be
+	// reused without letting a receiver keep us reading forever.
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver responded with status %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
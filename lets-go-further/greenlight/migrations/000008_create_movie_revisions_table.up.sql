@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    operation text NOT NULL,
+    snapshot jsonb NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (movie_id, version)
+);
+
+-- Record the current state of any existing movies as their first revision, so that
+-- every movie has a starting point to diff against
+INSERT INTO movie_revisions (movie_id, version, operation, snapshot)
+SELECT id, version, 'insert', json_build_object(
+    'title', title,
+    'year', year,
+    'runtime', runtime || ' mins',
+    'genres', genres,
+    'deleted', deleted_at IS NOT NULL
+)
+FROM movies;
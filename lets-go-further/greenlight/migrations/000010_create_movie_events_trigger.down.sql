@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS movies_notify_event ON movies;
+DROP FUNCTION IF EXISTS notify_movie_event();
+DROP SEQUENCE IF EXISTS movie_events_id_seq;
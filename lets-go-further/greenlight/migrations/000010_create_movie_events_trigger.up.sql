@@ -0,0 +1,58 @@
+-- Every change to a movie is broadcast on the movie_events channel, so that each API
+-- instance can push it to its Server-Sent Events clients. Event IDs come from a
+-- sequence, so they are the same on every instance that receives the notification.
+CREATE SEQUENCE IF NOT EXISTS movie_events_id_seq;
+
+CREATE OR REPLACE FUNCTION notify_movie_event() RETURNS trigger AS $$
+DECLARE
+    event text;
+    movie movies;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        event := 'movie.created';
+        movie := NEW;
+    ELSIF TG_OP = 'UPDATE' THEN
+        movie := NEW;
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+            event := 'movie.deleted';
+        ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+            -- A movie restored from the trash reappears, so is announced as created
+            event := 'movie.created';
+        ELSIF NEW.deleted_at IS NULL THEN
+            event := 'movie.updated';
+        ELSE
+            RETURN NULL;
+        END IF;
+    ELSE
+        -- Purging a movie which is already in the trash isn't news to anyone
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        event := 'movie.deleted';
+        movie := OLD;
+    END IF;
+
+    -- NOTIFY payloads are limited to 8000 bytes. ValidateMovie() limits the length of
+    -- the title and each genre, so that even the largest movie fits.
+    PERFORM pg_notify('movie_events', json_build_object(
+        'id', nextval('movie_events_id_seq'),
+        'event', event,
+        'movie', json_build_object(
+            'id', movie.id,
+            'created_at', movie.created_at,
+            'title', movie.title,
+            'year', movie.year,
+            'runtime', movie.runtime || ' mins',
+            'genres', movie.genres,
+            'version', movie.version,
+            'deleted_at', movie.deleted_at
+        )
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER movies_notify_event
+AFTER INSERT OR UPDATE OR DELETE ON movies
+FOR EACH ROW EXECUTE FUNCTION notify_movie_event();
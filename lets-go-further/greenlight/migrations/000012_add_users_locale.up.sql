@@ -0,0 +1,2 @@
+-- The locale is used to pick the language of the emails we send to the user
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale text NOT NULL DEFAULT 'en';
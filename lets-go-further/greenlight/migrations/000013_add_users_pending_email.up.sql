@@ -0,0 +1,3 @@
+-- A new email address is held here until the user confirms it with the token we send
+-- to that address
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email citext;
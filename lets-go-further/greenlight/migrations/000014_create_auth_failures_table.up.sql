@@ -0,0 +1,9 @@
+-- Failed sign in attempts are counted per account ("email:<address>") and per client IP
+-- address ("ip:<address>"), so that every API instance sees the same counts
+CREATE TABLE IF NOT EXISTS auth_failures (
+    key text PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    blocked_until timestamp(0) with time zone,
+    locked bool NOT NULL DEFAULT false
+);
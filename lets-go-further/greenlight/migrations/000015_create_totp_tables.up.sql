@@ -0,0 +1,17 @@
+-- A user's TOTP secret. It is stored when the user starts enrolling, but two-factor
+-- authentication is only turned on once they confirm it with a valid code
+CREATE TABLE IF NOT EXISTS users_totp (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    secret text NOT NULL,
+    enabled_at timestamp(0) with time zone,
+    last_used_step bigint
+);
+
+-- Hashed one-time recovery codes, for when the user has lost their authenticator
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    used_at timestamp(0) with time zone,
+    PRIMARY KEY (user_id, hash)
+);
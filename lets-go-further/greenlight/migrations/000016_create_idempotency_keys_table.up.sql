@@ -0,0 +1,18 @@
+-- The responses to POST requests sent with an Idempotency-Key header, so that a client
+-- retrying a request gets the original response rather than the request being carried
+-- out twice. Keys are scoped to the user who sent them. Anonymous requests (such as
+-- registering) are stored with a user_id of 0, under a hash of the key and the request,
+-- so that only a retry of the identical request matches. The status is NULL while the
+-- first request is still running
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id bigint NOT NULL,
+    key text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    request_hash bytea NOT NULL,
+    status integer,
+    header jsonb,
+    body bytea,
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
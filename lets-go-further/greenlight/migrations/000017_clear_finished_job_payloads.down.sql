@@ -0,0 +1 @@
+-- The cleared payloads can't be restored, so there is nothing to undo
@@ -0,0 +1,3 @@
+-- Job payloads can hold plaintext tokens, so they are cleared once a job has succeeded.
+-- Dead jobs keep theirs, so that they can be inspected and set back to pending.
+UPDATE jobs SET payload = '{}' WHERE status = 'succeeded';
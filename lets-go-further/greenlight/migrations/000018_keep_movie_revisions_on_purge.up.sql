@@ -0,0 +1,5 @@
+-- A movie's revisions are kept when it is purged, with a final 'purge' revision as a
+-- tombstone recording who purged it and when. The movie_id column is part of the
+-- primary key, so it can't be set to NULL, and instead the foreign key is dropped. Movie
+-- IDs are never reused, so the revisions still only ever belong to one movie
+ALTER TABLE movie_revisions DROP CONSTRAINT IF EXISTS movie_revisions_movie_id_fkey;